@@ -1,6 +1,7 @@
 package relayer
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/fiatjaf/relayer/storage"
@@ -8,35 +9,84 @@ import (
 )
 
 func AddEvent(relay Relay, evt nostr.Event) (accepted bool, message string) {
+	return AddEventContext(context.Background(), relay, evt)
+}
+
+// AddEventContext is like AddEvent, timing each stage in spans of the tracer
+// of ctx (see [ContextWithTracer]) and passing ctx on to a [ContextSaver] storage.
+// Events from connections of a [Server] with an [Admin] are checked against
+// its bans first.
+func AddEventContext(ctx context.Context, relay Relay, evt nostr.Event) (accepted bool, message string) {
 	store := relay.Storage()
-	advancedSaver, _ := store.(AdvancedSaver)
 
-	if !relay.AcceptEvent(&evt) {
+	if reason := adminFromContext(ctx).reject(&evt); reason != "" {
+		return false, reason
+	}
+
+	_, span := StartSpan(ctx, "AcceptEvent")
+	accept := relay.AcceptEvent(&evt)
+	span.End()
+	if !accept {
 		return false, "blocked: event blocked by relay"
 	}
 
-	if 20000 <= evt.Kind && evt.Kind < 30000 {
+	if isEphemeral(evt.Kind) {
 		// do not store ephemeral events
 	} else {
-		if advancedSaver != nil {
-			advancedSaver.BeforeSave(&evt)
-		}
-
-		if saveErr := store.SaveEvent(&evt); saveErr != nil {
+		if saveErr := saveEvent(ctx, store, &evt); saveErr != nil {
 			switch saveErr {
-			case storage.ErrDupEvent:
+			case storage.ErrDupEvent, storage.ErrNewerVersion:
 				return true, saveErr.Error()
 			default:
 				return false, fmt.Sprintf("error: failed to save: %s", saveErr.Error())
 			}
 		}
-
-		if advancedSaver != nil {
-			advancedSaver.AfterSave(&evt)
-		}
 	}
 
-	notifyListeners(&evt)
+	// the event is accepted even if it couldn't reach other instances,
+	// as it has been saved already and reached local subscribers
+	_, span = StartSpan(ctx, "fanout")
+	publish(relay, &evt)
+	span.End()
 
 	return true, ""
 }
+
+// saveEvent passes evt on to store, calling the AdvancedSaver hooks
+// around [Storage.SaveEvent] if store implements them.
+func saveEvent(ctx context.Context, store Storage, evt *nostr.Event) error {
+	advancedSaver, _ := store.(AdvancedSaver)
+
+	if advancedSaver != nil {
+		_, span := StartSpan(ctx, "BeforeSave")
+		advancedSaver.BeforeSave(evt)
+		span.End()
+	}
+
+	saveCtx, span := StartSpan(ctx, "SaveEvent")
+	var err error
+	if cs, ok := store.(ContextSaver); ok {
+		err = cs.SaveEventContext(saveCtx, evt)
+	} else {
+		err = store.SaveEvent(evt)
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+	if err != nil {
+		return err
+	}
+
+	if advancedSaver != nil {
+		_, span := StartSpan(ctx, "AfterSave")
+		advancedSaver.AfterSave(evt)
+		span.End()
+	}
+
+	return nil
+}
+
+func isEphemeral(kind int) bool {
+	return 20000 <= kind && kind < 30000
+}
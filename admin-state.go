@@ -0,0 +1,76 @@
+package relayer
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// AdminState is what [Admin] changes at runtime.
+type AdminState struct {
+	BannedPubkeys  []string `json:"banned_pubkeys"`
+	AllowedPubkeys []string `json:"allowed_pubkeys"`
+	BannedIPs      []string `json:"banned_ips"`
+	BannedEvents   []string `json:"banned_events,omitempty"`
+	// AllowedKinds, if any, are the only kinds accepted.
+	AllowedKinds []int `json:"allowed_kinds,omitempty"`
+	// DisallowedKinds are never accepted.
+	DisallowedKinds []int `json:"disallowed_kinds,omitempty"`
+	// Reasons given when banning or allowing, by pubkey, IP or event id.
+	Reasons map[string]string `json:"reasons,omitempty"`
+
+	// these override the NIP-11 document of the relay
+	RelayName        string `json:"relay_name,omitempty"`
+	RelayDescription string `json:"relay_description,omitempty"`
+	RelayIcon        string `json:"relay_icon,omitempty"`
+}
+
+// StateStore persists the [AdminState] across restarts.
+type StateStore interface {
+	// LoadState returns the saved state, or nil if nothing was saved yet.
+	LoadState() (*AdminState, error)
+	// SaveState is called with the whole state after every change.
+	SaveState(*AdminState) error
+}
+
+// FileStateStore keeps the state as JSON in a file.
+type FileStateStore struct {
+	Path string
+}
+
+func (f FileStateStore) LoadState() (*AdminState, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var state AdminState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// SaveState writes to a temporary file first, so that the state
+// isn't lost if the process dies while writing.
+func (f FileStateStore) SaveState(state *AdminState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
@@ -0,0 +1,571 @@
+package relayer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/exp/slices"
+)
+
+// Admin is an HTTP API to manage a running relay. It lists connections and
+// their subscriptions, kicks connections, bans pubkeys and IPs, deletes events
+// and shows storage stats. Set it as [Server.Admin] before Start.
+//
+// Requests must carry a NIP-98 Authorization header signed by one of Pubkeys.
+// Banned pubkeys can't publish and banned IPs can't connect. Allowed pubkeys
+// are only kept for relays to use, in their AcceptEvent for instance.
+//
+// The same state can be managed through NIP-86, see [ManagementHandler].
+//
+// The API, under Path, is:
+//
+//	GET    /connections                list connections and their subscriptions
+//	DELETE /connections/{id}           close a connection
+//	GET    /state                      show banned and allowed pubkeys and IPs
+//	POST   /pubkeys/{pubkey}/ban       ban a pubkey, removing it from the allowed ones
+//	POST   /pubkeys/{pubkey}/allow     allow a pubkey, lifting any ban
+//	POST   /ips/{ip}/ban               ban an IP, closing its connections
+//	                                   (ban and allow take an optional reason parameter)
+//	POST   /ips/{ip}/allow             lift the ban of an IP
+//	DELETE /events/{id}                delete an event
+//	DELETE /events?author={pubkey}     delete all events of a pubkey
+//	GET    /storage                    show the storage type, health and stats
+type Admin struct {
+	// Pubkeys allowed to use the API, in hex.
+	Pubkeys []string
+
+	// Path the API is served under, "/admin" by default.
+	Path string
+
+	// Store persists changes. The state only lives in memory if nil.
+	Store StateStore
+
+	server *Server
+
+	mu sync.RWMutex
+	// banned and allowed things, with the reason given, if any
+	bannedPubkeys   map[string]string
+	allowedPubkeys  map[string]string
+	bannedIPs       map[string]string
+	bannedEvents    map[string]string
+	allowedKinds    map[int]struct{}
+	disallowedKinds map[int]struct{}
+	relayName       string
+	relayDesc       string
+	relayIcon       string
+}
+
+// mount loads the saved state and serves the API on the router of s.
+func (a *Admin) mount(s *Server) error {
+	a.server = s
+	a.bannedPubkeys = make(map[string]string)
+	a.allowedPubkeys = make(map[string]string)
+	a.bannedIPs = make(map[string]string)
+	a.bannedEvents = make(map[string]string)
+	a.allowedKinds = make(map[int]struct{})
+	a.disallowedKinds = make(map[int]struct{})
+	if a.Store != nil {
+		state, err := a.Store.LoadState()
+		if err != nil {
+			return fmt.Errorf("failed to load state: %w", err)
+		}
+		if state != nil {
+			addAll(a.bannedPubkeys, state.BannedPubkeys, state.Reasons)
+			addAll(a.allowedPubkeys, state.AllowedPubkeys, state.Reasons)
+			addAll(a.bannedIPs, state.BannedIPs, state.Reasons)
+			addAll(a.bannedEvents, state.BannedEvents, state.Reasons)
+			for _, kind := range state.AllowedKinds {
+				a.allowedKinds[kind] = struct{}{}
+			}
+			for _, kind := range state.DisallowedKinds {
+				a.disallowedKinds[kind] = struct{}{}
+			}
+			a.relayName = state.RelayName
+			a.relayDesc = state.RelayDescription
+			a.relayIcon = state.RelayIcon
+		}
+	}
+
+	path := a.Path
+	if path == "" {
+		path = "/admin"
+	}
+	r := s.router.PathPrefix(path).Subrouter()
+	r.Use(a.authenticate)
+	r.Path("/connections").Methods("GET").HandlerFunc(a.handleConnections)
+	r.Path("/connections/{id}").Methods("DELETE").HandlerFunc(a.handleKick)
+	r.Path("/state").Methods("GET").HandlerFunc(a.handleState)
+	r.Path("/pubkeys/{pubkey}/{action:ban|allow}").Methods("POST").HandlerFunc(a.handlePubkey)
+	r.Path("/ips/{ip}/{action:ban|allow}").Methods("POST").HandlerFunc(a.handleIP)
+	r.Path("/events/{id}").Methods("DELETE").HandlerFunc(a.handleDeleteEvent)
+	r.Path("/events").Methods("DELETE").Queries("author", "{author}").HandlerFunc(a.handleDeleteAuthor)
+	r.Path("/storage").Methods("GET").HandlerFunc(a.handleStorage)
+
+	// NIP-86 is served on the relay URL
+	s.router.Path("/").Methods("POST").
+		HeadersRegexp("Content-Type", "^application/nostr\\+json\\+rpc").
+		Handler(NIP98Auth{
+			TrustedProxies: s.TrustedProxies,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(nip86Response{Error: "unauthorized: " + err.Error()})
+			},
+		}.Middleware(http.HandlerFunc(a.handleNIP86)))
+	return nil
+}
+
+// IsBanned reports whether pubkey was banned.
+func (a *Admin) IsBanned(pubkey string) bool { return has(a, a.bannedPubkeys, pubkey) }
+
+// IsAllowed reports whether pubkey was allowed.
+func (a *Admin) IsAllowed(pubkey string) bool { return has(a, a.allowedPubkeys, pubkey) }
+
+// IsIPBanned reports whether ip was banned.
+func (a *Admin) IsIPBanned(ip string) bool { return has(a, a.bannedIPs, ip) }
+
+// IsEventBanned reports whether the event with the given id was banned.
+func (a *Admin) IsEventBanned(id string) bool { return has(a, a.bannedEvents, id) }
+
+// IsKindAllowed reports whether events of kind are accepted: it wasn't
+// disallowed, and either it was allowed or no kind was, all of them but the
+// disallowed ones being accepted then.
+func (a *Admin) IsKindAllowed(kind int) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if _, ok := a.disallowedKinds[kind]; ok {
+		return false
+	}
+	_, ok := a.allowedKinds[kind]
+	return ok || len(a.allowedKinds) == 0
+}
+
+// AllowKind allows events of kind, making the allowed kinds the only ones
+// accepted.
+func (a *Admin) AllowKind(kind int) error {
+	return a.update(func() {
+		a.allowedKinds[kind] = struct{}{}
+		delete(a.disallowedKinds, kind)
+	})
+}
+
+// DisallowKind rejects events of kind, removing it from the allowed ones.
+func (a *Admin) DisallowKind(kind int) error {
+	return a.update(func() {
+		a.disallowedKinds[kind] = struct{}{}
+		delete(a.allowedKinds, kind)
+	})
+}
+
+// BanPubkey bans pubkey, removing it from the allowed ones.
+func (a *Admin) BanPubkey(pubkey, reason string) error {
+	return a.update(func() {
+		a.bannedPubkeys[pubkey] = reason
+		delete(a.allowedPubkeys, pubkey)
+	})
+}
+
+// AllowPubkey allows pubkey, lifting any ban.
+func (a *Admin) AllowPubkey(pubkey, reason string) error {
+	return a.update(func() {
+		a.allowedPubkeys[pubkey] = reason
+		delete(a.bannedPubkeys, pubkey)
+	})
+}
+
+// BanIP bans ip and closes its connections.
+func (a *Admin) BanIP(ip, reason string) error {
+	if err := a.update(func() { a.bannedIPs[ip] = reason }); err != nil {
+		return err
+	}
+	a.server.closeConnections(func(ws *WebSocket) bool { return ws.ip == ip })
+	return nil
+}
+
+// AllowIP lifts the ban of ip.
+func (a *Admin) AllowIP(ip string) error {
+	return a.update(func() { delete(a.bannedIPs, ip) })
+}
+
+// State returns the current state.
+func (a *Admin) State() AdminState {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.state()
+}
+
+func (a *Admin) state() AdminState {
+	state := AdminState{
+		BannedPubkeys:    sortedKeys(a.bannedPubkeys),
+		AllowedPubkeys:   sortedKeys(a.allowedPubkeys),
+		BannedIPs:        sortedKeys(a.bannedIPs),
+		BannedEvents:     sortedKeys(a.bannedEvents),
+		RelayName:        a.relayName,
+		RelayDescription: a.relayDesc,
+		RelayIcon:        a.relayIcon,
+		Reasons:          make(map[string]string),
+	}
+	for kind := range a.allowedKinds {
+		state.AllowedKinds = append(state.AllowedKinds, kind)
+	}
+	sort.Ints(state.AllowedKinds)
+	for kind := range a.disallowedKinds {
+		state.DisallowedKinds = append(state.DisallowedKinds, kind)
+	}
+	sort.Ints(state.DisallowedKinds)
+	for _, set := range []map[string]string{a.bannedPubkeys, a.allowedPubkeys, a.bannedIPs, a.bannedEvents} {
+		for key, reason := range set {
+			if reason != "" {
+				state.Reasons[key] = reason
+			}
+		}
+	}
+	return state
+}
+
+func has[V any](a *Admin, set map[string]V, key string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	_, ok := set[key]
+	return ok
+}
+
+// update applies change and saves the resulting state.
+func (a *Admin) update(change func()) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	change()
+	if a.Store == nil {
+		return nil
+	}
+	state := a.state()
+	if err := a.Store.SaveState(&state); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
+
+// reject returns why evt must be rejected, if it must.
+func (a *Admin) reject(evt *nostr.Event) string {
+	switch {
+	case a == nil:
+		return ""
+	case a.IsBanned(evt.PubKey):
+		return "blocked: pubkey is banned"
+	case a.IsEventBanned(evt.ID):
+		return "blocked: event is banned"
+	case !a.IsKindAllowed(evt.Kind):
+		return "blocked: kind is not allowed"
+	}
+	return ""
+}
+
+// hidden reports whether evt mustn't be returned to clients.
+func (a *Admin) hidden(evt *nostr.Event) bool {
+	return a != nil && (a.IsBanned(evt.PubKey) || a.IsEventBanned(evt.ID))
+}
+
+// info applies the relay name, description and icon set through NIP-86.
+func (a *Admin) info(doc *nip11Document) {
+	if a == nil {
+		return
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.relayName != "" {
+		doc.Name = a.relayName
+	}
+	if a.relayDesc != "" {
+		doc.Description = a.relayDesc
+	}
+	if a.relayIcon != "" {
+		doc.Icon = a.relayIcon
+	}
+}
+
+type adminContextKey struct{}
+
+// withAdmin returns a copy of ctx carrying a, for AddEventContext to
+// enforce its bans.
+func withAdmin(ctx context.Context, a *Admin) context.Context {
+	return context.WithValue(ctx, adminContextKey{}, a)
+}
+
+func adminFromContext(ctx context.Context) *Admin {
+	a, _ := ctx.Value(adminContextKey{}).(*Admin)
+	return a
+}
+
+// authenticate only lets requests signed by one of Pubkeys through.
+func (a *Admin) authenticate(next http.Handler) http.Handler {
+	auth := NIP98Auth{
+		TrustedProxies: a.server.TrustedProxies,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			writeJSONError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized: %w", err))
+		},
+	}
+	return auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(a.Pubkeys, NIP98Pubkey(r.Context())) {
+			writeJSONError(w, http.StatusForbidden, errors.New("not an admin"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// adminConnection describes a connection in /connections.
+type adminConnection struct {
+	ID            string                   `json:"id"`
+	IP            string                   `json:"ip"`
+	Pubkey        string                   `json:"pubkey,omitempty"`
+	ConnectedAt   int64                    `json:"connected_at"`
+	Subscriptions map[string]nostr.Filters `json:"subscriptions"`
+}
+
+func (a *Admin) handleConnections(w http.ResponseWriter, r *http.Request) {
+	s := a.server
+	s.clientsMu.Lock()
+	conns := make([]adminConnection, 0, len(s.clients))
+	sockets := make([]*WebSocket, 0, len(s.clients))
+	for _, ws := range s.clients {
+		sockets = append(sockets, ws)
+	}
+	s.clientsMu.Unlock()
+
+	listenersMutex.Lock()
+	for _, ws := range sockets {
+		conn := adminConnection{
+			ID:            ws.id,
+			IP:            ws.ip,
+			Pubkey:        ws.authed,
+			ConnectedAt:   ws.connectedAt.Unix(),
+			Subscriptions: make(map[string]nostr.Filters),
+		}
+		for id, listener := range listeners[ws] {
+			conn.Subscriptions[id] = listener.filters
+		}
+		conns = append(conns, conn)
+	}
+	listenersMutex.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ConnectedAt < conns[j].ConnectedAt })
+	writeJSON(w, http.StatusOK, conns)
+}
+
+func (a *Admin) handleKick(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if n := a.server.closeConnections(func(ws *WebSocket) bool { return ws.id == id }); n == 0 {
+		writeJSONError(w, http.StatusNotFound, errors.New("no such connection"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"closed": id})
+}
+
+func (a *Admin) handleState(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.State())
+}
+
+func (a *Admin) handlePubkey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pubkey := vars["pubkey"]
+	if !isHex64(pubkey) {
+		writeJSONError(w, http.StatusBadRequest, errors.New("invalid pubkey"))
+		return
+	}
+	change := a.BanPubkey
+	if vars["action"] == "allow" {
+		change = a.AllowPubkey
+	}
+	if err := change(pubkey, r.URL.Query().Get("reason")); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.State())
+}
+
+func (a *Admin) handleIP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ip := net.ParseIP(vars["ip"])
+	if ip == nil {
+		writeJSONError(w, http.StatusBadRequest, errors.New("invalid ip"))
+		return
+	}
+	var err error
+	if vars["action"] == "allow" {
+		err = a.AllowIP(ip.String())
+	} else {
+		err = a.BanIP(ip.String(), r.URL.Query().Get("reason"))
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.State())
+}
+
+func (a *Admin) handleDeleteEvent(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	store := a.server.relay.Storage()
+	events, err := store.QueryEvents(&nostr.Filter{IDs: []string{id}})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	deleted := 0
+	for _, evt := range events {
+		if evt.ID != id {
+			continue
+		}
+		if err := adminDelete(r.Context(), store, evt); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		deleted++
+	}
+	if deleted == 0 {
+		writeJSONError(w, http.StatusNotFound, errors.New("no such event"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+}
+
+func (a *Admin) handleDeleteAuthor(w http.ResponseWriter, r *http.Request) {
+	author := mux.Vars(r)["author"]
+	if !isHex64(author) {
+		writeJSONError(w, http.StatusBadRequest, errors.New("invalid pubkey"))
+		return
+	}
+	store := a.server.relay.Storage()
+	deleted := 0
+	for {
+		events, err := store.QueryEvents(&nostr.Filter{Authors: []string{author}, Limit: 500})
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if len(events) == 0 {
+			break
+		}
+		for _, evt := range events {
+			if err := adminDelete(r.Context(), store, evt); err != nil {
+				writeJSONError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		deleted += len(events)
+		if remaining, _ := store.QueryEvents(&nostr.Filter{IDs: []string{events[0].ID}}); len(remaining) > 0 {
+			// the storage didn't delete them, don't loop forever
+			writeJSONError(w, http.StatusInternalServerError, errors.New("storage didn't delete events"))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+}
+
+// adminDelete deletes evt, calling the AdvancedDeleter hooks if any.
+func adminDelete(ctx context.Context, store Storage, evt nostr.Event) error {
+	advancedDeleter, _ := store.(AdvancedDeleter)
+	if advancedDeleter != nil {
+		advancedDeleter.BeforeDelete(evt.ID, evt.PubKey)
+	}
+	if err := deleteEvent(ctx, store, evt.ID, evt.PubKey); err != nil {
+		return err
+	}
+	if advancedDeleter != nil {
+		advancedDeleter.AfterDelete(evt.ID, evt.PubKey)
+	}
+	return nil
+}
+
+// adminStorage describes the storage in /storage.
+type adminStorage struct {
+	Type   string `json:"type"`
+	Health string `json:"health,omitempty"`
+	// Stats is what the storage Stats method, if any, returns.
+	Stats any `json:"stats,omitempty"`
+}
+
+func (a *Admin) handleStorage(w http.ResponseWriter, r *http.Request) {
+	store := a.server.relay.Storage()
+	info := adminStorage{Type: fmt.Sprintf("%T", store)}
+	if checker, ok := store.(HealthChecker); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+		info.Health = "ok"
+		if err := checker.CheckHealth(ctx); err != nil {
+			info.Health = err.Error()
+		}
+	}
+	// storages have Stats methods returning different types
+	if stats := reflect.ValueOf(store).MethodByName("Stats"); stats.IsValid() &&
+		stats.Type().NumIn() == 0 && stats.Type().NumOut() == 1 {
+		info.Stats = stats.Call(nil)[0].Interface()
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// closeConnections closes the connections for which match returns true,
+// returning how many were closed.
+func (s *Server) closeConnections(match func(*WebSocket) bool) int {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	n := 0
+	for conn, ws := range s.clients {
+		if match(ws) {
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "closed by admin"),
+				time.Now().Add(time.Second))
+			conn.Close()
+			n++
+		}
+	}
+	return n
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func addAll(set map[string]string, keys []string, reasons map[string]string) {
+	for _, key := range keys {
+		set[key] = reasons[key]
+	}
+}
+
+func sortedKeys(set map[string]string) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isHex64(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package relayer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer/storage/memory"
+	"github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestAdmin(t *testing.T) {
+	adminKey := nostr.GeneratePrivateKey()
+	adminPubkey, _ := nostr.GetPublicKey(adminKey)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	rl := &testRelay{storage: &memory.MemoryBackend{}}
+	srv := NewServer("127.0.0.1:0", rl)
+	srv.Admin = &Admin{Pubkeys: []string{adminPubkey}, Store: FileStateStore{Path: statePath}}
+	ready := make(chan struct{})
+	rl.onInitialized = func(*Server) { close(ready) }
+	go srv.Start()
+	<-ready
+	defer srv.Shutdown(context.Background())
+
+	base := "http://" + srv.Addr() + "/admin"
+	call := func(sk, method, path string, v any) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, base+path, nil)
+		if sk != "" {
+			req.Header.Set("Authorization", nip98Header(sk, method, base+path, nil))
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if v != nil {
+			json.NewDecoder(res.Body).Decode(v)
+		}
+		return res.StatusCode
+	}
+
+	var unauthorized struct{ Error string }
+	if code := call("", "GET", "/state", &unauthorized); code != http.StatusUnauthorized || !strings.HasPrefix(unauthorized.Error, "unauthorized: ") {
+		t.Errorf("GET /state without auth = %d %+v", code, unauthorized)
+	}
+	if code := call(nostr.GeneratePrivateKey(), "GET", "/state", nil); code != http.StatusForbidden {
+		t.Errorf("GET /state by someone else = %d", code)
+	}
+
+	// events of a banned pubkey are rejected
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	evt := nostr.Event{PubKey: pk, Kind: 1, CreatedAt: time.Now(), Tags: nostr.Tags{}}
+	evt.Sign(sk)
+	if ok, msg := publishEvent(t, srv.Addr(), evt); !ok {
+		t.Fatalf("event not accepted: %s", msg)
+	}
+	var state AdminState
+	if code := call(adminKey, "POST", "/pubkeys/"+pk+"/ban", &state); code != http.StatusOK || len(state.BannedPubkeys) != 1 {
+		t.Fatalf("ban = %d %+v", code, state)
+	}
+	evt.CreatedAt = evt.CreatedAt.Add(time.Second)
+	evt.Sign(sk)
+	if ok, msg := publishEvent(t, srv.Addr(), evt); ok || msg != "blocked: pubkey is banned" {
+		t.Errorf("event of banned pubkey: %v %q", ok, msg)
+	}
+	if saved, _ := (FileStateStore{Path: statePath}).LoadState(); saved == nil || len(saved.BannedPubkeys) != 1 {
+		t.Errorf("saved state = %+v", saved)
+	}
+	call(adminKey, "POST", "/pubkeys/"+pk+"/allow", &state)
+	if len(state.BannedPubkeys) != 0 || !srv.Admin.IsAllowed(pk) {
+		t.Errorf("state after allow = %+v", state)
+	}
+
+	// events of a pubkey are deleted
+	var deleted map[string]int
+	if code := call(adminKey, "DELETE", "/events?author="+pk, &deleted); code != http.StatusOK || deleted["deleted"] != 1 {
+		t.Errorf("delete by author = %d %v", code, deleted)
+	}
+	if events, _ := rl.storage.QueryEvents(&nostr.Filter{Authors: []string{pk}}); len(events) != 0 {
+		t.Errorf("%d events left", len(events))
+	}
+	if code := call(adminKey, "DELETE", "/events/"+evt.ID, nil); code != http.StatusNotFound {
+		t.Errorf("delete missing event = %d", code)
+	}
+
+	// connections are listed with their subscriptions, and kicked
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteJSON([]any{"REQ", "mysub", nostr.Filter{Kinds: []int{1}}})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var eose []any
+	conn.ReadJSON(&eose)
+
+	var conns []adminConnection
+	call(adminKey, "GET", "/connections", &conns)
+	var subscribed *adminConnection
+	for i, c := range conns {
+		if c.Subscriptions["mysub"] != nil {
+			subscribed = &conns[i]
+		}
+	}
+	if subscribed == nil || subscribed.IP != "127.0.0.1" {
+		t.Fatalf("connections = %+v", conns)
+	}
+	if code := call(adminKey, "DELETE", "/connections/"+subscribed.ID, nil); code != http.StatusOK {
+		t.Errorf("kick = %d", code)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("connection not closed by admin: %v", err)
+	}
+
+	// banned IPs can't connect
+	call(adminKey, "POST", "/ips/127.0.0.1/ban", nil)
+	if _, res, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr(), nil); err == nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("banned IP connected: %v", err)
+	}
+
+	var storage adminStorage
+	call(adminKey, "GET", "/storage", &storage)
+	if storage.Type != "*memory.MemoryBackend" {
+		t.Errorf("storage = %+v", storage)
+	}
+}
+
+func TestAdminKinds(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	a := &Admin{Store: FileStateStore{Path: statePath}}
+	if err := a.mount(NewServer("127.0.0.1:0", &testRelay{})); err != nil {
+		t.Fatal(err)
+	}
+
+	// disallowing a kind when all of them are accepted
+	a.DisallowKind(1)
+	if a.IsKindAllowed(1) || !a.IsKindAllowed(7) {
+		t.Errorf("disallowed kind 1 with none allowed: 1 %v, 7 %v", a.IsKindAllowed(1), a.IsKindAllowed(7))
+	}
+
+	// disallowing a kind after allowing it
+	a.AllowKind(1)
+	a.AllowKind(7)
+	if !a.IsKindAllowed(1) || a.IsKindAllowed(30023) {
+		t.Errorf("allowed kinds 1 and 7: 1 %v, 30023 %v", a.IsKindAllowed(1), a.IsKindAllowed(30023))
+	}
+	a.DisallowKind(1)
+	if a.IsKindAllowed(1) || !a.IsKindAllowed(7) || a.IsKindAllowed(30023) {
+		t.Errorf("disallowed kind 1 after allowing it: 1 %v, 7 %v, 30023 %v", a.IsKindAllowed(1), a.IsKindAllowed(7), a.IsKindAllowed(30023))
+	}
+
+	// both survive restarts
+	reloaded := &Admin{Store: FileStateStore{Path: statePath}}
+	if err := reloaded.mount(NewServer("127.0.0.1:0", &testRelay{})); err != nil {
+		t.Fatal(err)
+	}
+	if state := reloaded.State(); !reflect.DeepEqual(state.AllowedKinds, []int{7}) || !reflect.DeepEqual(state.DisallowedKinds, []int{1}) {
+		t.Errorf("reloaded kinds: allowed %v, disallowed %v", state.AllowedKinds, state.DisallowedKinds)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// runCommand handles the "export" and "import" subcommands, which stream events
+// between the relay storage and newline-delimited JSON on stdout or stdin.
+func runCommand(r *Relay, args []string) error {
+	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+	since := fs.Int64("since", 0, "only events created after this unix timestamp")
+	until := fs.Int64("until", 0, "only events created before this unix timestamp")
+	kinds := fs.String("kinds", "", "comma-separated list of event kinds")
+	authors := fs.String("authors", "", "comma-separated list of author pubkeys")
+	fs.Parse(args[1:])
+
+	var filter nostr.Filter
+	if *since != 0 {
+		t := time.Unix(*since, 0)
+		filter.Since = &t
+	}
+	if *until != 0 {
+		t := time.Unix(*until, 0)
+		filter.Until = &t
+	}
+	if *kinds != "" {
+		for _, k := range strings.Split(*kinds, ",") {
+			kind, err := strconv.Atoi(strings.TrimSpace(k))
+			if err != nil {
+				return fmt.Errorf("invalid kind %q", k)
+			}
+			filter.Kinds = append(filter.Kinds, kind)
+		}
+	}
+	if *authors != "" {
+		for _, a := range strings.Split(*authors, ",") {
+			filter.Authors = append(filter.Authors, strings.TrimSpace(a))
+		}
+	}
+
+	if err := r.storage.Init(); err != nil {
+		return fmt.Errorf("storage init: %w", err)
+	}
+
+	switch args[0] {
+	case "export":
+		n, err := relayer.Export(r.storage, os.Stdout, filter, func(n int) {
+			log.Printf("exported %d events", n)
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("done: exported %d events", n)
+	case "import":
+		stats, err := relayer.Import(r.storage, os.Stdin, &filter, func(stats relayer.ImportStats) {
+			log.Print(stats)
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("done: %s", stats)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	return nil
+}
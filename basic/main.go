@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/fiatjaf/relayer"
@@ -13,7 +14,9 @@ import (
 )
 
 type Relay struct {
-	PostgresDatabase string `envconfig:"POSTGRESQL_DATABASE"`
+	PostgresDatabase  string `envconfig:"POSTGRESQL_DATABASE"`
+	PostgresNotify    bool   `envconfig:"POSTGRESQL_NOTIFY"`
+	PostgresBatchSize int    `envconfig:"POSTGRESQL_BATCH_SIZE"`
 
 	storage *postgresql.PostgresBackend
 }
@@ -63,7 +66,17 @@ func main() {
 		log.Fatalf("failed to read from env: %v", err)
 		return
 	}
-	r.storage = &postgresql.PostgresBackend{DatabaseURL: r.PostgresDatabase}
+	r.storage = &postgresql.PostgresBackend{
+		DatabaseURL: r.PostgresDatabase,
+		Notify:      r.PostgresNotify,
+		BatchSize:   r.PostgresBatchSize,
+	}
+	if len(os.Args) > 1 {
+		if err := runCommand(&r, os.Args[1:]); err != nil {
+			log.Fatalf("%s: %v", os.Args[1], err)
+		}
+		return
+	}
 	if err := relayer.Start(&r); err != nil {
 		log.Fatalf("server terminated: %v", err)
 	}
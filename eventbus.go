@@ -0,0 +1,72 @@
+package relayer
+
+import (
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// EventBus carries events between the instances of a relay, so that events
+// added through any of them reach the subscribers of all of them.
+//
+// Events accepted by [AddEvent] or pushed by an [Injector] are published to
+// the bus of the relay, if it is an [EventBusProvider], and the server sends
+// events it receives from the bus to its subscribers. Without a bus, events
+// only reach the subscribers of the process they were added through,
+// as with [LocalEventBus].
+type EventBus interface {
+	// Publish sends evt to the handlers of every instance, this one included.
+	// Implementations should deliver to local handlers even if the event
+	// couldn't be sent to other instances, returning the error.
+	Publish(evt *nostr.Event) error
+
+	// Subscribe registers a handler called for every event published,
+	// by any instance. It is called by [Server.Start].
+	Subscribe(handle func(*nostr.Event)) error
+}
+
+// EventBusProvider is implemented by relays running several instances,
+// to spread events to all of them. See [EventBus].
+type EventBusProvider interface {
+	EventBus() EventBus
+}
+
+// LocalEventBus is an [EventBus] delivering events within the process only.
+// It is the default.
+type LocalEventBus struct {
+	mu       sync.RWMutex
+	handlers []func(*nostr.Event)
+}
+
+func (b *LocalEventBus) Publish(evt *nostr.Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, handle := range b.handlers {
+		handle(evt)
+	}
+	return nil
+}
+
+func (b *LocalEventBus) Subscribe(handle func(*nostr.Event)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handle)
+	return nil
+}
+
+// defaultEventBus is used by relays which aren't an EventBusProvider.
+var defaultEventBus = &LocalEventBus{handlers: []func(*nostr.Event){notifyListeners}}
+
+func eventBus(relay Relay) EventBus {
+	if p, ok := relay.(EventBusProvider); ok {
+		if bus := p.EventBus(); bus != nil {
+			return bus
+		}
+	}
+	return defaultEventBus
+}
+
+// publish sends evt to the subscribers of all instances of relay.
+func publish(relay Relay, evt *nostr.Event) error {
+	return eventBus(relay).Publish(evt)
+}
@@ -0,0 +1,333 @@
+// Package nats implements a [relayer.EventBus] over a NATS server, speaking
+// the NATS client protocol, so that several instances of a relay share their
+// subscriptions fan-out.
+package nats
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const defaultSubject = "relayer.events"
+
+// ErrNotConnected is returned by Publish when the connection to the server is
+// down. The event is still delivered to the handlers of this instance.
+var ErrNotConnected = errors.New("not connected to nats server")
+
+type NATSBus struct {
+	// URL of the NATS server, such as nats://localhost:4222. Credentials
+	// are taken from it, either as user:password or as a single token.
+	// Use the tls scheme for TLS connections.
+	URL string
+
+	// Subject events are published on, "relayer.events" by default.
+	Subject string
+
+	// InstanceID identifies this instance in published messages, so that
+	// it ignores its own events coming back. It is random by default.
+	InstanceID string
+
+	// Log receives connection errors. It defaults to the standard logger.
+	Log relayer.Logger
+
+	// WriteTimeout bounds writes to the server, 5 seconds by default, so
+	// that a stalled connection doesn't hold Publish up: it is dropped and
+	// reestablished instead.
+	WriteTimeout time.Duration
+
+	mu       sync.Mutex
+	conn     net.Conn
+	w        *bufio.Writer
+	handlers []func(*nostr.Event)
+	started  bool
+	closed   bool
+}
+
+// message is what goes through the NATS server.
+type message struct {
+	Instance string       `json:"instance"`
+	Event    *nostr.Event `json:"event"`
+}
+
+// Publish delivers evt to the handlers of this instance
+// and sends it to the other ones.
+func (b *NATSBus) Publish(evt *nostr.Event) error {
+	b.deliver(evt)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return ErrNotConnected
+	}
+
+	data, err := json.Marshal(message{Instance: b.InstanceID, Event: evt})
+	if err != nil {
+		return err
+	}
+	return b.write(func(w *bufio.Writer) {
+		fmt.Fprintf(w, "PUB %s %d\r\n", b.Subject, len(data))
+		w.Write(data)
+		w.WriteString("\r\n")
+	})
+}
+
+// write sends what fn writes to the server, within WriteTimeout. The
+// connection is closed if it fails, for run to reconnect. b.mu must be held.
+func (b *NATSBus) write(fn func(w *bufio.Writer)) error {
+	timeout := b.WriteTimeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	b.conn.SetWriteDeadline(time.Now().Add(timeout))
+	fn(b.w)
+	err := b.w.Flush()
+	if err != nil {
+		b.conn.Close()
+		b.conn = nil
+		return err
+	}
+	b.conn.SetWriteDeadline(time.Time{})
+	return nil
+}
+
+// Subscribe adds a handler for events published by any instance. The first
+// call connects to the server, failing if it can't. The connection is then
+// reestablished whenever it is lost, events published meanwhile being missed.
+func (b *NATSBus) Subscribe(handle func(*nostr.Event)) error {
+	b.mu.Lock()
+	b.handlers = append(b.handlers, handle)
+	started := b.started
+	b.started = true
+	b.mu.Unlock()
+
+	if started {
+		return nil
+	}
+
+	if b.Subject == "" {
+		b.Subject = defaultSubject
+	}
+	if b.InstanceID == "" {
+		var id [8]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			return err
+		}
+		b.InstanceID = hex.EncodeToString(id[:])
+	}
+
+	conn, r, err := b.connect()
+	if err != nil {
+		b.mu.Lock()
+		b.started = false
+		b.mu.Unlock()
+		return err
+	}
+	go b.run(conn, r)
+	return nil
+}
+
+// Close disconnects from the server for good.
+func (b *NATSBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.Close()
+	b.conn = nil
+	return err
+}
+
+func (b *NATSBus) deliver(evt *nostr.Event) {
+	b.mu.Lock()
+	handlers := b.handlers
+	b.mu.Unlock()
+	for _, handle := range handlers {
+		handle(evt)
+	}
+}
+
+// connect dials the server and subscribes to the subject.
+func (b *NATSBus) connect() (net.Conn, *bufio.Reader, error) {
+	u, err := url.Parse(b.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid nats url: %w", err)
+	}
+
+	var conn net.Conn
+	if u.Scheme == "tls" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", u.Host,
+			&tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = net.DialTimeout("tcp", u.Host, 10*time.Second)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	options := map[string]any{"verbose": false, "pedantic": false, "name": "relayer"}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			options["user"] = u.User.Username()
+			options["pass"] = password
+		} else {
+			options["auth_token"] = u.User.Username()
+		}
+	}
+	connect, _ := json.Marshal(options)
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// the server greets with INFO, then answers our PING once it has
+	// processed CONNECT and SUB, or sends an error
+	if line, err := readLine(r); err != nil {
+		conn.Close()
+		return nil, nil, err
+	} else if !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return nil, nil, fmt.Errorf("unexpected greeting from nats server: %q", line)
+	}
+	fmt.Fprintf(w, "CONNECT %s\r\nSUB %s 1\r\nPING\r\n", connect, b.Subject)
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return nil, nil, fmt.Errorf("nats server: %s", line)
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		conn.Close()
+		return nil, nil, net.ErrClosed
+	}
+	b.conn = conn
+	b.w = w
+	return conn, r, nil
+}
+
+// run reads from the connection, reconnecting when it is lost, until Close.
+func (b *NATSBus) run(conn net.Conn, r *bufio.Reader) {
+	for {
+		err := b.read(r)
+
+		b.mu.Lock()
+		if b.conn == conn {
+			b.conn = nil
+		}
+		closed := b.closed
+		b.mu.Unlock()
+		conn.Close()
+		if closed {
+			return
+		}
+		b.logf("lost connection to nats server: %v", err)
+
+		delay := time.Second
+		for {
+			time.Sleep(delay)
+			conn, r, err = b.connect()
+			if err == nil {
+				break
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			b.logf("failed to reconnect to nats server: %v", err)
+			if delay < 30*time.Second {
+				delay *= 2
+			}
+		}
+	}
+}
+
+// read handles messages from the server until the connection fails.
+func (b *NATSBus) read(r *bufio.Reader) error {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case line == "PING":
+			b.mu.Lock()
+			if b.conn != nil {
+				b.write(func(w *bufio.Writer) { w.WriteString("PONG\r\n") })
+			}
+			b.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			b.logf("nats server: %s", line)
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(line)
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || size < 0 {
+				return fmt.Errorf("invalid message from nats server: %q", line)
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return err
+			}
+
+			var msg message
+			if err := json.Unmarshal(payload[:size], &msg); err != nil || msg.Event == nil {
+				b.logf("invalid event from nats server: %s", payload[:size])
+				continue
+			}
+			if msg.Instance == b.InstanceID {
+				// already delivered by Publish
+				continue
+			}
+			b.deliver(msg.Event)
+		}
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (b *NATSBus) logf(format string, v ...any) {
+	if b.Log != nil {
+		b.Log.Errorf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
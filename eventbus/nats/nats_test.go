@@ -0,0 +1,237 @@
+package nats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestNATSBus(t *testing.T) {
+	broker := startBroker(t)
+
+	a, gotA := connectBus(t, broker)
+	b, gotB := connectBus(t, broker)
+
+	evt := &nostr.Event{ID: "abc", Kind: 1, Tags: nostr.Tags{}}
+	if err := a.Publish(evt); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, gotA, "abc")
+	expect(t, gotB, "abc")
+
+	// a doesn't get its own event back from the broker
+	select {
+	case id := <-gotA:
+		t.Errorf("publisher got event %s twice", id)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// b keeps getting events once reconnected
+	broker.disconnectAll()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.Publish(&nostr.Event{ID: "def", Kind: 1, Tags: nostr.Tags{}})
+		select {
+		case id := <-gotA:
+			if id != "def" {
+				t.Fatalf("got event %s; want def", id)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+		for len(gotB) > 0 {
+			<-gotB
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("events not delivered after reconnection")
+		}
+	}
+}
+
+func TestNATSBusAuth(t *testing.T) {
+	broker := startBroker(t)
+	broker.token = "secret"
+
+	bus := &NATSBus{URL: "nats://wrong@" + broker.addr}
+	if err := bus.Subscribe(func(*nostr.Event) {}); err == nil {
+		t.Error("connected with the wrong token")
+	}
+
+	bus = &NATSBus{URL: "nats://secret@" + broker.addr}
+	if err := bus.Subscribe(func(*nostr.Event) {}); err != nil {
+		t.Errorf("failed to connect with the right token: %v", err)
+	}
+	bus.Close()
+}
+
+func TestNATSBusStalled(t *testing.T) {
+	broker := startBroker(t)
+	broker.stall = make(chan struct{})
+	t.Cleanup(func() { close(broker.stall) })
+
+	bus := &NATSBus{URL: "nats://" + broker.addr, WriteTimeout: 200 * time.Millisecond}
+	if err := bus.Subscribe(func(*nostr.Event) {}); err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+
+	// the broker doesn't read anymore, so the socket buffers fill up
+	evt := &nostr.Event{ID: "abc", Kind: 1, Tags: nostr.Tags{}, Content: strings.Repeat("a", 1<<20)}
+	for i := 0; i < 100; i++ {
+		start := time.Now()
+		err := bus.Publish(evt)
+		if took := time.Since(start); took > time.Second {
+			t.Fatalf("Publish took %s", took)
+		}
+		if err != nil {
+			return
+		}
+	}
+	t.Error("Publish never failed on a stalled connection")
+}
+
+func connectBus(t *testing.T, broker *broker) (*NATSBus, chan string) {
+	got := make(chan string, 10)
+	bus := &NATSBus{URL: "nats://" + broker.addr}
+	if err := bus.Subscribe(func(evt *nostr.Event) { got <- evt.ID }); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bus.Close() })
+	return bus, got
+}
+
+func expect(t *testing.T, got chan string, id string) {
+	t.Helper()
+	select {
+	case gotID := <-got:
+		if gotID != id {
+			t.Errorf("got event %s; want %s", gotID, id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("event %s not delivered", id)
+	}
+}
+
+// broker is a stand-in for a NATS server, handling the subset
+// of the protocol used by NATSBus.
+type broker struct {
+	addr  string
+	token string
+	// stall, if set, makes the broker stop reading once connected,
+	// until it is closed
+	stall chan struct{}
+
+	mu    sync.Mutex
+	conns map[net.Conn]*brokerConn
+}
+
+type brokerConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+	subs map[string]string // subject to sid
+}
+
+func (c *brokerConn) write(s string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	io.WriteString(c.conn, s)
+}
+
+func startBroker(t *testing.T) *broker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	br := &broker{addr: ln.Addr().String(), conns: make(map[net.Conn]*brokerConn)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go br.serve(conn)
+		}
+	}()
+	return br
+}
+
+func (br *broker) serve(conn net.Conn) {
+	c := &brokerConn{conn: conn, subs: make(map[string]string)}
+	br.mu.Lock()
+	br.conns[conn] = c
+	br.mu.Unlock()
+	defer func() {
+		br.mu.Lock()
+		delete(br.conns, conn)
+		br.mu.Unlock()
+		conn.Close()
+	}()
+
+	c.write("INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "CONNECT":
+			if br.token != "" && !strings.Contains(line, `"auth_token":"`+br.token+`"`) {
+				c.write("-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PING":
+			c.write("PONG\r\n")
+			if br.stall != nil {
+				<-br.stall
+				return
+			}
+		case "SUB":
+			c.mu.Lock()
+			c.subs[fields[1]] = fields[2]
+			c.mu.Unlock()
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			br.route(fields[1], payload[:size])
+		}
+	}
+}
+
+func (br *broker) route(subject string, payload []byte) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	for _, c := range br.conns {
+		c.mu.Lock()
+		sid, ok := c.subs[subject]
+		c.mu.Unlock()
+		if ok {
+			c.write(fmt.Sprintf("MSG %s %s %d\r\n%s\r\n", subject, sid, len(payload), payload))
+		}
+	}
+}
+
+func (br *broker) disconnectAll() {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	for conn := range br.conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,33 @@
+package relayer
+
+import (
+	"testing"
+
+	"github.com/fiatjaf/relayer/storage/memory"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestAddEventPublishes(t *testing.T) {
+	bus := &LocalEventBus{}
+	var published []string
+	bus.Subscribe(func(evt *nostr.Event) { published = append(published, evt.ID) })
+	rl := &testRelay{storage: &memory.MemoryBackend{}, bus: bus}
+
+	for _, evt := range []nostr.Event{
+		{ID: "a", Kind: 1, Tags: nostr.Tags{}},
+		{ID: "b", Kind: 20001, Tags: nostr.Tags{}},
+	} {
+		if ok, msg := AddEvent(rl, evt); !ok {
+			t.Fatalf("AddEvent(%s): %s", evt.ID, msg)
+		}
+	}
+	if len(published) != 2 || published[0] != "a" || published[1] != "b" {
+		t.Errorf("published %v; want [a b]", published)
+	}
+
+	rl.acceptEvent = func(*nostr.Event) bool { return false }
+	AddEvent(rl, nostr.Event{ID: "c", Kind: 1, Tags: nostr.Tags{}})
+	if len(published) != 2 {
+		t.Errorf("published rejected event: %v", published)
+	}
+}
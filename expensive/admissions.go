@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// admissions keeps who can write to the relay, and until when, along with
+// the invoices issued for plans and the zaps received. Pubkeys known to be
+// admitted are accepted without asking the payment provider.
+type admissions struct {
+	db       *sqlx.DB
+	provider PaymentProvider
+}
+
+// admission lets a pubkey write events of Kind, or any kind if allKinds,
+// until ExpiresAt, or for ever if 0.
+type admission struct {
+	Pubkey    string `json:"pubkey"`
+	Kind      int    `json:"kind"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+type invoice struct {
+	InvoiceID string `json:"invoice_id"`
+	Plan      string `json:"plan"`
+	Kind      int    `json:"kind"`
+	Days      int    `json:"days"`
+	Bolt11    string `json:"bolt11"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func newAdmissions(db *sqlx.DB, provider PaymentProvider) (*admissions, error) {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS admission (
+  pubkey text NOT NULL,
+  kind integer NOT NULL,
+  expires_at integer NOT NULL,
+  reminded_at integer NOT NULL DEFAULT 0,
+  PRIMARY KEY (pubkey, kind)
+);
+
+CREATE TABLE IF NOT EXISTS invoice (
+  invoice_id text NOT NULL PRIMARY KEY,
+  pubkey text NOT NULL,
+  plan text NOT NULL,
+  kind integer NOT NULL,
+  days integer NOT NULL,
+  bolt11 text NOT NULL,
+  expires_at integer NOT NULL,
+  paid_at integer NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS invoice_pubkey ON invoice (pubkey) WHERE paid_at = 0;
+
+CREATE TABLE IF NOT EXISTS credit (
+  payment_hash text NOT NULL PRIMARY KEY,
+  pubkey text NOT NULL,
+  msats bigint NOT NULL,
+  receipt_id text NOT NULL,
+  created_at integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS credit_pubkey ON credit (pubkey);
+
+CREATE TABLE IF NOT EXISTS legacy_import (
+  name text NOT NULL PRIMARY KEY,
+  imported_at integer NOT NULL
+);
+    `)
+	if err != nil {
+		return nil, err
+	}
+	a := &admissions{db: db, provider: provider}
+
+	// tickets paid before they were kept here were only known to CLN
+	if cln, ok := provider.(*clnProvider); ok {
+		go func() {
+			if err := a.importCLNTickets(context.Background(), cln); err != nil {
+				log.Printf("failed to import the tickets paid to CLN, trying again on the next start: %v", err)
+			}
+		}()
+	}
+
+	return a, nil
+}
+
+// importCLNTickets admits the pubkeys of the tickets paid to cln, once.
+func (a *admissions) importCLNTickets(ctx context.Context, cln *clnProvider) error {
+	var done bool
+	if err := a.db.Get(&done, `SELECT EXISTS (SELECT 1 FROM legacy_import WHERE name = 'cln_tickets')`); err != nil || done {
+		return err
+	}
+	pubkeys, err := cln.paidTickets(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := a.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, pubkey := range pubkeys {
+		if err := a.admit(tx, pubkey, allKinds, 0); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO legacy_import (name, imported_at) VALUES ('cln_tickets', $1)`,
+		time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// invoice returns an invoice for pubkey to pay for p, the same one until
+// it expires.
+func (a *admissions) invoice(ctx context.Context, pubkey string, p plan) (string, error) {
+	if !isPubkey(pubkey) {
+		return "", errors.New("invalid pubkey")
+	}
+	if p.Days == 0 && a.isAdmitted(ctx, pubkey, p.Kind) {
+		return "", errors.New("this pubkey is admitted for ever already")
+	}
+
+	var existing invoice
+	err := a.db.Get(&existing, `SELECT bolt11 FROM invoice
+		WHERE pubkey = $1 AND plan = $2 AND paid_at = 0 AND expires_at > $3`,
+		pubkey, p.Name, time.Now().Add(time.Minute).Unix())
+	if err == nil {
+		return existing.Bolt11, nil
+	} else if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	description := fmt.Sprintf("%s's ticket for writing to relayer-expensive", pubkey)
+	if p.Name != "lifetime" {
+		description = fmt.Sprintf("%s's %s admission to relayer-expensive", pubkey, p.Name)
+	}
+	inv, err := a.provider.CreateInvoice(ctx, p.Sats, description)
+	if err != nil {
+		return "", err
+	}
+	_, err = a.db.Exec(`INSERT INTO invoice (invoice_id, pubkey, plan, kind, days, bolt11, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		inv.ID, pubkey, p.Name, p.Kind, p.Days, inv.Bolt11, inv.ExpiresAt.Unix())
+	if err != nil {
+		return "", err
+	}
+	return inv.Bolt11, nil
+}
+
+// isAdmitted reports whether pubkey can write events of kind, or of some
+// kind if allKinds. Only pubkeys with an invoice pending are checked with
+// the provider, and only until their invoices expire.
+func (a *admissions) isAdmitted(ctx context.Context, pubkey string, kind int) bool {
+	admitted, err := a.admitted(pubkey, kind)
+	if err != nil {
+		log.Printf("failed to get admission of %s: %v", pubkey, err)
+		return false
+	}
+	if admitted {
+		return true
+	}
+
+	var pending []invoice
+	if err := a.db.Select(&pending, `SELECT invoice_id, plan, kind, days, bolt11, expires_at
+		FROM invoice WHERE pubkey = $1 AND paid_at = 0`, pubkey); err != nil {
+		log.Printf("failed to get invoices of %s: %v", pubkey, err)
+		return false
+	}
+	if len(pending) == 0 {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	for _, inv := range pending {
+		paid, err := a.provider.IsPaid(ctx, inv.InvoiceID)
+		if err != nil {
+			log.Printf("failed to check invoice of %s: %v", pubkey, err)
+			continue
+		}
+		if paid {
+			if err := a.paid(pubkey, inv); err != nil {
+				log.Printf("failed to admit %s: %v", pubkey, err)
+			}
+		} else if inv.ExpiresAt < time.Now().Unix() {
+			// it won't ever be paid, don't check it again
+			a.db.Exec(`DELETE FROM invoice WHERE invoice_id = $1 AND paid_at = 0`, inv.InvoiceID)
+		}
+	}
+
+	admitted, _ = a.admitted(pubkey, kind)
+	return admitted
+}
+
+func (a *admissions) admitted(pubkey string, kind int) (bool, error) {
+	var admitted bool
+	err := a.db.Get(&admitted, `SELECT EXISTS (SELECT 1 FROM admission
+		WHERE pubkey = $1 AND ($2 = -1 OR kind = -1 OR kind = $2) AND (expires_at = 0 OR expires_at > $3))`,
+		pubkey, kind, time.Now().Unix())
+	return admitted, err
+}
+
+// paid admits pubkey for what inv was for, once.
+func (a *admissions) paid(pubkey string, inv invoice) error {
+	tx, err := a.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`UPDATE invoice SET paid_at = $2 WHERE invoice_id = $1 AND paid_at = 0`,
+		inv.InvoiceID, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// admitted already
+		return nil
+	}
+	if err := a.admit(tx, pubkey, inv.Kind, time.Duration(inv.Days)*24*time.Hour); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// admit lets pubkey write events of kind for d more, or for ever if 0.
+func (a *admissions) admit(db sqlx.Execer, pubkey string, kind int, d time.Duration) error {
+	now := time.Now().Unix()
+	var expiresAt int64
+	if d != 0 {
+		expiresAt = now + int64(d.Seconds())
+	}
+	_, err := db.Exec(`INSERT INTO admission (pubkey, kind, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (pubkey, kind) DO UPDATE SET
+		  expires_at = CASE WHEN admission.expires_at = 0 OR $3 = 0 THEN 0
+		    ELSE GREATEST(admission.expires_at, $4) + $5 END,
+		  reminded_at = 0`,
+		pubkey, kind, expiresAt, now, int64(d.Seconds()))
+	return err
+}
+
+// credit records z, once per payment, returning how much its sender
+// zapped in total, in msats.
+func (a *admissions) credit(z zap, receiptID string) (int64, error) {
+	_, err := a.db.Exec(`INSERT INTO credit (payment_hash, pubkey, msats, receipt_id, created_at)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`,
+		z.PaymentHash, z.Sender, z.MilliSats, receiptID, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	err = a.db.Get(&total, `SELECT COALESCE(SUM(msats), 0) FROM credit WHERE pubkey = $1`, z.Sender)
+	return total, err
+}
+
+// expiring returns the admissions expiring before t which weren't reminded
+// of yet, see reminded.
+func (a *admissions) expiring(t time.Time) ([]admission, error) {
+	var expiring []admission
+	err := a.db.Select(&expiring, `SELECT pubkey, kind, expires_at FROM admission
+		WHERE expires_at != 0 AND expires_at > $1 AND expires_at < $2 AND reminded_at = 0`,
+		time.Now().Unix(), t.Unix())
+	return expiring, err
+}
+
+// reminded marks adm as reminded of, unless it was renewed since.
+func (a *admissions) reminded(adm admission) error {
+	_, err := a.db.Exec(`UPDATE admission SET reminded_at = $4
+		WHERE pubkey = $1 AND kind = $2 AND expires_at = $3`,
+		adm.Pubkey, adm.Kind, adm.ExpiresAt, time.Now().Unix())
+	return err
+}
+
+func isPubkey(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
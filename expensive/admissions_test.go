@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// tests needing a database use the one in POSTGRESQL_TEST_DATABASE,
+// whose admission tables are dropped along the way.
+func testAdmissions(t *testing.T) *admissions {
+	url := os.Getenv("POSTGRESQL_TEST_DATABASE")
+	if url == "" {
+		t.Skip("POSTGRESQL_TEST_DATABASE not set")
+	}
+	db, err := sqlx.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.Mapper = reflectx.NewMapperFunc("json", sqlx.NameMapper)
+	if _, err := db.Exec(`DROP TABLE IF EXISTS admission, invoice, credit, legacy_import`); err != nil {
+		t.Fatal(err)
+	}
+	a, err := newAdmissions(db, &mockProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestReminders(t *testing.T) {
+	a := testAdmissions(t)
+	pubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err := a.admit(a.db, pubkey, 1, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	soon := time.Now().AddDate(0, 0, 3)
+
+	// until a reminder is sent, it is tried again
+	for i := 0; i < 2; i++ {
+		expiring, err := a.expiring(soon)
+		if err != nil || len(expiring) != 1 || expiring[0].Pubkey != pubkey {
+			t.Fatalf("expiring = %v, %v", expiring, err)
+		}
+	}
+	expiring, _ := a.expiring(soon)
+	if err := a.reminded(expiring[0]); err != nil {
+		t.Fatal(err)
+	}
+	if expiring, _ := a.expiring(soon); len(expiring) != 0 {
+		t.Errorf("reminded again: %v", expiring)
+	}
+
+	// renewals are reminded of again
+	if err := a.admit(a.db, pubkey, 1, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if expiring, _ := a.expiring(soon); len(expiring) != 1 {
+		t.Errorf("renewal not reminded of: %v", expiring)
+	}
+}
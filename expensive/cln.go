@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	lnsocket "github.com/jb55/lnsocket/go"
+	"github.com/tidwall/gjson"
+)
+
+// clnLabelPrefix starts the labels of tickets, followed by the pubkey.
+const clnLabelPrefix = "relayer-expensive:ticket:"
+
+// clnTimeout bounds calls whose context has no deadline.
+const clnTimeout = 30 * time.Second
+
+// clnMaxIdle is how many connections are kept open between calls.
+const clnMaxIdle = 4
+
+// clnProvider talks to Core Lightning through Commando. A connection only
+// serves one call at a time, so concurrent calls open more of them, which
+// are then reused.
+type clnProvider struct {
+	NodeID string
+	Host   string
+	Rune   string
+
+	mu   sync.Mutex
+	idle []*lnsocket.LNSocket
+}
+
+func (p *clnProvider) CreateInvoice(ctx context.Context, sats int64, description string) (Invoice, error) {
+	random := make([]byte, 8)
+	rand.Read(random)
+	label := clnLabelPrefix + hex.EncodeToString(random)
+
+	result, err := p.rpc(ctx, "invoice", map[string]any{
+		"amount_msat": sats * 1000,
+		"label":       label,
+		"description": description,
+		"expiry":      int(invoiceExpiry.Seconds()),
+	})
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	bolt11 := gjson.Get(result, "result.bolt11")
+	if bolt11.Type != gjson.String {
+		return Invoice{}, fmt.Errorf("No bolt11 result found in invoice response, got %v", result)
+	}
+	return Invoice{
+		ID:        label,
+		Bolt11:    bolt11.String(),
+		ExpiresAt: time.Unix(gjson.Get(result, "result.expires_at").Int(), 0),
+	}, nil
+}
+
+func (p *clnProvider) IsPaid(ctx context.Context, id string) (bool, error) {
+	result, err := p.rpc(ctx, "listinvoices", map[string]any{"label": id})
+	if err != nil {
+		return false, err
+	}
+	return gjson.Get(result, "result.invoices.0.status").String() == "paid", nil
+}
+
+// paidTickets returns the pubkeys of the tickets paid before the paid status
+// was kept in the database, when invoices were labeled with the pubkey.
+// They are looked up with the sql method, which filters them on the node,
+// or by listing all invoices if it isn't available.
+func (p *clnProvider) paidTickets(ctx context.Context) ([]string, error) {
+	var labels []string
+	result, err := p.rpc(ctx, "sql", map[string]any{
+		"query": "SELECT label FROM invoices WHERE status = 'paid' AND label LIKE '" + clnLabelPrefix + "%'",
+	})
+	if err == nil {
+		for _, row := range gjson.Get(result, "result.rows").Array() {
+			labels = append(labels, row.Get("0").String())
+		}
+	} else {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		result, err := p.rpc(ctx, "listinvoices", map[string]any{})
+		if err != nil {
+			return nil, err
+		}
+		for _, invoice := range gjson.Get(result, "result.invoices").Array() {
+			if invoice.Get("status").String() == "paid" {
+				labels = append(labels, invoice.Get("label").String())
+			}
+		}
+	}
+
+	return ticketPubkeys(labels), nil
+}
+
+// ticketPubkeys returns the pubkeys in the labels of tickets, ignoring the
+// other invoices, such as those labeled randomly since.
+func ticketPubkeys(labels []string) []string {
+	var pubkeys []string
+	for _, label := range labels {
+		pubkey, ok := strings.CutPrefix(label, clnLabelPrefix)
+		if ok && isPubkey(pubkey) {
+			pubkeys = append(pubkeys, pubkey)
+		}
+	}
+	return pubkeys
+}
+
+// rpc calls method on an idle connection, or a new one. It retries once on
+// a new connection if the call failed, the idle one having been lost.
+func (p *clnProvider) rpc(ctx context.Context, method string, params map[string]any) (string, error) {
+	jparams, _ := json.Marshal(params)
+	var result string
+	var err error
+	for attempt := 0; attempt < 2 && ctx.Err() == nil; attempt++ {
+		conn := p.take()
+		if conn == nil {
+			if conn, err = p.connect(ctx); err != nil {
+				return "", err
+			}
+		}
+		err = withDeadline(ctx, conn, func() (err error) {
+			result, err = conn.Rpc(p.Rune, method, string(jparams))
+			return err
+		})
+		if err == nil {
+			p.put(conn)
+			break
+		}
+		conn.Disconnect()
+	}
+	if err == nil && result == "" {
+		// canceled before any call
+		err = ctx.Err()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	resErr := gjson.Get(result, "error")
+	if resErr.Type != gjson.Null {
+		if resErr.Type == gjson.JSON {
+			return "", errors.New(resErr.Get("message").String())
+		} else if resErr.Type == gjson.String {
+			return "", errors.New(resErr.String())
+		}
+		return "", fmt.Errorf("Unknown commando error: '%v'", resErr)
+	}
+	return result, nil
+}
+
+// connect opens a new connection to the node.
+func (p *clnProvider) connect(ctx context.Context) (*lnsocket.LNSocket, error) {
+	conn := &lnsocket.LNSocket{}
+	conn.GenKey()
+	if err := conn.Connect(p.Host, p.NodeID); err != nil {
+		return nil, err
+	}
+	if err := withDeadline(ctx, conn, conn.PerformInit); err != nil {
+		conn.Disconnect()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// take returns an idle connection, or nil if there is none.
+func (p *clnProvider) take() *lnsocket.LNSocket {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) == 0 {
+		return nil
+	}
+	conn := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	return conn
+}
+
+// put keeps conn for later calls, unless enough connections are idle.
+func (p *clnProvider) put(conn *lnsocket.LNSocket) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) >= clnMaxIdle {
+		conn.Disconnect()
+		return
+	}
+	p.idle = append(p.idle, conn)
+}
+
+// withDeadline runs fn, which reads and writes conn, until the deadline of
+// ctx, clnTimeout from now if none, or until ctx is canceled.
+func withDeadline(ctx context.Context, conn *lnsocket.LNSocket, fn func() error) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(clnTimeout)
+	}
+	conn.Conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.Conn.SetDeadline(time.Now()) })
+	err := fn()
+	if !stop() {
+		// interrupted by the cancellation of ctx, if err is set
+		if err != nil {
+			return ctx.Err()
+		}
+	}
+	conn.Conn.SetDeadline(time.Time{})
+	return err
+}
@@ -2,23 +2,42 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 )
 
-func handleWebpage(w http.ResponseWriter, rq *http.Request) {
+func handleWebpage(w http.ResponseWriter, rq *http.Request, r *Relay) {
+	var options strings.Builder
+	for _, p := range r.plans() {
+		label := "lifetime"
+		switch {
+		case p.Kind != allKinds:
+			label = fmt.Sprintf("lifetime, only kind %d", p.Kind)
+		case p.Days != 0:
+			label = fmt.Sprintf("%d days", p.Days)
+		}
+		fmt.Fprintf(&options, "<option value=%q>%s: %d sats</option>", p.Name, label, p.Sats)
+	}
+
 	w.Header().Set("Content-Type", "text/html")
 	w.Write([]byte(`
 <meta charset=utf-8>
 <title>expensive relay</title>
 <h1>expensive relay</h1>
 <a href="https://github.com/fiatjaf/expensive-relay">https://github.com/fiatjaf/expensive-relay</a>
-<p>this is a nostr relay that only accepts events published from keys that pay a registration fee. this is an antispam measure. you can still be banned if you're spamming or doing something bad.</p>
+<p>this is a nostr relay that only accepts events published from keys that pay a registration fee, for ever or for some days. this is an antispam measure. you can still be banned if you're spamming or doing something bad.</p>
 <p>to register your nostr public key, type it below and click the button.</p>
 <form>
   <label>
     nostr public key:
     <input name=pubkey />
   </label>
+  <label>
+    admission:
+    <select name=plan>` + options.String() + `</select>
+  </label>
   <button>Get Invoice</button>
 </form>
 <p id=message></p>
@@ -28,7 +47,7 @@ func handleWebpage(w http.ResponseWriter, rq *http.Request) {
 <script>
 document.querySelector('form').addEventListener('submit', async ev => {
   ev.preventDefault()
-  let res = await (await fetch('/invoice?pubkey=' + ev.target.pubkey.value)).text()
+  let res = await (await fetch('/invoice?pubkey=' + ev.target.pubkey.value + '&plan=' + ev.target.plan.value)).text()
   let { bolt11, error } = JSON.parse(res)
   if (bolt11) {
     invoice.innerHTML = bolt11
@@ -55,7 +74,12 @@ body {
 
 func handleInvoice(w http.ResponseWriter, rq *http.Request, r *Relay) {
 	w.Header().Set("Content-Type", "application/json")
-	invoice, err := generateInvoice(r, rq.URL.Query().Get("pubkey"))
+	var invoice string
+	p, ok := r.plan(rq.URL.Query().Get("plan"))
+	err := errors.New("unknown plan")
+	if ok {
+		invoice, err = r.admissions.invoice(rq.Context(), rq.URL.Query().Get("pubkey"), p)
+	}
 	if err != nil {
 		json.NewEncoder(w).Encode(struct {
 			Error string `json:"error"`
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// lnurlProvider gets invoices from an LNURL-pay service (LUD-06), which
+// must support verifying their payment (LUD-21). The invoice ID is the
+// verify URL.
+type lnurlProvider struct {
+	// Address is a lightning address, like relay@getalby.com, or the
+	// https URL of the LNURL-pay service.
+	Address string
+}
+
+type lnurlPayParams struct {
+	Tag            string `json:"tag"`
+	Callback       string `json:"callback"`
+	MinSendable    int64  `json:"minSendable"`
+	MaxSendable    int64  `json:"maxSendable"`
+	CommentAllowed int    `json:"commentAllowed"`
+}
+
+func (p *lnurlProvider) CreateInvoice(ctx context.Context, sats int64, description string) (Invoice, error) {
+	var params lnurlPayParams
+	if err := lnurlGet(ctx, p.url(), &params); err != nil {
+		return Invoice{}, err
+	}
+	msat := sats * 1000
+	switch {
+	case params.Tag != "payRequest":
+		return Invoice{}, fmt.Errorf("not an lnurl-pay service: %q", params.Tag)
+	case msat < params.MinSendable || msat > params.MaxSendable:
+		return Invoice{}, fmt.Errorf("%d sats out of the range of the lnurl-pay service", sats)
+	}
+
+	callback, err := url.Parse(params.Callback)
+	if err != nil {
+		return Invoice{}, fmt.Errorf("invalid callback: %w", err)
+	}
+	query := callback.Query()
+	query.Set("amount", strconv.FormatInt(msat, 10))
+	if params.CommentAllowed > 0 {
+		if len(description) > params.CommentAllowed {
+			description = description[:params.CommentAllowed]
+		}
+		query.Set("comment", description)
+	}
+	callback.RawQuery = query.Encode()
+
+	var res struct {
+		PR     string `json:"pr"`
+		Verify string `json:"verify"`
+	}
+	if err := lnurlGet(ctx, callback.String(), &res); err != nil {
+		return Invoice{}, err
+	}
+	if res.Verify == "" {
+		return Invoice{}, errors.New("the lnurl-pay service doesn't support verifying payments")
+	}
+	invoice, err := decodeBolt11(res.PR)
+	if err != nil {
+		return Invoice{}, fmt.Errorf("invalid invoice: %w", err)
+	}
+	if invoice.MilliSat == nil || int64(*invoice.MilliSat) != msat {
+		return Invoice{}, errors.New("the lnurl-pay service returned an invoice of the wrong amount")
+	}
+	return Invoice{
+		ID:        res.Verify,
+		Bolt11:    res.PR,
+		ExpiresAt: invoice.Timestamp.Add(invoice.Expiry()),
+	}, nil
+}
+
+func (p *lnurlProvider) IsPaid(ctx context.Context, id string) (bool, error) {
+	var res struct {
+		Settled bool `json:"settled"`
+	}
+	if err := lnurlGet(ctx, id, &res); err != nil {
+		return false, err
+	}
+	return res.Settled, nil
+}
+
+func (p *lnurlProvider) url() string {
+	if name, domain, ok := strings.Cut(p.Address, "@"); ok {
+		return "https://" + domain + "/.well-known/lnurlp/" + name
+	}
+	return p.Address
+}
+
+// lnurlGet gets the JSON at u into result, failing on LNURL errors.
+func lnurlGet(ctx context.Context, u string, result any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var body json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return fmt.Errorf("invalid lnurl response: %w", err)
+	}
+	var lnurlErr struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	json.Unmarshal(body, &lnurlErr)
+	if lnurlErr.Status == "ERROR" {
+		return fmt.Errorf("lnurl error: %s", lnurlErr.Reason)
+	}
+	return json.Unmarshal(body, result)
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
@@ -15,15 +18,48 @@ import (
 
 type Relay struct {
 	PostgresDatabase string `envconfig:"POSTGRESQL_DATABASE"`
-	CLNNodeId        string `envconfig:"CLN_NODE_ID"`
-	CLNHost          string `envconfig:"CLN_HOST"`
-	CLNRune          string `envconfig:"CLN_RUNE"`
-	TicketPriceSats  int64  `envconfig:"TICKET_PRICE_SATS"`
 
-	storage *postgresql.PostgresBackend
-}
+	// TicketPriceSats is the price of the lifetime admission, not sold if 0.
+	TicketPriceSats int64 `envconfig:"TICKET_PRICE_SATS"`
+	// SubscriptionPrices are prices of admissions by number of days,
+	// like 30:1000,90:2500,365:9000.
+	SubscriptionPrices map[int]int64 `envconfig:"SUBSCRIPTION_PRICES"`
+	// KindPrices are prices of lifetime admissions to write only some kind,
+	// like 30023:5000.
+	KindPrices map[int]int64 `envconfig:"KIND_PRICES"`
+	// PaidReads makes reading require an admission too, through NIP-42.
+	PaidReads bool `envconfig:"PAID_READS"`
+	// RelayURL is the URL clients connect to, checked in NIP-42 AUTH events.
+	RelayURL string `envconfig:"SERVICE_URL"`
+
+	// RelayPrivateKey signs reminders sent RemindDays before admissions expire.
+	RelayPrivateKey string `envconfig:"RELAY_PRIVATE_KEY"`
+	RemindDays      int    `envconfig:"REMIND_DAYS" default:"3"`
+
+	// Zaps to the pubkey of RelayPrivateKey count towards TicketPriceSats.
+	// Their receipts must be signed by ZapperPubkey, the one of the lnurl
+	// server of LNURLAddress by default.
+	Zaps         bool   `envconfig:"ZAPS"`
+	ZapperPubkey string `envconfig:"ZAPPER_PUBKEY"`
 
-var r = &Relay{}
+	// PaymentProvider is one of cln, lnd, lnurl, nwc and mock, which
+	// needs DevMode.
+	PaymentProvider string `envconfig:"PAYMENT_PROVIDER" default:"cln"`
+	DevMode         bool   `envconfig:"DEV_MODE"`
+	CLNNodeId       string `envconfig:"CLN_NODE_ID"`
+	CLNHost         string `envconfig:"CLN_HOST"`
+	CLNRune         string `envconfig:"CLN_RUNE"`
+	LNDHost         string `envconfig:"LND_HOST"`
+	LNDMacaroon     string `envconfig:"LND_MACAROON"`
+	LNDCert         string `envconfig:"LND_CERT"`
+	LNURLAddress    string `envconfig:"LNURL_ADDRESS"`
+	NWCURI          string `envconfig:"NWC_URI"`
+
+	storage    *postgresql.PostgresBackend
+	payments   PaymentProvider
+	admissions *admissions
+	pubkey     string
+}
 
 func (r *Relay) Name() string {
 	return "ExpensiveRelay"
@@ -34,6 +70,40 @@ func (r *Relay) Storage() relayer.Storage {
 }
 
 func (r *Relay) Init() error {
+	if len(r.plans()) == 0 {
+		return errors.New("nothing on sale, set TICKET_PRICE_SATS, SUBSCRIPTION_PRICES or KIND_PRICES")
+	}
+	if (r.PaidReads || r.RelayPrivateKey != "") && r.RelayURL == "" {
+		return errors.New("SERVICE_URL is required for paid reads and reminders")
+	}
+	if r.RelayPrivateKey != "" {
+		pubkey, err := nostr.GetPublicKey(r.RelayPrivateKey)
+		if err != nil {
+			return fmt.Errorf("invalid RELAY_PRIVATE_KEY: %w", err)
+		}
+		r.pubkey = pubkey
+	}
+
+	payments, err := newPaymentProvider(r)
+	if err != nil {
+		return err
+	}
+	r.payments = payments
+
+	if r.Zaps {
+		if r.pubkey == "" || r.TicketPriceSats == 0 {
+			return errors.New("RELAY_PRIVATE_KEY and TICKET_PRICE_SATS are required for zaps")
+		}
+		if r.ZapperPubkey == "" && r.LNURLAddress == "" {
+			return errors.New("ZAPPER_PUBKEY or LNURL_ADDRESS is required for zaps")
+		}
+		if r.ZapperPubkey == "" {
+			if r.ZapperPubkey, err = r.zapperPubkey(context.Background()); err != nil {
+				return fmt.Errorf("failed to get the zapper pubkey of LNURL_ADDRESS: %w", err)
+			}
+		}
+	}
+
 	// every hour, delete all very old events
 	go func() {
 		db := r.Storage().(*postgresql.PostgresBackend)
@@ -48,16 +118,43 @@ func (r *Relay) Init() error {
 }
 
 func (r *Relay) OnInitialized(s *relayer.Server) {
+	// admissions live in the database, which is ready only now
+	admissions, err := newAdmissions(r.storage.DB, r.payments)
+	if err != nil {
+		log.Fatalf("failed to load admissions: %v", err)
+	}
+	r.admissions = admissions
+	if r.pubkey != "" {
+		go r.remindExpiring()
+	}
+
 	// special handlers
-	s.Router().Path("/").HandlerFunc(handleWebpage)
+	s.Router().Path("/").HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		handleWebpage(w, rq, r)
+	})
 	s.Router().Path("/invoice").HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
 		handleInvoice(w, rq, r)
 	})
 }
 
 func (r *Relay) AcceptEvent(evt *nostr.Event) bool {
+	// reminders
+	if r.pubkey != "" && evt.PubKey == r.pubkey {
+		return true
+	}
+
+	// receipts of zaps to the relay are published by the zapper, which
+	// needn't be admitted; other receipts are like any other event
+	if r.isZapToRelay(evt) {
+		if err := r.acceptZap(context.Background(), evt); err != nil {
+			log.Printf("rejected zap receipt %s: %v", evt.ID, err)
+			return false
+		}
+		return true
+	}
+
 	// only accept they have a good preimage for a paid invoice for their public key
-	if !checkInvoicePaidOk(evt.PubKey) {
+	if !r.admissions.isAdmitted(context.Background(), evt.PubKey, evt.Kind) {
 		return false
 	}
 
@@ -70,6 +167,31 @@ func (r *Relay) AcceptEvent(evt *nostr.Event) bool {
 	return true
 }
 
+// authRelay is the relay with a SERVICE_URL, which clients can authenticate
+// to with NIP-42. Without one AUTH events can't be checked, so the relay
+// isn't a relayer.Auther at all.
+type authRelay struct{ *Relay }
+
+// ServiceURL implements relayer.Auther, for clients to authenticate to read
+// their reminders and, with PaidReads, anything.
+func (r authRelay) ServiceURL() string {
+	return r.RelayURL
+}
+
+// serverRelay returns r as given to relayer.Start.
+func (r *Relay) serverRelay() relayer.Relay {
+	if r.RelayURL == "" {
+		return r
+	}
+	return authRelay{r}
+}
+
+// AcceptReq implements relayer.ReqAccepter, only serving admitted pubkeys
+// with PaidReads.
+func (r *Relay) AcceptReq(ctx context.Context, id string, filters nostr.Filters, authedPubkey string) bool {
+	return !r.PaidReads || authedPubkey != "" && r.admissions.isAdmitted(ctx, authedPubkey, allKinds)
+}
+
 func main() {
 	r := Relay{}
 	if err := envconfig.Process("", &r); err != nil {
@@ -77,7 +199,7 @@ func main() {
 		return
 	}
 	r.storage = &postgresql.PostgresBackend{DatabaseURL: r.PostgresDatabase}
-	if err := relayer.Start(&r); err != nil {
+	if err := relayer.Start(r.serverRelay()); err != nil {
 		log.Fatalf("server terminated: %v", err)
 	}
 }
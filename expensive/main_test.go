@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestAuther(t *testing.T) {
+	if _, ok := (&Relay{}).serverRelay().(relayer.Auther); ok {
+		t.Error("relay without SERVICE_URL is an Auther")
+	}
+	if auther, ok := (&Relay{RelayURL: "wss://relay.example.com"}).serverRelay().(relayer.Auther); !ok || auther.ServiceURL() != "wss://relay.example.com" {
+		t.Error("relay with SERVICE_URL isn't an Auther")
+	}
+
+	// reminders can't be read without it
+	r := &Relay{TicketPriceSats: 500, RelayPrivateKey: nostr.GeneratePrivateKey(), PaymentProvider: "mock", DevMode: true}
+	if err := r.Init(); err == nil {
+		t.Error("reminders without SERVICE_URL")
+	}
+	r = &Relay{TicketPriceSats: 500, PaidReads: true, PaymentProvider: "mock", DevMode: true}
+	if err := r.Init(); err == nil {
+		t.Error("paid reads without SERVICE_URL")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// nwcProvider talks to a wallet through Nostr Wallet Connect (NIP-47).
+// The invoice ID is the payment hash.
+type nwcProvider struct {
+	// Wallet is the pubkey of the wallet service.
+	Wallet string
+	// Relay is where the wallet service listens to requests.
+	Relay string
+	// Secret signs the requests.
+	Secret string
+}
+
+// newNWCProvider parses a nostr+walletconnect:// URI.
+func newNWCProvider(uri string) (*nwcProvider, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NWC URI: %w", err)
+	}
+	if u.Scheme != "nostr+walletconnect" && u.Scheme != "nostrwalletconnect" {
+		return nil, fmt.Errorf("invalid NWC URI scheme %q", u.Scheme)
+	}
+	p := &nwcProvider{
+		Wallet: u.Host,
+		Relay:  u.Query().Get("relay"),
+		Secret: u.Query().Get("secret"),
+	}
+	if p.Wallet == "" {
+		// nostr+walletconnect:pubkey?...
+		p.Wallet = u.Opaque
+	}
+	if !isPubkey(p.Wallet) || p.Relay == "" || p.Secret == "" {
+		return nil, errors.New("NWC URI must have a wallet pubkey, a relay and a secret")
+	}
+	return p, nil
+}
+
+type nwcResponse struct {
+	ResultType string `json:"result_type"`
+	Error      *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Result json.RawMessage `json:"result"`
+}
+
+func (p *nwcProvider) CreateInvoice(ctx context.Context, sats int64, description string) (Invoice, error) {
+	var res struct {
+		Invoice     string `json:"invoice"`
+		PaymentHash string `json:"payment_hash"`
+		ExpiresAt   int64  `json:"expires_at"`
+	}
+	err := p.call(ctx, "make_invoice", map[string]any{
+		"amount":      sats * 1000,
+		"description": description,
+		"expiry":      int(invoiceExpiry.Seconds()),
+	}, &res)
+	if err != nil {
+		return Invoice{}, err
+	}
+	expiresAt := time.Now().Add(invoiceExpiry)
+	if res.ExpiresAt != 0 {
+		expiresAt = time.Unix(res.ExpiresAt, 0)
+	}
+	return Invoice{ID: res.PaymentHash, Bolt11: res.Invoice, ExpiresAt: expiresAt}, nil
+}
+
+func (p *nwcProvider) IsPaid(ctx context.Context, id string) (bool, error) {
+	var res struct {
+		State     string `json:"state"`
+		SettledAt int64  `json:"settled_at"`
+	}
+	if err := p.call(ctx, "lookup_invoice", map[string]any{"payment_hash": id}, &res); err != nil {
+		return false, err
+	}
+	return res.State == "settled" || res.SettledAt != 0, nil
+}
+
+// call sends a request to the wallet and waits for its response.
+func (p *nwcProvider) call(ctx context.Context, method string, params, result any) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+	}
+
+	pubkey, err := nostr.GetPublicKey(p.Secret)
+	if err != nil {
+		return fmt.Errorf("invalid NWC secret: %w", err)
+	}
+	shared, err := nip04.ComputeSharedSecret(p.Wallet, p.Secret)
+	if err != nil {
+		return err
+	}
+	request, _ := json.Marshal(map[string]any{"method": method, "params": params})
+	content, err := nip04.Encrypt(string(request), shared)
+	if err != nil {
+		return err
+	}
+	evt := nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: time.Now(),
+		Kind:      23194,
+		Tags:      nostr.Tags{{"p", p.Wallet}},
+		Content:   content,
+	}
+	if err := evt.Sign(p.Secret); err != nil {
+		return err
+	}
+
+	relay, err := nostr.RelayConnect(ctx, p.Relay)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NWC relay: %w", err)
+	}
+	defer relay.Close()
+
+	// subscribe before publishing, not to miss the response
+	sub := relay.Subscribe(ctx, nostr.Filters{{
+		Kinds:   []int{23195},
+		Authors: []string{p.Wallet},
+		Tags:    nostr.TagMap{"e": []string{evt.ID}},
+	}})
+	go relay.Publish(ctx, evt)
+
+	for {
+		select {
+		case response, ok := <-sub.Events:
+			if !ok {
+				return ctx.Err()
+			}
+			plain, err := nip04.Decrypt(response.Content, shared)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt NWC response: %w", err)
+			}
+			var res nwcResponse
+			if err := json.Unmarshal([]byte(plain), &res); err != nil {
+				return fmt.Errorf("invalid NWC response: %w", err)
+			}
+			if res.Error != nil {
+				return fmt.Errorf("wallet error: %s %s", res.Error.Code, strings.TrimSpace(res.Error.Message))
+			}
+			return json.Unmarshal(res.Result, result)
+		case <-ctx.Done():
+			return fmt.Errorf("no response from the wallet: %w", ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+// PaymentProvider issues the invoices for tickets and tells whether they
+// were paid. It is picked with PAYMENT_PROVIDER.
+type PaymentProvider interface {
+	// CreateInvoice returns a new invoice of sats.
+	CreateInvoice(ctx context.Context, sats int64, description string) (Invoice, error)
+
+	// IsPaid reports whether the invoice with the given Invoice.ID was paid.
+	IsPaid(ctx context.Context, id string) (bool, error)
+}
+
+// Invoice is an invoice issued by a PaymentProvider.
+type Invoice struct {
+	// ID identifies the invoice to the provider: a label, a payment hash
+	// or a URL, depending on the provider.
+	ID        string
+	Bolt11    string
+	ExpiresAt time.Time
+}
+
+// invoiceExpiry is how long invoices can be paid for.
+const invoiceExpiry = time.Hour
+
+func newPaymentProvider(r *Relay) (PaymentProvider, error) {
+	switch r.PaymentProvider {
+	case "cln":
+		return &clnProvider{NodeID: r.CLNNodeId, Host: r.CLNHost, Rune: r.CLNRune}, nil
+	case "lnd":
+		return newLNDProvider(r.LNDHost, r.LNDMacaroon, r.LNDCert)
+	case "lnurl":
+		return &lnurlProvider{Address: r.LNURLAddress}, nil
+	case "nwc":
+		return newNWCProvider(r.NWCURI)
+	case "mock":
+		// everything is free
+		if !r.DevMode {
+			return nil, errors.New("the mock payment provider gives tickets away, it needs DEV_MODE")
+		}
+		return &mockProvider{AutoPay: true}, nil
+	}
+	return nil, fmt.Errorf("unknown payment provider %q", r.PaymentProvider)
+}
+
+// decodeBolt11 decodes invoices of mainnet, testnet and regtest.
+func decodeBolt11(bolt11 string) (*zpay32.Invoice, error) {
+	params := &chaincfg.MainNetParams
+	switch lower := strings.ToLower(bolt11); {
+	case strings.HasPrefix(lower, "lnbcrt"):
+		params = &chaincfg.RegressionNetParams
+	case strings.HasPrefix(lower, "lntb"):
+		params = &chaincfg.TestNet3Params
+	}
+	return zpay32.Decode(bolt11, params)
+}
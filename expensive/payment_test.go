@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	lnsocket "github.com/jb55/lnsocket/go"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestLNDProvider(t *testing.T) {
+	hash := make([]byte, 32)
+	hash[0] = 0xab
+	settled := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Grpc-Metadata-macaroon") != "cafe" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/v1/invoices":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["value"] != "500" {
+				t.Errorf("invoice request = %v", req)
+			}
+			json.NewEncoder(w).Encode(map[string]string{
+				"r_hash":          base64.StdEncoding.EncodeToString(hash),
+				"payment_request": "lnbc5u1...",
+			})
+		case r.Method == "GET" && r.URL.Path == "/v1/invoice/"+hex.EncodeToString(hash):
+			state := "OPEN"
+			if settled {
+				state = "SETTLED"
+			}
+			json.NewEncoder(w).Encode(map[string]string{"state": state})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	p, _ := newLNDProvider(srv.URL, "cafe", "")
+	testProvider(t, p, func(string) { settled = true })
+}
+
+func TestLNURLProvider(t *testing.T) {
+	settled := false
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/lnurlp/relay":
+			json.NewEncoder(w).Encode(lnurlPayParams{
+				Tag:         "payRequest",
+				Callback:    srv.URL + "/callback",
+				MinSendable: 1000,
+				MaxSendable: 1000000,
+			})
+		case "/callback":
+			if r.URL.Query().Get("amount") != "500000" {
+				t.Errorf("callback query = %v", r.URL.Query())
+			}
+			json.NewEncoder(w).Encode(map[string]string{
+				"pr":     signedBolt11(t, 500000, zpay32.Description("ticket")),
+				"verify": srv.URL + "/verify",
+			})
+		case "/verify":
+			json.NewEncoder(w).Encode(map[string]any{"status": "OK", "settled": settled})
+		}
+	}))
+	defer srv.Close()
+
+	p := &lnurlProvider{Address: srv.URL + "/.well-known/lnurlp/relay"}
+	testProvider(t, p, func(string) { settled = true })
+
+	if _, err := p.CreateInvoice(context.Background(), 5000, "ticket"); err == nil {
+		t.Error("invoice out of range created")
+	}
+}
+
+func TestMockProvider(t *testing.T) {
+	p := &mockProvider{}
+	testProvider(t, p, p.pay)
+
+	p.AutoPay = true
+	invoice, _ := p.CreateInvoice(context.Background(), 500, "ticket")
+	if paid, _ := p.IsPaid(context.Background(), invoice.ID); !paid {
+		t.Error("invoice not paid automatically")
+	}
+}
+
+func TestMockProviderRestart(t *testing.T) {
+	before, _ := (&mockProvider{}).CreateInvoice(context.Background(), 500, "ticket")
+	after, _ := (&mockProvider{}).CreateInvoice(context.Background(), 500, "ticket")
+	if before.ID == after.ID {
+		t.Errorf("same invoice id %s after a restart", before.ID)
+	}
+
+	if _, err := newPaymentProvider(&Relay{PaymentProvider: "mock"}); err == nil {
+		t.Error("mock provider without DEV_MODE")
+	}
+	if _, err := newPaymentProvider(&Relay{PaymentProvider: "mock", DevMode: true}); err != nil {
+		t.Errorf("mock provider with DEV_MODE: %v", err)
+	}
+}
+
+func TestCLNCallCanceled(t *testing.T) {
+	client, node := net.Pipe()
+	defer node.Close()
+	conn := &lnsocket.LNSocket{Conn: client}
+
+	// the node never answers
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err := withDeadline(ctx, conn, func() error {
+		_, _, err := conn.Recv()
+		return err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled call = %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := (&clnProvider{idle: []*lnsocket.LNSocket{conn}}).IsPaid(ctx, "label"); err == nil {
+		t.Error("call past its deadline succeeded")
+	}
+}
+
+func TestTicketPubkeys(t *testing.T) {
+	pubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	got := ticketPubkeys([]string{
+		clnLabelPrefix + pubkey,
+		clnLabelPrefix + "0123456789abcdef",
+		"someone else's invoice",
+	})
+	if len(got) != 1 || got[0] != pubkey {
+		t.Errorf("ticketPubkeys = %v", got)
+	}
+}
+
+func TestNWCURI(t *testing.T) {
+	wallet := "b889ff5b1513b641e2a139f661a661364979c5beee91842f8f0ef42ab558e9d4"
+	p, err := newNWCProvider("nostr+walletconnect://" + wallet + "?relay=wss%3A%2F%2Frelay.example.com&secret=71a8c14c1407c113601079c4302dab36460f0ccd0ad506f1f2dc73b5100e4f3c")
+	if err != nil || p.Wallet != wallet || p.Relay != "wss://relay.example.com" {
+		t.Errorf("got %+v %v", p, err)
+	}
+	if _, err := newNWCProvider("nostr+walletconnect://" + wallet + "?relay=wss%3A%2F%2Frelay.example.com"); err == nil {
+		t.Error("URI without secret accepted")
+	}
+}
+
+// testProvider creates an invoice with p, checking it is paid only after pay.
+func testProvider(t *testing.T, p PaymentProvider, pay func(id string)) {
+	t.Helper()
+	ctx := context.Background()
+	invoice, err := p.CreateInvoice(ctx, 500, "ticket")
+	if err != nil {
+		t.Fatalf("CreateInvoice: %v", err)
+	}
+	if invoice.ID == "" || invoice.Bolt11 == "" || invoice.ExpiresAt.Before(time.Now()) {
+		t.Errorf("invoice = %+v", invoice)
+	}
+	if paid, err := p.IsPaid(ctx, invoice.ID); err != nil || paid {
+		t.Errorf("unpaid invoice: %v %v", paid, err)
+	}
+	pay(invoice.ID)
+	if paid, err := p.IsPaid(ctx, invoice.ID); err != nil || !paid {
+		t.Errorf("paid invoice: %v %v", paid, err)
+	}
+}
+
+// signedBolt11 returns a mainnet invoice of msat signed by a random node.
+func signedBolt11(t *testing.T, msat int64, options ...func(*zpay32.Invoice)) string {
+	t.Helper()
+	key, _ := btcec.NewPrivateKey()
+	options = append(options, zpay32.Amount(lnwire.MilliSatoshi(msat)))
+	invoice, err := zpay32.NewInvoice(&chaincfg.MainNetParams, [32]byte{1}, time.Now(), options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bolt11, err := invoice.Encode(zpay32.MessageSigner{
+		SignCompact: func(msg []byte) ([]byte, error) {
+			return ecdsa.SignCompact(key, chainhash.HashB(msg), true)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bolt11
+}
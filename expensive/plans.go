@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fiatjaf/relayer"
+)
+
+// plan is what pubkeys pay for to write to the relay.
+type plan struct {
+	Name string `json:"name"`
+	Sats int64  `json:"sats"`
+	// Days the admission lasts, 0 for ever.
+	Days int `json:"days,omitempty"`
+	// Kind the admission is restricted to, allKinds if not.
+	Kind int `json:"kind"`
+}
+
+// allKinds is the kind of admissions to write any kind.
+const allKinds = -1
+
+func (p plan) duration() time.Duration {
+	return time.Duration(p.Days) * 24 * time.Hour
+}
+
+// plans returns the plans on sale: the lifetime ticket, subscriptions
+// from the shortest, then kinds.
+func (r *Relay) plans() []plan {
+	var plans []plan
+	if r.TicketPriceSats > 0 {
+		plans = append(plans, plan{Name: "lifetime", Sats: r.TicketPriceSats, Kind: allKinds})
+	}
+	for _, days := range sortedKeys(r.SubscriptionPrices) {
+		plans = append(plans, plan{
+			Name: fmt.Sprintf("%dd", days),
+			Sats: r.SubscriptionPrices[days],
+			Days: days,
+			Kind: allKinds,
+		})
+	}
+	for _, kind := range sortedKeys(r.KindPrices) {
+		plans = append(plans, plan{
+			Name: fmt.Sprintf("kind:%d", kind),
+			Sats: r.KindPrices[kind],
+			Kind: kind,
+		})
+	}
+	return plans
+}
+
+// plan returns the plan named name, or the first one if name is empty.
+func (r *Relay) plan(name string) (plan, bool) {
+	plans := r.plans()
+	if name == "" && len(plans) > 0 {
+		return plans[0], true
+	}
+	for _, p := range plans {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return plan{}, false
+}
+
+// NIP11Fees implements relayer.FeesInformer. Kinds, whose admission is
+// bought once, are listed as publication fees.
+func (r *Relay) NIP11Fees() relayer.Fees {
+	var fees relayer.Fees
+	for _, p := range r.plans() {
+		fee := relayer.Fee{Amount: p.Sats * 1000, Unit: "msats"}
+		switch {
+		case p.Kind != allKinds:
+			fee.Kinds = []int{p.Kind}
+			fees.Publication = append(fees.Publication, fee)
+		case p.Days != 0:
+			fee.Period = int64(p.duration().Seconds())
+			fees.Subscription = append(fees.Subscription, fee)
+		default:
+			fees.Admission = append(fees.Admission, fee)
+		}
+	}
+	return fees
+}
+
+func sortedKeys(m map[int]int64) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPlans(t *testing.T) {
+	r := &Relay{
+		TicketPriceSats:    5000,
+		SubscriptionPrices: map[int]int64{365: 3000, 30: 500},
+		KindPrices:         map[int]int64{30023: 1000},
+	}
+	var names []string
+	for _, p := range r.plans() {
+		names = append(names, p.Name)
+	}
+	if got := fmt.Sprint(names); got != "[lifetime 30d 365d kind:30023]" {
+		t.Errorf("plans = %s", got)
+	}
+	if p, ok := r.plan(""); !ok || p.Name != "lifetime" {
+		t.Errorf("default plan = %+v", p)
+	}
+	if p, ok := r.plan("30d"); !ok || p.duration() != 30*24*time.Hour {
+		t.Errorf("30d = %+v", p)
+	}
+
+	fees := r.NIP11Fees()
+	if len(fees.Admission) != 1 || fees.Admission[0].Amount != 5000000 {
+		t.Errorf("admission fees = %+v", fees.Admission)
+	}
+	if len(fees.Subscription) != 2 || fees.Subscription[1].Period != 365*24*60*60 {
+		t.Errorf("subscription fees = %+v", fees.Subscription)
+	}
+	if len(fees.Publication) != 1 || fees.Publication[0].Kinds[0] != 30023 {
+		t.Errorf("publication fees = %+v", fees.Publication)
+	}
+}
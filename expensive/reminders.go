@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// remindExpiring sends a direct message, signed by the relay key, to the
+// pubkeys whose admission expires in less than RemindDays, every hour.
+// Those which couldn't be sent are tried again the next hour.
+func (r *Relay) remindExpiring() {
+	for {
+		expiring, err := r.admissions.expiring(time.Now().AddDate(0, 0, r.RemindDays))
+		if err != nil {
+			log.Printf("failed to get expiring admissions: %v", err)
+		}
+		for _, adm := range expiring {
+			if err := r.remind(adm); err != nil {
+				log.Printf("failed to remind %s: %v", adm.Pubkey, err)
+				continue
+			}
+			if err := r.admissions.reminded(adm); err != nil {
+				log.Printf("failed to mark %s as reminded: %v", adm.Pubkey, err)
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func (r *Relay) remind(adm admission) error {
+	expiresAt := time.Unix(adm.ExpiresAt, 0).UTC()
+	message := fmt.Sprintf("your admission to %s expires on %s.", r.Name(), expiresAt.Format("January 2, 2006"))
+	if r.RelayURL != "" {
+		url := strings.Replace(strings.Replace(r.RelayURL, "wss://", "https://", 1), "ws://", "http://", 1)
+		message += " you can renew it at " + url
+	}
+
+	shared, err := nip04.ComputeSharedSecret(adm.Pubkey, r.RelayPrivateKey)
+	if err != nil {
+		return err
+	}
+	content, err := nip04.Encrypt(message, shared)
+	if err != nil {
+		return err
+	}
+	evt := nostr.Event{
+		PubKey:    r.pubkey,
+		CreatedAt: time.Now(),
+		Kind:      4,
+		Tags:      nostr.Tags{{"p", adm.Pubkey}},
+		Content:   content,
+	}
+	if err := evt.Sign(r.RelayPrivateKey); err != nil {
+		return err
+	}
+	if ok, message := relayer.AddEvent(r, evt); !ok {
+		return fmt.Errorf("failed to add event: %s", message)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// zap is a zap to the relay, as verified from its receipt.
+type zap struct {
+	// Sender is who signed the zap request.
+	Sender      string
+	MilliSats   int64
+	PaymentHash string
+}
+
+// verifyZapReceipt checks receipt is a NIP-57 zap receipt of a zap to
+// recipient, signed by zapper, the pubkey of the LNURL server of recipient.
+func verifyZapReceipt(receipt *nostr.Event, recipient, zapper string) (zap, error) {
+	switch {
+	case receipt.Kind != 9735:
+		return zap{}, errors.New("not a zap receipt")
+	case receipt.PubKey != zapper:
+		return zap{}, errors.New("zap receipt not signed by the zapper of the relay")
+	case tagValue(receipt.Tags, "p") != recipient:
+		return zap{}, errors.New("zap not sent to the relay")
+	}
+
+	description := tagValue(receipt.Tags, "description")
+	var request nostr.Event
+	if err := json.Unmarshal([]byte(description), &request); err != nil {
+		return zap{}, fmt.Errorf("invalid zap request: %w", err)
+	}
+	if ok, err := request.CheckSignature(); err != nil || !ok || request.ID != request.GetID() {
+		return zap{}, errors.New("invalid zap request signature")
+	}
+	if request.Kind != 9734 || tagValue(request.Tags, "p") != recipient {
+		return zap{}, errors.New("zap request not for the relay")
+	}
+
+	invoice, err := decodeBolt11(tagValue(receipt.Tags, "bolt11"))
+	if err != nil {
+		return zap{}, fmt.Errorf("invalid bolt11: %w", err)
+	}
+	hash := sha256.Sum256([]byte(description))
+	if invoice.DescriptionHash == nil || *invoice.DescriptionHash != hash {
+		return zap{}, errors.New("bolt11 description hash doesn't match the zap request")
+	}
+	if invoice.MilliSat == nil || *invoice.MilliSat == 0 {
+		return zap{}, errors.New("bolt11 has no amount")
+	}
+	msat := int64(*invoice.MilliSat)
+	if amount := tagValue(request.Tags, "amount"); amount != "" && amount != strconv.FormatInt(msat, 10) {
+		return zap{}, errors.New("bolt11 amount doesn't match the zap request")
+	}
+
+	return zap{
+		Sender:      request.PubKey,
+		MilliSats:   msat,
+		PaymentHash: hex.EncodeToString(invoice.PaymentHash[:]),
+	}, nil
+}
+
+// isZapToRelay reports whether evt is the receipt of a zap to the relay,
+// with Zaps.
+func (r *Relay) isZapToRelay(evt *nostr.Event) bool {
+	return r.Zaps && r.pubkey != "" && evt.Kind == 9735 && tagValue(evt.Tags, "p") == r.pubkey
+}
+
+// acceptZap credits the sender of the zap of receipt, admitting them for
+// ever once they zapped TicketPriceSats in total.
+func (r *Relay) acceptZap(ctx context.Context, receipt *nostr.Event) error {
+	z, err := verifyZapReceipt(receipt, r.pubkey, r.ZapperPubkey)
+	if err != nil {
+		return err
+	}
+	total, err := r.admissions.credit(z, receipt.ID)
+	if err != nil {
+		return err
+	}
+	if total >= r.TicketPriceSats*1000 {
+		return r.admissions.admit(r.admissions.db, z.Sender, allKinds, 0)
+	}
+	return nil
+}
+
+// zapperPubkey returns the pubkey signing the zap receipts of the lightning
+// address, or LNURL, of LNURLAddress.
+func (r *Relay) zapperPubkey(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var params struct {
+		AllowsNostr bool   `json:"allowsNostr"`
+		NostrPubkey string `json:"nostrPubkey"`
+	}
+	if err := lnurlGet(ctx, (&lnurlProvider{Address: r.LNURLAddress}).url(), &params); err != nil {
+		return "", err
+	}
+	if !params.AllowsNostr || !isPubkey(params.NostrPubkey) {
+		return "", errors.New("the lnurl-pay service doesn't support zaps")
+	}
+	return params.NostrPubkey, nil
+}
+
+func tagValue(tags nostr.Tags, name string) string {
+	if tag := tags.GetFirst([]string{name, ""}); tag != nil && len(*tag) >= 2 {
+		return (*tag)[1]
+	}
+	return ""
+}
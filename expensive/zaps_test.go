@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/zpay32"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestIsZapToRelay(t *testing.T) {
+	relay, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	someone, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	r := &Relay{Zaps: true, pubkey: relay}
+	for _, tc := range []struct {
+		name string
+		evt  nostr.Event
+		zap  bool
+	}{
+		{"to the relay", nostr.Event{Kind: 9735, Tags: nostr.Tags{{"p", relay}}}, true},
+		{"to someone else", nostr.Event{Kind: 9735, Tags: nostr.Tags{{"p", someone}}}, false},
+		{"to no one", nostr.Event{Kind: 9735, Tags: nostr.Tags{}}, false},
+		{"not a receipt", nostr.Event{Kind: 1, Tags: nostr.Tags{{"p", relay}}}, false},
+	} {
+		if zap := r.isZapToRelay(&tc.evt); zap != tc.zap {
+			t.Errorf("%s: got %v", tc.name, zap)
+		}
+	}
+	if (&Relay{pubkey: relay}).isZapToRelay(&nostr.Event{Kind: 9735, Tags: nostr.Tags{{"p", relay}}}) {
+		t.Error("zap accepted without ZAPS")
+	}
+}
+
+func TestVerifyZapReceipt(t *testing.T) {
+	relayKey, zapperKey, senderKey := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	relay, _ := nostr.GetPublicKey(relayKey)
+	zapper, _ := nostr.GetPublicKey(zapperKey)
+	sender, _ := nostr.GetPublicKey(senderKey)
+
+	request := nostr.Event{
+		PubKey:    sender,
+		CreatedAt: time.Now(),
+		Kind:      9734,
+		Tags:      nostr.Tags{{"p", relay}, {"amount", "21000"}, {"relays", "wss://relay.example.com"}},
+	}
+	request.Sign(senderKey)
+	description, _ := json.Marshal(request)
+
+	receipt := func(key string, msat int64, description string) *nostr.Event {
+		hash := sha256.Sum256([]byte(description))
+		evt := &nostr.Event{
+			CreatedAt: time.Now(),
+			Kind:      9735,
+			Tags: nostr.Tags{
+				{"p", relay},
+				{"bolt11", signedBolt11(t, msat, zpay32.DescriptionHash(hash))},
+				{"description", description},
+			},
+		}
+		evt.PubKey, _ = nostr.GetPublicKey(key)
+		evt.Sign(key)
+		return evt
+	}
+
+	z, err := verifyZapReceipt(receipt(zapperKey, 21000, string(description)), relay, zapper)
+	if err != nil || z.Sender != sender || z.MilliSats != 21000 || len(z.PaymentHash) != 64 {
+		t.Errorf("valid receipt: %+v %v", z, err)
+	}
+
+	for name, evt := range map[string]*nostr.Event{
+		"not signed by the zapper": receipt(senderKey, 21000, string(description)),
+		"amount":                   receipt(zapperKey, 1000, string(description)),
+		"description hash": func() *nostr.Event {
+			evt := receipt(zapperKey, 21000, string(description)+" ")
+			evt.Tags[2][1] = string(description)
+			return evt
+		}(),
+		"zap request": receipt(zapperKey, 21000, strings.Replace(string(description), "wss://", "ws://", 1)),
+	} {
+		if _, err := verifyZapReceipt(evt, relay, zapper); err == nil {
+			t.Errorf("%s: invalid receipt verified", name)
+		}
+	}
+}
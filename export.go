@@ -0,0 +1,108 @@
+package relayer
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// exportPageSize is the number of events requested from a storage at once.
+// Storage implementations in this module cap results at 100 per query,
+// so a larger page wouldn't buy anything.
+const exportPageSize = 100
+
+// Export writes all events from store matching filter to w as newline-delimited JSON,
+// one event per line, most recent first.
+//
+// The filter's IDs, Authors, Kinds, Tags, Since and Until are passed to [Storage.QueryEvents]
+// as is, while Until and Limit are used to walk through the storage page by page.
+// A filter.Limit of zero means the whole matching history is exported.
+//
+// If progress is not nil, it is called after each page with the total number
+// of events written so far.
+func Export(store Storage, w io.Writer, filter nostr.Filter, progress func(written int)) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	max := filter.Limit
+	until := time.Now().Add(time.Second)
+	if filter.Until != nil {
+		until = *filter.Until
+	}
+
+	var written int
+	// ids already written which may be returned again by the next page,
+	// since it starts right after the oldest timestamp of the previous one.
+	boundary := make(map[string]struct{})
+
+	for {
+		page := filter
+		page.Until = &until
+		page.Limit = exportPageSize
+
+		events, err := store.QueryEvents(&page)
+		if err != nil {
+			return written, err
+		}
+		if len(events) == 0 {
+			break
+		}
+
+		fresh := 0
+		oldest := events[0].CreatedAt
+		for _, evt := range events {
+			if evt.CreatedAt.Before(oldest) {
+				oldest = evt.CreatedAt
+			}
+			if _, ok := boundary[evt.ID]; ok {
+				continue
+			}
+
+			if err := enc.Encode(evt); err != nil {
+				return written, err
+			}
+			fresh++
+			written++
+			if max > 0 && written >= max {
+				return written, bw.Flush()
+			}
+		}
+
+		if progress != nil {
+			progress(written)
+		}
+		if len(events) < exportPageSize {
+			// nothing older left
+			break
+		}
+
+		if fresh == 0 {
+			// the whole page falls within the same second, which is more than
+			// a storage returns at once. move on, possibly missing some of them.
+			if until.After(oldest) {
+				until = oldest
+			} else {
+				until = oldest.Add(-time.Second)
+			}
+			continue
+		}
+
+		// storage implementations differ as to whether Until is inclusive,
+		// so start the next page one second after the oldest one seen and skip
+		// whatever was already written at that timestamp.
+		until = oldest.Add(time.Second)
+		for id := range boundary {
+			delete(boundary, id)
+		}
+		for _, evt := range events {
+			if !evt.CreatedAt.After(until) {
+				boundary[evt.ID] = struct{}{}
+			}
+		}
+	}
+
+	return written, bw.Flush()
+}
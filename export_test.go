@@ -0,0 +1,114 @@
+package relayer
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer/storage/memory"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestExportImport(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	base := time.Unix(1670000000, 0)
+
+	// more events than a single page, several sharing the same second
+	var events []nostr.Event
+	for i := 0; i < 250; i++ {
+		evt := nostr.Event{
+			PubKey:    pk,
+			CreatedAt: base.Add(time.Duration(i/3) * time.Second),
+			Kind:      1 + i%2,
+			Tags:      nostr.Tags{},
+			Content:   strings.Repeat("x", i),
+		}
+		if err := evt.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, evt)
+	}
+
+	src := &memory.MemoryBackend{}
+	for _, evt := range events {
+		src.SaveEvent(&evt)
+	}
+
+	var buf bytes.Buffer
+	n, err := Export(src, &buf, nostr.Filter{}, nil)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if n != len(events) {
+		t.Errorf("Export wrote %d events; want %d", n, len(events))
+	}
+
+	// corrupt one line and import everything back, twice
+	data := buf.String() + "{\"id\":\"bogus\"}\n"
+	dst := &memory.MemoryBackend{}
+	filter := &nostr.Filter{Kinds: []int{1}}
+	stats, err := Import(dst, strings.NewReader(data), filter, nil)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	want := ImportStats{Read: 251, Saved: 125, Skipped: 125, Invalid: 1}
+	if stats != want {
+		t.Errorf("Import stats = %+v; want %+v", stats, want)
+	}
+
+	stats, _ = Import(dst, strings.NewReader(data), filter, nil)
+	want = ImportStats{Read: 251, Duplicate: 125, Skipped: 125, Invalid: 1}
+	if stats != want {
+		t.Errorf("second Import stats = %+v; want %+v", stats, want)
+	}
+
+	saved, _ := dst.QueryEvents(&nostr.Filter{Limit: 1000})
+	if len(saved) != 100 {
+		t.Errorf("got %d events from storage; want 100", len(saved))
+	}
+	for _, evt := range saved {
+		if evt.Kind != 1 {
+			t.Errorf("event %s of kind %d was imported", evt.ID, evt.Kind)
+		}
+	}
+}
+
+func TestImportDeletions(t *testing.T) {
+	sign := func(sk string, evt nostr.Event) nostr.Event {
+		evt.PubKey, _ = nostr.GetPublicKey(sk)
+		if err := evt.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		return evt
+	}
+	alice, mallory := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	base := time.Unix(1670000000, 0)
+	deleted := sign(alice, nostr.Event{CreatedAt: base, Kind: 1, Tags: nostr.Tags{}, Content: "oops"})
+	kept := sign(alice, nostr.Event{CreatedAt: base, Kind: 1, Tags: nostr.Tags{}, Content: "hello"})
+	deletion := sign(alice, nostr.Event{CreatedAt: base.Add(time.Second), Kind: 5, Tags: nostr.Tags{{"e", deleted.ID}}})
+	forged := sign(mallory, nostr.Event{CreatedAt: base.Add(time.Second), Kind: 5, Tags: nostr.Tags{{"e", kept.ID}}})
+
+	// as exported, deletions come before the events they delete
+	var buf bytes.Buffer
+	for _, evt := range []nostr.Event{deletion, forged, kept, deleted} {
+		line, _ := json.Marshal(evt)
+		buf.Write(append(line, '\n'))
+	}
+	dst := &memory.MemoryBackend{}
+	stats, err := Import(dst, &buf, nil, nil)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	want := ImportStats{Read: 4, Saved: 2, Deletions: 2}
+	if stats != want {
+		t.Errorf("Import stats = %+v; want %+v", stats, want)
+	}
+
+	saved, _ := dst.QueryEvents(&nostr.Filter{})
+	if len(saved) != 1 || saved[0].ID != kept.ID {
+		t.Errorf("got %v from storage; want only the event not deleted by its author", saved)
+	}
+}
@@ -1,14 +1,17 @@
 package relayer
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/fiatjaf/relayer/storage"
 	"github.com/gorilla/websocket"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip11"
@@ -40,17 +43,24 @@ var upgrader = websocket.Upgrader{
 
 func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 	store := s.relay.Storage()
-	advancedDeleter, _ := store.(AdvancedDeleter)
 	advancedQuerier, _ := store.(AdvancedQuerier)
+	storageName := fmt.Sprintf("%T", store)
+
+	var connID [4]byte
+	rand.Read(connID[:])
+	ip := clientIP(r, s.TrustedProxies)
+	log := s.logger().With("conn", hex.EncodeToString(connID[:]), "ip", ip)
+
+	if s.Admin != nil && s.Admin.IsIPBanned(ip) {
+		http.Error(w, "blocked", http.StatusForbidden)
+		return
+	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		s.Log.Errorf("failed to upgrade websocket: %v", err)
+		log.Error("failed to upgrade websocket", "error", err)
 		return
 	}
-	s.clientsMu.Lock()
-	defer s.clientsMu.Unlock()
-	s.clients[conn] = struct{}{}
 	ticker := time.NewTicker(pingPeriod)
 
 	// NIP-42 challenge
@@ -58,9 +68,16 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 	rand.Read(challenge)
 
 	ws := &WebSocket{
-		conn:      conn,
-		challenge: hex.EncodeToString(challenge),
+		conn:        conn,
+		challenge:   hex.EncodeToString(challenge),
+		id:          hex.EncodeToString(connID[:]),
+		ip:          ip,
+		connectedAt: time.Now(),
+		log:         log,
 	}
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	s.clients[conn] = ws
 
 	// reader
 	go func() {
@@ -96,7 +113,7 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 					websocket.CloseNoStatusReceived, // 1005
 					websocket.CloseAbnormalClosure,  // 1006
 				) {
-					s.Log.Warningf("unexpected close error from %s: %v", r.Header.Get("X-Forwarded-For"), err)
+					log.Warn("unexpected close error", "error", err)
 				}
 				break
 			}
@@ -127,12 +144,20 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 
 				var typ string
 				json.Unmarshal(request[0], &typ)
+				s.metrics.messages.Inc(messageType(typ))
+				debug := s.Debugging()
 
 				switch typ {
 				case "EVENT":
+					ctx, span := s.startSpan("EVENT")
+					defer span.End()
+
 					// it's a new event
+					_, decodeSpan := StartSpan(ctx, "decode")
 					var evt nostr.Event
 					if err := json.Unmarshal(request[1], &evt); err != nil {
+						decodeSpan.RecordError(err)
+						decodeSpan.End()
 						notice = "failed to decode event: " + err.Error()
 						return
 					}
@@ -143,39 +168,46 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 					// assign ID
 					hash := sha256.Sum256(serialized)
 					evt.ID = hex.EncodeToString(hash[:])
+					decodeSpan.End()
+					span.SetAttributes("event", evt.ID, "kind", evt.Kind)
+					if debug {
+						log.Debug("EVENT", "event", evt.ID, "kind", evt.Kind, "pubkey", evt.PubKey)
+					}
 
 					// check signature (requires the ID to be set)
-					if ok, err := evt.CheckSignature(); err != nil {
-						ws.WriteJSON([]interface{}{"OK", evt.ID, false, "error: failed to verify signature"})
+					_, verifySpan := StartSpan(ctx, "verify")
+					ok, err := evt.CheckSignature()
+					verifySpan.SetAttributes("valid", ok)
+					if err != nil {
+						verifySpan.RecordError(err)
+					}
+					verifySpan.End()
+					if err != nil {
+						s.writeOK(ws, evt.ID, false, "error: failed to verify signature")
 						return
 					} else if !ok {
-						ws.WriteJSON([]interface{}{"OK", evt.ID, false, "invalid: signature is invalid"})
+						s.writeOK(ws, evt.ID, false, "invalid: signature is invalid")
 						return
 					}
 
 					if evt.Kind == 5 {
 						// event deletion -- nip09
-						for _, tag := range evt.Tags {
-							if len(tag) >= 2 && tag[0] == "e" {
-								if advancedDeleter != nil {
-									advancedDeleter.BeforeDelete(tag[1], evt.PubKey)
-								}
-
-								if err := store.DeleteEvent(tag[1], evt.PubKey); err != nil {
-									ws.WriteJSON([]interface{}{"OK", evt.ID, false, fmt.Sprintf("error: %s", err.Error())})
-									return
-								}
-
-								if advancedDeleter != nil {
-									advancedDeleter.AfterDelete(tag[1], evt.PubKey)
-								}
-							}
+						if reason := s.Admin.reject(&evt); reason != "" {
+							s.writeOK(ws, evt.ID, false, reason)
+							return
+						}
+						if err := applyDeletion(ctx, store, &evt); err != nil {
+							s.writeOK(ws, evt.ID, false, fmt.Sprintf("error: %s", err.Error()))
 						}
 						return
 					}
 
-					ok, message := AddEvent(s.relay, evt)
-					ws.WriteJSON([]interface{}{"OK", evt.ID, ok, message})
+					if s.Admin != nil {
+						ctx = withAdmin(ctx, s.Admin)
+					}
+					ok, message := AddEventContext(ctx, s.relay, evt)
+					span.SetAttributes("ok", ok, "message", message)
+					s.writeOK(ws, evt.ID, ok, message)
 
 				case "REQ":
 					var id string
@@ -184,17 +216,35 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 						notice = "REQ has no <id>"
 						return
 					}
+					log := log.With("sub", id)
+					if debug {
+						log.Debug("REQ", "message", string(message))
+					}
+					ctx, span := s.startSpan("REQ")
+					defer span.End()
+					span.SetAttributes("sub", id, "filters", len(request)-2)
 
 					filters := make(nostr.Filters, len(request)-2)
 					for i, filterReq := range request[2:] {
-						if err := json.Unmarshal(
-							filterReq,
-							&filters[i],
-						); err != nil {
+						_, decodeSpan := StartSpan(ctx, "decode")
+						err := json.Unmarshal(filterReq, &filters[i])
+						if err != nil {
+							decodeSpan.RecordError(err)
+						}
+						decodeSpan.End()
+						if err != nil {
 							notice = "failed to decode filter"
 							return
 						}
+					}
+
+					if accepter, ok := s.relay.(ReqAccepter); ok && !accepter.AcceptReq(ctx, id, filters, ws.authed) {
+						span.SetAttributes("accepted", false)
+						notice = "restricted: this relay only serves authorized users, does your client implement NIP-42?"
+						return
+					}
 
+					for i := range filters {
 						filter := &filters[i]
 
 						// prevent kind-4 events from being returned to unauthed users,
@@ -226,9 +276,16 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 							advancedQuerier.BeforeQuery(filter)
 						}
 
-						events, err := store.QueryEvents(filter)
-						if err != nil {
-							s.Log.Errorf("store: %v", err)
+						start := time.Now()
+						events, err := queryEvents(ctx, store, filter)
+						s.metrics.queryDuration.ObserveSince(start, storageName)
+						if errors.Is(err, storage.ErrFilterTooLarge) {
+							// no EOSE, the subscription is over
+							span.RecordError(err)
+							notice = "error: " + err.Error()
+							return
+						} else if err != nil {
+							log.Error("failed to query storage", "error", err)
 							continue
 						}
 
@@ -236,6 +293,16 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 							advancedQuerier.AfterQuery(events, filter)
 						}
 
+						if s.Admin != nil {
+							visible := events[:0]
+							for _, event := range events {
+								if !s.Admin.hidden(&event) {
+									visible = append(visible, event)
+								}
+							}
+							events = visible
+						}
+
 						// this block should not trigger if the SQL query accounts for filter.Limit
 						// other implementations may be broken, and this ensures the client
 						// won't be bombarded.
@@ -258,6 +325,9 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 						notice = "CLOSE has no <id>"
 						return
 					}
+					if debug {
+						log.Debug("CLOSE", "sub", id)
+					}
 
 					removeListenerId(ws, id)
 				case "AUTH":
@@ -267,6 +337,9 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 							notice = "failed to decode auth event: " + err.Error()
 							return
 						}
+						if debug {
+							log.Debug("AUTH", "event", evt.ID)
+						}
 						if pubkey, ok := nip42.ValidateAuthEvent(&evt, ws.challenge, auther.ServiceURL()); ok {
 							ws.authed = pubkey
 							ws.WriteJSON([]interface{}{"OK", evt.ID, true, "authentication success"})
@@ -275,6 +348,9 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 						}
 					}
 				default:
+					if debug {
+						log.Debug("unknown message type", "type", typ)
+					}
 					if cwh, ok := s.relay.(CustomWebSocketHandler); ok {
 						cwh.HandleUnknownType(ws, typ, request)
 					} else {
@@ -297,7 +373,7 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 			case <-ticker.C:
 				err := ws.WriteMessage(websocket.PingMessage, nil)
 				if err != nil {
-					s.Log.Errorf("error writing ping: %v; closing websocket", err)
+					log.Error("error writing ping; closing websocket", "error", err)
 					return
 				}
 			}
@@ -305,6 +381,48 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 	}()
 }
 
+// startSpan starts a root span with the server tracer, in a context
+// passing it on to the storage and the relay.
+// applyDeletion deletes the events e-tagged by the deletion request evt,
+// as per NIP-09, with the store's AdvancedDeleter hooks, if any.
+// Only the events signed by the author of evt are deleted.
+func applyDeletion(ctx context.Context, store Storage, evt *nostr.Event) error {
+	advancedDeleter, _ := store.(AdvancedDeleter)
+	for _, tag := range evt.Tags {
+		if len(tag) >= 2 && tag[0] == "e" {
+			if advancedDeleter != nil {
+				advancedDeleter.BeforeDelete(tag[1], evt.PubKey)
+			}
+
+			if err := deleteEvent(ctx, store, tag[1], evt.PubKey); err != nil {
+				return err
+			}
+
+			if advancedDeleter != nil {
+				advancedDeleter.AfterDelete(tag[1], evt.PubKey)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) startSpan(name string) (context.Context, Span) {
+	tracer := s.Tracer
+	if tracer == nil {
+		tracer = NoopTracer
+	}
+	return StartSpan(ContextWithTracer(context.Background(), tracer), name)
+}
+
+// writeOK answers an EVENT message, counting the result in metrics.
+func (s *Server) writeOK(ws *WebSocket, id string, ok bool, message string) {
+	s.metrics.events.Inc(eventResult(ok, message))
+	if s.Debugging() {
+		ws.log.Debug("OK", "event", id, "ok", ok, "message", message)
+	}
+	ws.WriteJSON([]interface{}{"OK", id, ok, message})
+}
+
 func (s *Server) handleNIP11(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -312,6 +430,9 @@ func (s *Server) handleNIP11(w http.ResponseWriter, r *http.Request) {
 	if _, ok := s.relay.(Auther); ok {
 		supportedNIPs = append(supportedNIPs, 42)
 	}
+	if s.Admin != nil {
+		supportedNIPs = append(supportedNIPs, 86)
+	}
 
 	info := nip11.RelayInformationDocument{
 		Name:          s.relay.Name(),
@@ -327,5 +448,35 @@ func (s *Server) handleNIP11(w http.ResponseWriter, r *http.Request) {
 		info = ifmer.GetNIP11InformationDocument()
 	}
 
-	json.NewEncoder(w).Encode(info)
+	doc := nip11Document{RelayInformationDocument: info}
+	s.Admin.info(&doc)
+	if informer, ok := s.relay.(FeesInformer); ok {
+		fees := informer.NIP11Fees()
+		doc.Fees = &fees
+	}
+	json.NewEncoder(w).Encode(doc)
+}
+
+// nip11Document adds the fields missing from nip11.RelayInformationDocument.
+type nip11Document struct {
+	nip11.RelayInformationDocument
+	Icon string `json:"icon,omitempty"`
+	Fees *Fees  `json:"fees,omitempty"`
+}
+
+// Fees are the fees of a relay, as published in its NIP-11 document.
+type Fees struct {
+	Admission    []Fee `json:"admission,omitempty"`
+	Subscription []Fee `json:"subscription,omitempty"`
+	Publication  []Fee `json:"publication,omitempty"`
+}
+
+// Fee is one of the Fees of a relay.
+type Fee struct {
+	Amount int64  `json:"amount"`
+	Unit   string `json:"unit"`
+	// Period is how long a subscription lasts, in seconds.
+	Period int64 `json:"period,omitempty"`
+	// Kinds the fee applies to, all of them if empty.
+	Kinds []int `json:"kinds,omitempty"`
 }
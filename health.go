@@ -0,0 +1,84 @@
+package relayer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthChecker is implemented by relays and storages able to tell whether
+// they can serve requests, for example by pinging their database.
+// Server reports the result at /readyz.
+type HealthChecker interface {
+	// CheckHealth returns a non-nil error if requests can't be served.
+	// It should return early when ctx is done.
+	CheckHealth(ctx context.Context) error
+}
+
+// healthCheckTimeout bounds the time /readyz waits for all checks.
+const healthCheckTimeout = 5 * time.Second
+
+// healthReport is the body of /healthz and /readyz responses.
+type healthReport struct {
+	Status string `json:"status"`
+	// Checks maps "relay" and "storage" to "ok" or the error they returned.
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// handleHealthz answers liveness probes: the server is up if it can answer.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, http.StatusOK, healthReport{Status: "ok"})
+}
+
+// handleReadyz answers readiness probes, running the relay and storage
+// HealthChecker, if any. It reports unavailability during Shutdown.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if s.isShuttingDown() {
+		writeHealthReport(w, http.StatusServiceUnavailable, healthReport{Status: "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	checkers := map[string]any{"relay": s.relay, "storage": s.relay.Storage()}
+	report := healthReport{Status: "ok", Checks: make(map[string]string)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, impl := range checkers {
+		checker, ok := impl.(HealthChecker)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, checker HealthChecker) {
+			defer wg.Done()
+			result := "ok"
+			if err := checker.CheckHealth(ctx); err != nil {
+				result = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result != "ok" {
+				report.Status = "unavailable"
+			}
+		}(name, checker)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealthReport(w, status, report)
+}
+
+func writeHealthReport(w http.ResponseWriter, status int, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,81 @@
+package relayer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	store := &checkedStorage{}
+	srv := NewServer("127.0.0.1:0", &testRelay{storage: store})
+
+	get := func(path string) (int, healthReport) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var report healthReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatalf("GET %s: %v: %s", path, err, rec.Body)
+		}
+		return rec.Code, report
+	}
+
+	if code, report := get("/healthz"); code != http.StatusOK || report.Status != "ok" {
+		t.Errorf("/healthz = %d %+v", code, report)
+	}
+	if code, report := get("/readyz"); code != http.StatusOK || report.Checks["storage"] != "ok" {
+		t.Errorf("/readyz = %d %+v", code, report)
+	}
+
+	store.err = errors.New("connection refused")
+	if code, report := get("/readyz"); code != http.StatusServiceUnavailable || report.Checks["storage"] != "connection refused" {
+		t.Errorf("/readyz with failing storage = %d %+v", code, report)
+	}
+
+	store.err = nil
+	srv.shuttingDown = 1
+	if code, report := get("/readyz"); code != http.StatusServiceUnavailable || report.Status != "shutting down" {
+		t.Errorf("/readyz during shutdown = %d %+v", code, report)
+	}
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz during shutdown = %d", code)
+	}
+}
+
+type checkedStorage struct {
+	testStorage
+	err error
+}
+
+func (s *checkedStorage) CheckHealth(ctx context.Context) error { return s.err }
+
+func TestShutdownDelay(t *testing.T) {
+	srv := startTestRelay(t, &testRelay{storage: &testStorage{}})
+	srv.ShutdownDelay = 500 * time.Millisecond
+	done := make(chan struct{})
+	go func() {
+		srv.Shutdown(context.Background())
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	res, err := http.Get("http://" + srv.Addr() + "/readyz")
+	if err != nil {
+		t.Fatalf("/readyz unreachable during the shutdown delay: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("/readyz during the shutdown delay = %d", res.StatusCode)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Error("Shutdown too long to return")
+	}
+}
@@ -0,0 +1,114 @@
+package relayer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/fiatjaf/relayer/storage"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Import progress is reported every importProgressInterval lines read.
+const importProgressInterval = 1000
+
+// ImportStats summarizes the outcome of an [Import].
+type ImportStats struct {
+	// Read is the number of non-empty lines read from the input.
+	Read int
+	// Saved is the number of events passed on to [Storage.SaveEvent] successfully.
+	Saved int
+	// Duplicate is the number of events the storage already had.
+	Duplicate int
+	// Skipped is the number of valid events which didn't match the filter
+	// or are ephemeral.
+	Skipped int
+	// Invalid is the number of lines which failed to decode or didn't have
+	// a valid id or signature.
+	Invalid int
+	// Deletions is the number of deletion requests (kind 5) applied.
+	// Like those received from clients, they are not saved.
+	Deletions int
+	// Failed is the number of events the storage failed to save
+	// or to delete the events of.
+	Failed int
+}
+
+func (s ImportStats) String() string {
+	return fmt.Sprintf("read %d, saved %d, duplicate %d, skipped %d, invalid %d, deletions %d, failed %d",
+		s.Read, s.Saved, s.Duplicate, s.Skipped, s.Invalid, s.Deletions, s.Failed)
+}
+
+// Import reads newline-delimited JSON events from r, as written by [Export],
+// and saves them into store.
+//
+// Each event goes through the same checks as those received from clients:
+// its id must match the serialized event and the signature must be valid.
+// Events are then saved with the store's AdvancedSaver hooks, if any.
+// Deletion requests delete the events they refer to signed by the same
+// author, as they do when received from clients. They are applied once all
+// the other events are saved, since exports list the events they delete
+// after them.
+// Invalid events, duplicates and storage failures are counted in the returned stats
+// and do not interrupt the import.
+//
+// If filter is not nil, only events matching it are saved.
+// If progress is not nil, it is called periodically and once more at the end.
+//
+// The returned error is non-nil only if reading from r fails.
+func Import(store Storage, r io.Reader, filter *nostr.Filter, progress func(ImportStats)) (ImportStats, error) {
+	var stats ImportStats
+
+	var deletions []nostr.Event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		stats.Read++
+
+		var evt nostr.Event
+		if err := json.Unmarshal(line, &evt); err != nil {
+			stats.Invalid++
+		} else if evt.GetID() != evt.ID {
+			stats.Invalid++
+		} else if ok, _ := evt.CheckSignature(); !ok {
+			stats.Invalid++
+		} else if isEphemeral(evt.Kind) || (filter != nil && !filter.Matches(&evt)) {
+			stats.Skipped++
+		} else if evt.Kind == 5 {
+			deletions = append(deletions, evt)
+		} else {
+			switch err := saveEvent(context.Background(), store, &evt); err {
+			case nil:
+				stats.Saved++
+			case storage.ErrDupEvent:
+				stats.Duplicate++
+			default:
+				stats.Failed++
+			}
+		}
+
+		if progress != nil && stats.Read%importProgressInterval == 0 {
+			progress(stats)
+		}
+	}
+
+	for _, evt := range deletions {
+		if err := applyDeletion(context.Background(), store, &evt); err != nil {
+			stats.Failed++
+		} else {
+			stats.Deletions++
+		}
+	}
+
+	if progress != nil {
+		progress(stats)
+	}
+	return stats, scanner.Err()
+}
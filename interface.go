@@ -35,6 +35,16 @@ type Auther interface {
 	ServiceURL() string
 }
 
+// ReqAccepter is implemented by relays which restrict who can read from them.
+// AcceptReq is called for every REQ, with the pubkey the client authenticated
+// as through NIP-42, if any. Rejected subscriptions get a NOTICE instead of
+// events. Authenticating requires the relay to also be an [Auther].
+type ReqAccepter interface {
+	AcceptReq(ctx context.Context, id string, filters nostr.Filters, authedPubkey string) bool
+}
+
+// Injector is implemented by relays, or storages, which have events to send to
+// subscribers other than those received from clients. See [Server.Start].
 type Injector interface {
 	InjectEvents() chan nostr.Event
 }
@@ -46,6 +56,12 @@ type Informationer interface {
 	GetNIP11InformationDocument() nip11.RelayInformationDocument
 }
 
+// FeesInformer is implemented by paid relays, to publish their fees in
+// the NIP-11 document served by [Server].
+type FeesInformer interface {
+	NIP11Fees() Fees
+}
+
 // CustomWebSocketHandler, if implemented, is passed nostr message types unrecognized
 // by the server.
 // The server handles "EVENT", "REQ" and "CLOSE" messages, as described in NIP-01.
@@ -60,12 +76,27 @@ type ShutdownAware interface {
 }
 
 // Logger is what [Server] uses to log messages.
+// It may also be a [StructuredLogger], which Server then uses for its own messages.
 type Logger interface {
 	Infof(format string, v ...any)
 	Warningf(format string, v ...any)
 	Errorf(format string, v ...any)
 }
 
+// StructuredLogger logs messages along with key/value pairs, such as the
+// connection or the subscription they are about, like [log/slog] does.
+// See [Structured] to turn any Logger into one and [NewSlogLogger] for slog.
+type StructuredLogger interface {
+	Logger
+	Debug(msg string, keysAndValues ...any)
+	Info(msg string, keysAndValues ...any)
+	Warn(msg string, keysAndValues ...any)
+	Error(msg string, keysAndValues ...any)
+
+	// With returns a logger adding keysAndValues to every message.
+	With(keysAndValues ...any) StructuredLogger
+}
+
 // Storage is a persistence layer for nostr events handled by a relay.
 type Storage interface {
 	// Init is called at the very beginning by [Server.Start], after [Relay.Init],
@@ -97,3 +128,20 @@ type AdvancedSaver interface {
 	BeforeSave(*nostr.Event)
 	AfterSave(*nostr.Event)
 }
+
+// ContextQuerier is implemented by storages able to use the context of a REQ,
+// for cancellation and tracing (see [StartSpan]). Server then calls
+// QueryEventsContext instead of [Storage.QueryEvents].
+type ContextQuerier interface {
+	QueryEventsContext(ctx context.Context, filter *nostr.Filter) ([]nostr.Event, error)
+}
+
+// ContextSaver is like [ContextQuerier], for [Storage.SaveEvent].
+type ContextSaver interface {
+	SaveEventContext(ctx context.Context, event *nostr.Event) error
+}
+
+// ContextDeleter is like [ContextQuerier], for [Storage.DeleteEvent].
+type ContextDeleter interface {
+	DeleteEventContext(ctx context.Context, id string, pubkey string) error
+}
@@ -2,6 +2,7 @@ package relayer
 
 import (
 	"sync"
+	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 )
@@ -91,6 +92,8 @@ func removeListener(ws *WebSocket) {
 }
 
 func notifyListeners(event *nostr.Event) {
+	defer fanoutDuration.ObserveSince(time.Now())
+
 	listenersMutex.Lock()
 	defer func() {
 		listenersMutex.Unlock()
@@ -0,0 +1,79 @@
+package relayer
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Structured returns l if it is a StructuredLogger, or else a StructuredLogger
+// writing messages and their key/value pairs as text lines to l.
+func Structured(l Logger) StructuredLogger {
+	if sl, ok := l.(StructuredLogger); ok {
+		return sl
+	}
+	return textLogger{l: l}
+}
+
+// textLogger formats messages as "msg key=value ...".
+type textLogger struct {
+	l      Logger
+	fields []any
+}
+
+func (t textLogger) Infof(format string, v ...any)    { t.Info(fmt.Sprintf(format, v...)) }
+func (t textLogger) Warningf(format string, v ...any) { t.Warn(fmt.Sprintf(format, v...)) }
+func (t textLogger) Errorf(format string, v ...any)   { t.Error(fmt.Sprintf(format, v...)) }
+
+func (t textLogger) Debug(msg string, kv ...any) { t.l.Infof("%s", t.format("debug: "+msg, kv)) }
+func (t textLogger) Info(msg string, kv ...any)  { t.l.Infof("%s", t.format(msg, kv)) }
+func (t textLogger) Warn(msg string, kv ...any)  { t.l.Warningf("%s", t.format(msg, kv)) }
+func (t textLogger) Error(msg string, kv ...any) { t.l.Errorf("%s", t.format(msg, kv)) }
+
+func (t textLogger) With(kv ...any) StructuredLogger {
+	fields := make([]any, 0, len(t.fields)+len(kv))
+	fields = append(append(fields, t.fields...), kv...)
+	return textLogger{l: t.l, fields: fields}
+}
+
+func (t textLogger) format(msg string, kv []any) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	writeFields(&b, t.fields)
+	writeFields(&b, kv)
+	return b.String()
+}
+
+func writeFields(b *strings.Builder, kv []any) {
+	for i := 0; i < len(kv); i += 2 {
+		var key, value any = "!BADKEY", kv[i]
+		if i+1 < len(kv) {
+			key, value = kv[i], kv[i+1]
+		}
+		s := fmt.Sprint(value)
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = fmt.Sprintf("%q", s)
+		}
+		fmt.Fprintf(b, " %v=%s", key, s)
+	}
+}
+
+// NewSlogLogger returns a StructuredLogger writing to l. Debug messages are
+// logged at [slog.LevelDebug], so l's handler must be enabled for that level
+// for them to be written, in addition to [Server.SetDebug].
+func NewSlogLogger(l *slog.Logger) StructuredLogger {
+	return slogLogger{l}
+}
+
+type slogLogger struct{ l *slog.Logger }
+
+func (s slogLogger) Infof(format string, v ...any)    { s.l.Info(fmt.Sprintf(format, v...)) }
+func (s slogLogger) Warningf(format string, v ...any) { s.l.Warn(fmt.Sprintf(format, v...)) }
+func (s slogLogger) Errorf(format string, v ...any)   { s.l.Error(fmt.Sprintf(format, v...)) }
+
+func (s slogLogger) Debug(msg string, kv ...any) { s.l.Debug(msg, kv...) }
+func (s slogLogger) Info(msg string, kv ...any)  { s.l.Info(msg, kv...) }
+func (s slogLogger) Warn(msg string, kv ...any)  { s.l.Warn(msg, kv...) }
+func (s slogLogger) Error(msg string, kv ...any) { s.l.Error(msg, kv...) }
+
+func (s slogLogger) With(kv ...any) StructuredLogger { return slogLogger{s.l.With(kv...)} }
@@ -0,0 +1,120 @@
+package relayer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer/storage/memory"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+type recordingLogger struct{ lines []string }
+
+func (l *recordingLogger) Infof(format string, v ...any) {
+	l.lines = append(l.lines, "INFO "+fmt.Sprintf(format, v...))
+}
+func (l *recordingLogger) Warningf(format string, v ...any) {
+	l.lines = append(l.lines, "WARN "+fmt.Sprintf(format, v...))
+}
+func (l *recordingLogger) Errorf(format string, v ...any) {
+	l.lines = append(l.lines, "ERROR "+fmt.Sprintf(format, v...))
+}
+
+func TestStructured(t *testing.T) {
+	rec := &recordingLogger{}
+	log := Structured(rec).With("conn", "ab12", "ip", "10.0.0.1")
+	log.With("sub", "my sub").Debug("REQ", "message", `["REQ"]`)
+	log.Error("failed", "error", fmt.Errorf("oops"))
+	log.Warningf("closing %d", 3)
+
+	want := []string{
+		`INFO debug: REQ conn=ab12 ip=10.0.0.1 sub="my sub" message="[\"REQ\"]"`,
+		`ERROR failed conn=ab12 ip=10.0.0.1 error=oops`,
+		`WARN closing 3 conn=ab12 ip=10.0.0.1`,
+	}
+	if strings.Join(rec.lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("logged\n%s\nwant\n%s", strings.Join(rec.lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	if sl := NewSlogLogger(slog.Default()); Structured(sl) != sl {
+		t.Error("Structured didn't return the StructuredLogger as is")
+	}
+}
+
+func TestDefaultLoggerLevels(t *testing.T) {
+	var buf bytes.Buffer
+	l := defaultLogger("relay: ")
+	l.(stdLogger).log.SetOutput(&buf)
+	l.(stdLogger).log.SetFlags(log.Lmsgprefix)
+	l.Infof("listening on %s", ":7447")
+	l.Warningf("closing %d", 3)
+	l.Errorf("failed: %v", "oops")
+
+	want := "relay: listening on :7447\nrelay: warning: closing 3\nrelay: error: failed: oops\n"
+	if buf.String() != want {
+		t.Errorf("logged\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestServerDebugLogging(t *testing.T) {
+	var buf lockedBuffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	rl := &testRelay{storage: &memory.MemoryBackend{}}
+	srv := NewServer("127.0.0.1:0", rl)
+	srv.Log = NewSlogLogger(slog.New(handler))
+	ready := make(chan struct{})
+	rl.onInitialized = func(*Server) { close(ready) }
+	go srv.Start()
+	<-ready
+	defer srv.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client, err := nostr.RelayConnect(ctx, "ws://"+srv.Addr())
+	if err != nil {
+		t.Fatalf("nostr.RelayConnect: %v", err)
+	}
+	defer client.Close()
+
+	req := func() {
+		sub := client.Subscribe(ctx, nostr.Filters{{Kinds: []int{1}}})
+		<-sub.EndOfStoredEvents
+	}
+
+	req()
+	if got := buf.String(); strings.Contains(got, "level=DEBUG") {
+		t.Errorf("logged with debug off: %s", got)
+	}
+	srv.SetDebug(true)
+	req()
+
+	got := buf.String()
+	if !regexp.MustCompile(`level=DEBUG msg=REQ conn=[0-9a-f]{8} ip=127.0.0.1 sub=[0-9a-f]+ message=`).MatchString(got) {
+		t.Errorf("REQ not logged with debug on: %s", got)
+	}
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
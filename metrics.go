@@ -0,0 +1,415 @@
+package relayer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics is a registry of counters, gauges and histograms, exposed in the
+// Prometheus text format. [Server] collects its own metrics in one, served at
+// [Settings.MetricsPath] if set, and relays and storages can add theirs by being a [MetricsReporter].
+//
+// Metrics are identified by name: asking twice for the same name returns
+// the same metric, while asking for a different type of metric panics.
+type Metrics struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+	names   []string // in registration order
+}
+
+// MetricsReporter is implemented by relays and storages exposing metrics
+// of their own. RegisterMetrics is called by [Server.Start], after Init.
+type MetricsReporter interface {
+	RegisterMetrics(m *Metrics)
+}
+
+// DefaultBuckets are histogram buckets suited to durations in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// NewMetrics returns an empty registry.
+func NewMetrics() *Metrics {
+	return &Metrics{metrics: make(map[string]metric)}
+}
+
+func (m *Metrics) register(name string, create func() metric) metric {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.metrics[name]; ok {
+		return existing
+	}
+	created := create()
+	m.metrics[name] = created
+	m.names = append(m.names, name)
+	return created
+}
+
+// Counter returns the counter with the given name, creating it if needed.
+func (m *Metrics) Counter(name, help string, labelNames ...string) *Counter {
+	return m.register(name, func() metric {
+		return &Counter{vec: newVec(name, help, "counter", labelNames)}
+	}).(*Counter)
+}
+
+// Gauge returns the gauge with the given name, creating it if needed.
+func (m *Metrics) Gauge(name, help string, labelNames ...string) *Gauge {
+	return m.register(name, func() metric {
+		return &Gauge{vec: newVec(name, help, "gauge", labelNames)}
+	}).(*Gauge)
+}
+
+// Histogram returns the histogram with the given name, creating it with the
+// given bucket upper bounds, or DefaultBuckets if nil, if needed.
+func (m *Metrics) Histogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return m.register(name, func() metric {
+		return newHistogram(name, help, buckets, labelNames...)
+	}).(*Histogram)
+}
+
+// CounterFunc registers a counter whose value is returned by fn when collected.
+func (m *Metrics) CounterFunc(name, help string, fn func() float64) {
+	m.register(name, func() metric { return &funcMetric{name, help, "counter", fn} })
+}
+
+// GaugeFunc registers a gauge whose value is returned by fn when collected.
+func (m *Metrics) GaugeFunc(name, help string, fn func() float64) {
+	m.register(name, func() metric { return &funcMetric{name, help, "gauge", fn} })
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	metrics := make([]metric, len(m.names))
+	for i, name := range m.names {
+		metrics[i] = m.metrics[name]
+	}
+	m.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, metric := range metrics {
+		metric.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics to Prometheus scrapers.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// vec holds the values of a metric for every set of label values.
+type vec struct {
+	name, help, typ string
+	labelNames      []string
+
+	mu     sync.Mutex
+	values map[string]*value
+}
+
+type value struct {
+	labelValues []string
+	v           float64
+}
+
+func newVec(name, help, typ string, labelNames []string) vec {
+	return vec{name: name, help: help, typ: typ, labelNames: labelNames, values: make(map[string]*value)}
+}
+
+// get returns the value for labelValues, vec.mu being held.
+func (v *vec) get(labelValues []string) *value {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	val, ok := v.values[key]
+	if !ok {
+		val = &value{labelValues: append([]string(nil), labelValues...)}
+		v.values[key] = val
+	}
+	return val
+}
+
+func (v *vec) add(delta float64, labelValues []string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.get(labelValues).v += delta
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	writeHeader(w, v.name, v.help, v.typ)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, val := range sortedValues(v.values) {
+		writeSample(w, v.name, v.labelNames, val.labelValues, "", "", val.v)
+	}
+}
+
+// Counter is a metric which only goes up.
+type Counter struct{ vec }
+
+// Inc adds one to the counter for the given label values.
+func (c *Counter) Inc(labelValues ...string) { c.add(1, labelValues) }
+
+// Add adds delta, which must not be negative, to the counter for the given label values.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("counter " + c.name + " can't decrease")
+	}
+	c.add(delta, labelValues)
+}
+
+// Gauge is a metric which can go up and down.
+type Gauge struct{ vec }
+
+// Add adds delta to the gauge for the given label values.
+func (g *Gauge) Add(delta float64, labelValues ...string) { g.add(delta, labelValues) }
+
+// Set sets the gauge for the given label values.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(labelValues).v = v
+}
+
+// Histogram counts observations, such as durations, in buckets.
+type Histogram struct {
+	name, help string
+	buckets    []float64
+	labelNames []string
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+func newHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{
+		name:       name,
+		help:       help,
+		buckets:    buckets,
+		labelNames: labelNames,
+		series:     make(map[string]*histogramSeries),
+	}
+}
+
+// Observe records v for the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labelNames) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", h.name, len(h.labelNames), len(labelValues)))
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// ObserveSince records the time elapsed since start, in seconds.
+func (h *Histogram) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.name+"_bucket", h.labelNames, s.labelValues, "le", formatFloat(upper), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labelNames, s.labelValues, "le", "+Inf", float64(s.count))
+		writeSample(w, h.name+"_sum", h.labelNames, s.labelValues, "", "", s.sum)
+		writeSample(w, h.name+"_count", h.labelNames, s.labelValues, "", "", float64(s.count))
+	}
+}
+
+type funcMetric struct {
+	name, help, typ string
+	fn              func() float64
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	writeHeader(w, f.name, f.help, f.typ)
+	writeSample(w, f.name, nil, nil, "", "", f.fn())
+}
+
+func sortedValues(values map[string]*value) []*value {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sorted := make([]*value, len(keys))
+	for i, key := range keys {
+		sorted[i] = values[key]
+	}
+	return sorted
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, typ)
+}
+
+// writeSample writes a sample line, with an extra label if extraName isn't empty.
+func writeSample(w *bufio.Writer, name string, labelNames, labelValues []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, labelName, labelEscaper.Replace(labelValues[i]))
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// these are shared by all servers of the process, as are subscriptions.
+var (
+	fanoutDuration = newHistogram("relayer_fanout_duration_seconds",
+		"Time taken to send an event to the matching subscriptions.", nil)
+	writeFailures = &Counter{vec: newVec("relayer_websocket_write_failures_total",
+		"Messages which couldn't be written to a client connection.", "counter", nil)}
+)
+
+// serverMetrics are the metrics collected by a Server.
+type serverMetrics struct {
+	messages      *Counter
+	events        *Counter
+	queryDuration *Histogram
+}
+
+func newServerMetrics(s *Server) serverMetrics {
+	m := s.Metrics
+	m.GaugeFunc("relayer_connections_open", "Websocket connections currently open.", func() float64 {
+		s.clientsMu.Lock()
+		defer s.clientsMu.Unlock()
+		return float64(len(s.clients))
+	})
+	m.GaugeFunc("relayer_subscriptions_active", "Subscriptions currently open, on all connections.", func() float64 {
+		listenersMutex.Lock()
+		defer listenersMutex.Unlock()
+		n := 0
+		for _, subs := range listeners {
+			n += len(subs)
+		}
+		return float64(n)
+	})
+	m.register(fanoutDuration.name, func() metric { return fanoutDuration })
+	m.register(writeFailures.name, func() metric { return writeFailures })
+
+	return serverMetrics{
+		messages: m.Counter("relayer_messages_total",
+			"Messages received from clients, by type.", "type"),
+		events: m.Counter("relayer_events_total",
+			`Events received from clients, by result: "accepted", or the reason prefix of the OK message, such as "blocked" or "invalid".`, "result"),
+		queryDuration: m.Histogram("relayer_query_duration_seconds",
+			"Time taken by the storage to answer a REQ filter.", nil, "storage"),
+	}
+}
+
+// messageType returns the label for a client message type, keeping unknown ones together.
+func messageType(typ string) string {
+	switch typ {
+	case "EVENT", "REQ", "CLOSE", "AUTH":
+		return typ
+	default:
+		return "other"
+	}
+}
+
+// eventResult returns the label for the outcome of an EVENT message.
+func eventResult(ok bool, message string) string {
+	if ok && message == "" {
+		return "accepted"
+	}
+	if reason, _, found := strings.Cut(message, ":"); found && !strings.Contains(reason, " ") {
+		return reason
+	}
+	if ok {
+		return "accepted"
+	}
+	return "rejected"
+}
@@ -0,0 +1,140 @@
+package relayer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer/storage/memory"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMetricsWriteTo(t *testing.T) {
+	m := NewMetrics()
+	c := m.Counter("test_messages_total", "Messages,\nby type.", "type")
+	c.Inc("REQ")
+	c.Add(2, `"quoted"`)
+	if m.Counter("test_messages_total", "") != c {
+		t.Error("Counter returned a different counter for the same name")
+	}
+	m.GaugeFunc("test_open", "Open things.", func() float64 { return 3 })
+	h := m.Histogram("test_duration_seconds", "Durations.", []float64{1, 0.1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	var b strings.Builder
+	n, err := m.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_messages_total Messages,\nby type.
+# TYPE test_messages_total counter
+test_messages_total{type="\"quoted\""} 2
+test_messages_total{type="REQ"} 1
+# HELP test_open Open things.
+# TYPE test_open gauge
+test_open 3
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 5.55
+test_duration_seconds_count 3
+`
+	if b.String() != want {
+		t.Errorf("WriteTo wrote\n%s\nwant\n%s", b.String(), want)
+	}
+	if n != int64(len(want)) {
+		t.Errorf("WriteTo returned %d; want %d", n, len(want))
+	}
+}
+
+func TestEventResult(t *testing.T) {
+	for _, tc := range []struct {
+		ok      bool
+		message string
+		want    string
+	}{
+		{true, "", "accepted"},
+		{true, "duplicate: already have this event", "duplicate"},
+		{false, "blocked: event blocked by relay", "blocked"},
+		{false, "error: failed to save event", "error"},
+		{false, "something went wrong: oops", "rejected"},
+	} {
+		if got := eventResult(tc.ok, tc.message); got != tc.want {
+			t.Errorf("eventResult(%v, %q) = %q; want %q", tc.ok, tc.message, got, tc.want)
+		}
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	rl := &testRelay{storage: &memory.MemoryBackend{}}
+	srv := NewServer("127.0.0.1:0", rl)
+	srv.MetricsPath = "/internal/metrics"
+	ready := make(chan struct{})
+	rl.onInitialized = func(*Server) { close(ready) }
+	go srv.Start()
+	<-ready
+	defer srv.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client, err := nostr.RelayConnect(ctx, "ws://"+srv.Addr())
+	if err != nil {
+		t.Fatalf("nostr.RelayConnect: %v", err)
+	}
+	defer client.Close()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	evt := nostr.Event{PubKey: pk, Kind: 1, CreatedAt: time.Now(), Tags: nostr.Tags{}}
+	evt.Sign(sk)
+	if ok, msg := publishEvent(t, srv.Addr(), evt); !ok {
+		t.Fatalf("event not accepted: %s", msg)
+	}
+	sub := client.Subscribe(ctx, nostr.Filters{{Kinds: []int{1}}})
+	<-sub.Events
+	<-sub.EndOfStoredEvents
+
+	res, err := http.Get("http://" + srv.Addr() + "/internal/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	for _, line := range []string{
+		"relayer_connections_open ",     // the publishing one may not be closed yet
+		"relayer_subscriptions_active ", // global, so other tests' may still be there
+		`relayer_messages_total{type="EVENT"} 1` + "\n",
+		`relayer_messages_total{type="REQ"} 1` + "\n",
+		`relayer_events_total{result="accepted"} 1` + "\n",
+		`relayer_query_duration_seconds_count{storage="*memory.MemoryBackend"} 1` + "\n",
+		"# TYPE relayer_fanout_duration_seconds histogram\n",
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("metrics don't contain %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestServerMetricsOptIn(t *testing.T) {
+	srv := startTestRelay(t, &testRelay{storage: &memory.MemoryBackend{}})
+	defer srv.Shutdown(context.Background())
+
+	res, err := http.Get("http://" + srv.Addr() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("metrics served without MetricsPath: %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,279 @@
+package relayer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/exp/slices"
+)
+
+// ManagementHandler is implemented by relays which want a say in NIP-86
+// management requests, served on the relay URL when [Server.Admin] is set.
+type ManagementHandler interface {
+	// ManagementMethods returns the methods added by the relay, reported
+	// by "supportedmethods" along with the default ones.
+	ManagementMethods() []string
+
+	// HandleManagement is called before the default handling of every
+	// method, with the pubkey of the admin making the request. A non-nil
+	// err is returned to the client, vetoing the request. If handled is
+	// true, result is returned and the default handling is skipped.
+	HandleManagement(ctx context.Context, pubkey, method string, params []json.RawMessage) (result any, handled bool, err error)
+}
+
+// nip86Request is the body of NIP-86 requests.
+type nip86Request struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// nip86Response is the body of NIP-86 responses.
+type nip86Response struct {
+	Result any    `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// nip86Methods are the methods handled by Admin.
+var nip86Methods = []string{
+	"supportedmethods",
+	"banpubkey", "allowpubkey", "listbannedpubkeys", "listallowedpubkeys",
+	"listeventsneedingmoderation", "banevent", "allowevent", "listbannedevents",
+	"changerelayname", "changerelaydescription", "changerelayicon",
+	"allowkind", "disallowkind", "listallowedkinds",
+	"blockip", "unblockip", "listblockedips",
+}
+
+// pubkeyReason, eventReason and ipReason are the items of NIP-86 lists.
+type pubkeyReason struct {
+	Pubkey string `json:"pubkey"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type eventReason struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type ipReason struct {
+	IP     string `json:"ip"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// handleNIP86 serves requests authenticated by NIP98Auth.
+func (a *Admin) handleNIP86(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pubkey := NIP98Pubkey(r.Context())
+	if !slices.Contains(a.Pubkeys, pubkey) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(nip86Response{Error: "not an admin"})
+		return
+	}
+
+	var req nip86Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(nip86Response{Error: "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := a.manage(r.Context(), pubkey, req.Method, req.Params)
+	if err != nil {
+		a.server.logger().Warn("management request failed", "pubkey", pubkey, "method", req.Method, "error", err)
+		json.NewEncoder(w).Encode(nip86Response{Error: err.Error()})
+		return
+	}
+	a.server.logger().Info("management request", "pubkey", pubkey, "method", req.Method)
+	json.NewEncoder(w).Encode(nip86Response{Result: result})
+}
+
+// manage runs method, letting the relay veto or handle it first.
+func (a *Admin) manage(ctx context.Context, pubkey, method string, params []json.RawMessage) (any, error) {
+	handler, _ := a.server.relay.(ManagementHandler)
+	if handler != nil {
+		result, handled, err := handler.HandleManagement(ctx, pubkey, method, params)
+		if err != nil {
+			return nil, err
+		}
+		if handled {
+			return result, nil
+		}
+	}
+
+	switch method {
+	case "supportedmethods":
+		methods := append([]string{}, nip86Methods...)
+		if handler != nil {
+			for _, m := range handler.ManagementMethods() {
+				if !slices.Contains(methods, m) {
+					methods = append(methods, m)
+				}
+			}
+		}
+		return methods, nil
+
+	case "banpubkey", "allowpubkey":
+		var pubkey, reason string
+		if err := parseParams(params, &pubkey, &reason); err != nil {
+			return nil, err
+		}
+		if !isHex64(pubkey) {
+			return nil, errors.New("invalid pubkey")
+		}
+		change := a.BanPubkey
+		if method == "allowpubkey" {
+			change = a.AllowPubkey
+		}
+		return true, change(pubkey, reason)
+
+	case "listbannedpubkeys", "listallowedpubkeys":
+		set := a.bannedPubkeys
+		if method == "listallowedpubkeys" {
+			set = a.allowedPubkeys
+		}
+		list := []pubkeyReason{}
+		for _, pk := range a.list(set) {
+			list = append(list, pubkeyReason{Pubkey: pk[0], Reason: pk[1]})
+		}
+		return list, nil
+
+	case "listeventsneedingmoderation":
+		// events are never held for moderation
+		return []eventReason{}, nil
+
+	case "banevent":
+		var id, reason string
+		if err := parseParams(params, &id, &reason); err != nil {
+			return nil, err
+		}
+		if !isHex64(id) {
+			return nil, errors.New("invalid event id")
+		}
+		if err := a.update(func() { a.bannedEvents[id] = reason }); err != nil {
+			return nil, err
+		}
+		store := a.server.relay.Storage()
+		events, err := queryEvents(ctx, store, &nostr.Filter{IDs: []string{id}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query event: %w", err)
+		}
+		for _, evt := range events {
+			if evt.ID != id {
+				continue
+			}
+			if err := adminDelete(ctx, store, evt); err != nil {
+				return nil, fmt.Errorf("failed to delete event: %w", err)
+			}
+		}
+		return true, nil
+
+	case "allowevent":
+		var id, reason string
+		if err := parseParams(params, &id, &reason); err != nil {
+			return nil, err
+		}
+		return true, a.update(func() { delete(a.bannedEvents, id) })
+
+	case "listbannedevents":
+		list := []eventReason{}
+		for _, id := range a.list(a.bannedEvents) {
+			list = append(list, eventReason{ID: id[0], Reason: id[1]})
+		}
+		return list, nil
+
+	case "changerelayname", "changerelaydescription", "changerelayicon":
+		var value string
+		if err := parseParams(params, &value); err != nil {
+			return nil, err
+		}
+		return true, a.update(func() {
+			switch method {
+			case "changerelayname":
+				a.relayName = value
+			case "changerelaydescription":
+				a.relayDesc = value
+			case "changerelayicon":
+				a.relayIcon = value
+			}
+		})
+
+	case "allowkind", "disallowkind":
+		var kind int
+		if err := parseParams(params, &kind); err != nil {
+			return nil, err
+		}
+		if method == "allowkind" {
+			return true, a.AllowKind(kind)
+		}
+		return true, a.DisallowKind(kind)
+
+	case "listallowedkinds":
+		return a.State().AllowedKinds, nil
+
+	case "blockip":
+		var ip, reason string
+		if err := parseParams(params, &ip, &reason); err != nil {
+			return nil, err
+		}
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, errors.New("invalid ip")
+		}
+		return true, a.BanIP(parsed.String(), reason)
+
+	case "unblockip":
+		var ip string
+		if err := parseParams(params, &ip); err != nil {
+			return nil, err
+		}
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, errors.New("invalid ip")
+		}
+		return true, a.AllowIP(parsed.String())
+
+	case "listblockedips":
+		list := []ipReason{}
+		for _, ip := range a.list(a.bannedIPs) {
+			list = append(list, ipReason{IP: ip[0], Reason: ip[1]})
+		}
+		return list, nil
+	}
+
+	return nil, fmt.Errorf("method %q is not supported", method)
+}
+
+// list returns the keys of set, sorted, with their reason.
+func (a *Admin) list(set map[string]string) [][2]string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	list := make([][2]string, 0, len(set))
+	for key, reason := range set {
+		list = append(list, [2]string{key, reason})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i][0] < list[j][0] })
+	return list
+}
+
+// parseParams decodes params into dst, in order. The first one is required
+// and the others are optional.
+func parseParams(params []json.RawMessage, dst ...any) error {
+	if len(params) == 0 {
+		return errors.New("missing params")
+	}
+	for i, param := range params {
+		if i == len(dst) {
+			break
+		}
+		if err := json.Unmarshal(param, dst[i]); err != nil {
+			return fmt.Errorf("invalid param %d: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package relayer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer/storage/memory"
+	"github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/exp/slices"
+)
+
+type managedRelay struct{ *testRelay }
+
+func (mr managedRelay) ManagementMethods() []string { return []string{"ping"} }
+
+func (mr managedRelay) HandleManagement(ctx context.Context, pubkey, method string, params []json.RawMessage) (any, bool, error) {
+	switch method {
+	case "ping":
+		return "pong", true, nil
+	case "changerelayicon":
+		return nil, false, errors.New("icons are fixed")
+	}
+	return nil, false, nil
+}
+
+func TestNIP86(t *testing.T) {
+	adminKey := nostr.GeneratePrivateKey()
+	adminPubkey, _ := nostr.GetPublicKey(adminKey)
+
+	rl := &testRelay{name: "test", storage: &memory.MemoryBackend{}}
+	srv := NewServer("127.0.0.1:0", managedRelay{rl})
+	srv.Admin = &Admin{Pubkeys: []string{adminPubkey}}
+	ready := make(chan struct{})
+	rl.onInitialized = func(*Server) { close(ready) }
+	go srv.Start()
+	<-ready
+	defer srv.Shutdown(context.Background())
+
+	url := "http://" + srv.Addr() + "/"
+	call := func(sk, method string, params ...any) (int, nip86Response) {
+		t.Helper()
+		if params == nil {
+			params = []any{}
+		}
+		body, _ := json.Marshal(map[string]any{"method": method, "params": params})
+		req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/nostr+json+rpc")
+		if sk != "" {
+			req.Header.Set("Authorization", nip98Header(sk, "POST", url, body))
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var response nip86Response
+		json.NewDecoder(res.Body).Decode(&response)
+		return res.StatusCode, response
+	}
+
+	if code, res := call("", "supportedmethods"); code != http.StatusUnauthorized || !strings.HasPrefix(res.Error, "unauthorized: ") {
+		t.Errorf("without auth = %d %+v", code, res)
+	}
+	if code, _ := call(nostr.GeneratePrivateKey(), "supportedmethods"); code != http.StatusUnauthorized {
+		t.Errorf("by someone else = %d", code)
+	}
+	_, res := call(adminKey, "supportedmethods")
+	methods, _ := res.Result.([]any)
+	if !slices.Contains(methods, any("banpubkey")) || !slices.Contains(methods, any("ping")) {
+		t.Errorf("supportedmethods = %v", res.Result)
+	}
+	if _, res := call(adminKey, "ping"); res.Result != "pong" {
+		t.Errorf("ping = %+v", res)
+	}
+	if _, res := call(adminKey, "changerelayicon", "https://example.com/icon.png"); res.Error != "icons are fixed" {
+		t.Errorf("vetoed method = %+v", res)
+	}
+	if _, res := call(adminKey, "nosuchmethod"); res.Error == "" {
+		t.Errorf("unknown method = %+v", res)
+	}
+
+	// banned events are deleted and rejected
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	evt := nostr.Event{PubKey: pk, Kind: 1, CreatedAt: time.Now(), Tags: nostr.Tags{}}
+	evt.Sign(sk)
+	if ok, msg := publishEvent(t, srv.Addr(), evt); !ok {
+		t.Fatalf("event not accepted: %s", msg)
+	}
+	if _, res := call(adminKey, "banevent", evt.ID, "spam"); res.Result != true {
+		t.Fatalf("banevent = %+v", res)
+	}
+	if events, _ := rl.storage.QueryEvents(&nostr.Filter{IDs: []string{evt.ID}}); len(events) != 0 {
+		t.Errorf("banned event not deleted")
+	}
+	if ok, msg := publishEvent(t, srv.Addr(), evt); ok || msg != "blocked: event is banned" {
+		t.Errorf("banned event: %v %q", ok, msg)
+	}
+	_, res = call(adminKey, "listbannedevents")
+	if banned, _ := json.Marshal(res.Result); string(banned) != `[{"id":"`+evt.ID+`","reason":"spam"}]` {
+		t.Errorf("listbannedevents = %s", banned)
+	}
+
+	// only allowed kinds are accepted, once some are
+	call(adminKey, "allowkind", 1)
+	reaction := nostr.Event{PubKey: pk, Kind: 7, CreatedAt: time.Now(), Tags: nostr.Tags{}}
+	reaction.Sign(sk)
+	if ok, msg := publishEvent(t, srv.Addr(), reaction); ok || msg != "blocked: kind is not allowed" {
+		t.Errorf("disallowed kind: %v %q", ok, msg)
+	}
+	call(adminKey, "disallowkind", 1)
+	if ok, msg := publishEvent(t, srv.Addr(), reaction); !ok {
+		t.Errorf("event not accepted once all kinds are: %s", msg)
+	}
+	note := nostr.Event{PubKey: pk, Kind: 1, CreatedAt: time.Now(), Tags: nostr.Tags{}, Content: "kinds"}
+	note.Sign(sk)
+	if ok, msg := publishEvent(t, srv.Addr(), note); ok || msg != "blocked: kind is not allowed" {
+		t.Errorf("kind disallowed after being allowed: %v %q", ok, msg)
+	}
+	call(adminKey, "allowkind", 1)
+	if ok, msg := publishEvent(t, srv.Addr(), note); !ok {
+		t.Errorf("kind not accepted once allowed again: %s", msg)
+	}
+	call(adminKey, "disallowkind", 1)
+
+	// events of pubkeys banned after saving them aren't returned
+	call(adminKey, "banpubkey", pk)
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteJSON([]any{"REQ", "sub", nostr.Filter{Authors: []string{pk}}})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg []any
+	if err := conn.ReadJSON(&msg); err != nil || msg[0] != "EOSE" {
+		t.Errorf("got %v %v instead of EOSE", msg, err)
+	}
+
+	// the relay information reflects the changes
+	call(adminKey, "changerelayname", "managed")
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Accept", "application/nostr+json")
+	info, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer info.Body.Close()
+	var doc nip11Document
+	json.NewDecoder(info.Body).Decode(&doc)
+	if doc.Name != "managed" || !slices.Contains(doc.SupportedNIPs, 86) {
+		t.Errorf("relay information = %+v", doc)
+	}
+}
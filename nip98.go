@@ -0,0 +1,172 @@
+package relayer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// nip98Window is how far the created_at of a NIP-98 event may be from now,
+// by default.
+const nip98Window = time.Minute
+
+// nip98MaxBodySize is the size of the largest body authenticated, by default.
+const nip98MaxBodySize = 1 << 20
+
+// NIP98Auth authenticates HTTP requests with NIP-98: an Authorization header
+// "Nostr <base64 of a kind 27235 event>" signed for the URL, method and body
+// of the request. It is meant for the routes relays add in OnInitialized:
+//
+//	auth := relayer.NIP98Auth{}
+//	s.Router().Path("/invoice").Handler(auth.Middleware(http.HandlerFunc(handleInvoice)))
+//
+// Handlers get the pubkey which signed the request with [NIP98Pubkey].
+type NIP98Auth struct {
+	// Window is how far the created_at of the event may be from now,
+	// a minute if zero.
+	Window time.Duration
+
+	// Optional lets requests without an Authorization header through,
+	// with no pubkey in their context. Invalid ones are still refused.
+	Optional bool
+
+	// MaxBodySize is the size of the largest request body, whose hash is
+	// checked against the event, 1MB if zero.
+	MaxBodySize int64
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-Proto header
+	// is trusted to tell the scheme of the URL signed, as in [Server.TrustedProxies].
+	TrustedProxies []netip.Prefix
+
+	// ErrorHandler answers unauthenticated requests, with err telling why,
+	// instead of a plain text 401 Unauthorized. The WWW-Authenticate header
+	// is already set when it is called.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+type nip98ContextKey struct{}
+
+// NIP98Pubkey returns the pubkey which signed the request of ctx, as
+// verified by [NIP98Auth], or "" if there is none.
+func NIP98Pubkey(ctx context.Context) string {
+	pubkey, _ := ctx.Value(nip98ContextKey{}).(string)
+	return pubkey
+}
+
+// Middleware answers unauthenticated requests with 401 Unauthorized, or
+// through ErrorHandler, passing the others on to next with the pubkey in
+// their context.
+// It can be given to [mux.Router.Use].
+func (auth NIP98Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.Optional && r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pubkey, err := auth.validate(w, r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Nostr")
+			if auth.ErrorHandler != nil {
+				auth.ErrorHandler(w, r, err)
+				return
+			}
+			http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), nip98ContextKey{}, pubkey)))
+	})
+}
+
+// validate checks the NIP-98 Authorization header of r, returning the pubkey
+// which signed it. The body of r is only read, and replaced, once the
+// signature is checked.
+func (auth NIP98Auth) validate(w http.ResponseWriter, r *http.Request) (pubkey string, err error) {
+	window := auth.Window
+	if window == 0 {
+		window = nip98Window
+	}
+	maxBodySize := auth.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = nip98MaxBodySize
+	}
+
+	header := r.Header.Get("Authorization")
+	scheme, encoded, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Nostr") || encoded == "" {
+		return "", errors.New("missing nostr authorization")
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", fmt.Errorf("invalid base64: %w", err)
+	}
+	var evt nostr.Event
+	if err := json.Unmarshal(data, &evt); err != nil {
+		return "", fmt.Errorf("invalid event: %w", err)
+	}
+
+	if evt.Kind != 27235 {
+		return "", fmt.Errorf("wrong kind %d", evt.Kind)
+	}
+	if age := time.Since(evt.CreatedAt); age > window || age < -window {
+		return "", errors.New("event is too old or too far in the future")
+	}
+	if u := tagValue(evt.Tags, "u"); u != requestURL(r, auth.TrustedProxies) {
+		return "", fmt.Errorf("url %q doesn't match the request", u)
+	}
+	if method := tagValue(evt.Tags, "method"); !strings.EqualFold(method, r.Method) {
+		return "", fmt.Errorf("method %q doesn't match the request", method)
+	}
+
+	if evt.ID != evt.GetID() {
+		return "", errors.New("invalid event id")
+	}
+	if ok, err := evt.CheckSignature(); err != nil || !ok {
+		return "", errors.New("invalid signature")
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the body: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	payload := tagValue(evt.Tags, "payload")
+	if len(body) > 0 || payload != "" {
+		hash := sha256.Sum256(body)
+		if !strings.EqualFold(payload, hex.EncodeToString(hash[:])) {
+			return "", errors.New("payload doesn't match the request body")
+		}
+	}
+	return evt.PubKey, nil
+}
+
+func tagValue(tags nostr.Tags, name string) string {
+	if tag := tags.GetFirst([]string{name, ""}); tag != nil && len(*tag) >= 2 {
+		return (*tag)[1]
+	}
+	return ""
+}
+
+// requestURL returns the absolute URL of r, as requested by the client,
+// through one of trusted if any.
+func requestURL(r *http.Request, trusted []netip.Prefix) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" && fromTrustedProxy(r, trusted) {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
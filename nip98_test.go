@@ -0,0 +1,196 @@
+package relayer
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestNIP98Auth(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	url := "http://relay.example.com/create"
+
+	var got string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = NIP98Pubkey(r.Context())
+	})
+	for _, tc := range []struct {
+		name   string
+		auth   NIP98Auth
+		header string
+		code   int
+		pubkey string
+	}{
+		{"valid", NIP98Auth{}, nip98Header(sk, "GET", url, nil), http.StatusOK, pk},
+		{"missing", NIP98Auth{}, "", http.StatusUnauthorized, ""},
+		{"optional", NIP98Auth{Optional: true}, "", http.StatusOK, ""},
+		{"invalid but optional", NIP98Auth{Optional: true}, nip98Header(sk, "POST", url, nil), http.StatusUnauthorized, ""},
+		{"old", NIP98Auth{}, nip98HeaderAt(sk, "GET", url, nil, time.Now().Add(-5*time.Minute)), http.StatusUnauthorized, ""},
+		{"old in window", NIP98Auth{Window: 10 * time.Minute}, nip98HeaderAt(sk, "GET", url, nil, time.Now().Add(-5*time.Minute)), http.StatusOK, pk},
+	} {
+		got = ""
+		r := httptest.NewRequest("GET", url, nil)
+		if tc.header != "" {
+			r.Header.Set("Authorization", tc.header)
+		}
+		w := httptest.NewRecorder()
+		tc.auth.Middleware(handler).ServeHTTP(w, r)
+		if w.Code != tc.code || got != tc.pubkey {
+			t.Errorf("%s: got %d %q", tc.name, w.Code, got)
+		}
+	}
+}
+
+func TestNIP98AuthErrorHandler(t *testing.T) {
+	auth := NIP98Auth{ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, err.Error())
+	}}
+	w := httptest.NewRecorder()
+	auth.Middleware(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusTeapot || w.Body.String() != "missing nostr authorization" || w.Header().Get("WWW-Authenticate") != "Nostr" {
+		t.Errorf("got %d %q, headers %v", w.Code, w.Body, w.Header())
+	}
+}
+
+func TestValidateNIP98(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	url := "http://relay.example.com/admin/state"
+	body := []byte(`{"method":"supportedmethods","params":[]}`)
+
+	for _, tc := range []struct {
+		name   string
+		header string
+		method string
+		body   []byte
+		ok     bool
+	}{
+		{"valid", nip98Header(sk, "GET", url, nil), "GET", nil, true},
+		{"valid with body", nip98Header(sk, "POST", url, body), "POST", body, true},
+		{"missing", "", "GET", nil, false},
+		{"wrong method", nip98Header(sk, "POST", url, nil), "GET", nil, false},
+		{"wrong url", nip98Header(sk, "GET", url+"?x=1", nil), "GET", nil, false},
+		{"wrong body", nip98Header(sk, "POST", url, body), "POST", []byte("{}"), false},
+		{"old", nip98HeaderAt(sk, "GET", url, nil, time.Now().Add(-2*time.Minute)), "GET", nil, false},
+	} {
+		var reader io.Reader
+		if tc.body != nil {
+			reader = strings.NewReader(string(tc.body))
+		}
+		r := httptest.NewRequest(tc.method, url, reader)
+		if tc.header != "" {
+			r.Header.Set("Authorization", tc.header)
+		}
+		got, err := NIP98Auth{}.validate(httptest.NewRecorder(), r)
+		if tc.ok && (err != nil || got != pk) {
+			t.Errorf("%s: got %q, %v", tc.name, got, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("%s: validated", tc.name)
+		}
+	}
+}
+
+func TestValidateNIP98Proxied(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	url := "https://relay.example.com/admin/state"
+	trusted, _ := ParseTrustedProxies([]string{"10.0.0.1"})
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		ok         bool
+	}{
+		{"trusted proxy", "10.0.0.1:1234", true},
+		{"spoofed", "192.0.2.1:1234", false},
+	} {
+		r := httptest.NewRequest("GET", "http://relay.example.com/admin/state", nil)
+		r.RemoteAddr = tc.remoteAddr
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("Authorization", nip98Header(sk, "GET", url, nil))
+		_, err := NIP98Auth{TrustedProxies: trusted}.validate(httptest.NewRecorder(), r)
+		if tc.ok != (err == nil) {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+	}
+}
+
+func TestValidateNIP98BodySize(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	url := "http://relay.example.com/admin/state"
+	body := []byte(strings.Repeat("a", 100))
+
+	for _, tc := range []struct {
+		name   string
+		header string
+		ok     bool
+	}{
+		{"too large", nip98Header(sk, "POST", url, body), false},
+		{"unsigned", nip98Header(sk, "POST", url, body)[:20], false},
+	} {
+		read := &countingReader{r: strings.NewReader(string(body))}
+		r := httptest.NewRequest("POST", url, read)
+		r.Header.Set("Authorization", tc.header)
+		_, err := NIP98Auth{MaxBodySize: 50}.validate(httptest.NewRecorder(), r)
+		if tc.ok != (err == nil) {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+		if read.n > 51 {
+			t.Errorf("%s: read %d bytes", tc.name, read.n)
+		}
+	}
+
+	// the body isn't read at all until the signature is checked
+	evt := nostr.Event{Kind: 27235, CreatedAt: time.Now(), Tags: nostr.Tags{{"u", url}, {"method", "POST"}}}
+	evt.PubKey, _ = nostr.GetPublicKey(sk)
+	evt.Sign(sk)
+	evt.Sig = strings.Repeat("0", 128)
+	data, _ := json.Marshal(evt)
+	read := &countingReader{r: strings.NewReader(string(body))}
+	r := httptest.NewRequest("POST", url, read)
+	r.Header.Set("Authorization", "Nostr "+base64.StdEncoding.EncodeToString(data))
+	if _, err := (NIP98Auth{}).validate(httptest.NewRecorder(), r); err == nil || read.n != 0 {
+		t.Errorf("bad signature: got %v after reading %d bytes", err, read.n)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func nip98Header(sk, method, url string, body []byte) string {
+	return nip98HeaderAt(sk, method, url, body, time.Now())
+}
+
+func nip98HeaderAt(sk, method, url string, body []byte, at time.Time) string {
+	evt := nostr.Event{
+		Kind:      27235,
+		CreatedAt: at,
+		Tags:      nostr.Tags{{"u", url}, {"method", method}},
+	}
+	if body != nil {
+		hash := sha256.Sum256(body)
+		evt.Tags = append(evt.Tags, nostr.Tag{"payload", hex.EncodeToString(hash[:])})
+	}
+	evt.PubKey, _ = nostr.GetPublicKey(sk)
+	evt.Sign(sk)
+	data, _ := json.Marshal(evt)
+	return "Nostr " + base64.StdEncoding.EncodeToString(data)
+}
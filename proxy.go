@@ -0,0 +1,98 @@
+package relayer
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParseTrustedProxies parses IP addresses and CIDR ranges, such as
+// "10.0.0.0/8" or "127.0.0.1", for [Server.TrustedProxies].
+func ParseTrustedProxies(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// clientIP returns the IP address of the client of r. X-Forwarded-For is
+// only read when r comes from one of trusted, taking the right-most address
+// which isn't a trusted proxy: the ones on its left can be set by the client.
+// Addresses are normalized as in [normalizeIP], to match banned ones.
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	ip := normalizeIP(r.RemoteAddr)
+	if !isTrustedProxy(ip, trusted) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = normalizeIP(hop)
+		if !isTrustedProxy(ip, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+// normalizeIP returns the address in s, which may come with a port, in the
+// form net.IP.String uses: lower-case, and IPv4-mapped addresses as IPv4.
+// s is returned as is if it isn't an address.
+func normalizeIP(s string) string {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		host, _, splitErr := net.SplitHostPort(s)
+		if splitErr != nil {
+			return s
+		}
+		if addr, err = netip.ParseAddr(host); err != nil {
+			return host
+		}
+	}
+	return addr.Unmap().String()
+}
+
+// fromTrustedProxy reports whether r was sent by one of trusted.
+func fromTrustedProxy(r *http.Request, trusted []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return isTrustedProxy(host, trusted)
+}
+
+func isTrustedProxy(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
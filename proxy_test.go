@@ -0,0 +1,87 @@
+package relayer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiatjaf/relayer/storage/memory"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		ip         string
+	}{
+		{"direct", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"spoofed", "192.0.2.1:1234", []string{"203.0.113.7"}, "192.0.2.1"},
+		{"proxied", "127.0.0.1:1234", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"proxied spoofed", "127.0.0.1:1234", []string{"198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		{"proxy chain", "127.0.0.1:1234", []string{"198.51.100.1, 203.0.113.7", "10.1.2.3"}, "203.0.113.7"},
+		{"proxied without header", "127.0.0.1:1234", nil, "127.0.0.1"},
+		{"upper-case IPv6", "[2001:DB8::1]:1234", nil, "2001:db8::1"},
+		{"IPv4-mapped", "[::ffff:192.0.2.1]:1234", nil, "192.0.2.1"},
+		{"proxied IPv4-mapped", "[::ffff:127.0.0.1]:1234", []string{"::FFFF:203.0.113.7"}, "203.0.113.7"},
+		{"proxied with port", "127.0.0.1:1234", []string{"203.0.113.7:5678"}, "203.0.113.7"},
+		{"proxied IPv6 with port", "127.0.0.1:1234", []string{"[2001:DB8::1]:5678, 10.1.2.3:80"}, "2001:db8::1"},
+		{"proxied garbage", "127.0.0.1:1234", []string{"unknown"}, "unknown"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remoteAddr
+		for _, value := range tc.xff {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if ip := clientIP(r, trusted); ip != tc.ip {
+			t.Errorf("%s: got %s, want %s", tc.name, ip, tc.ip)
+		}
+	}
+
+	if _, err := ParseTrustedProxies([]string{"not an ip"}); err == nil {
+		t.Error("invalid trusted proxy parsed")
+	}
+}
+
+func TestIPBanSpoofedForwardedFor(t *testing.T) {
+	rl := &testRelay{storage: &memory.MemoryBackend{}}
+	srv := NewServer("127.0.0.1:0", rl)
+	srv.Admin = &Admin{}
+	srv.TrustedProxies, _ = ParseTrustedProxies([]string{"10.0.0.1"})
+	ready := make(chan struct{})
+	rl.onInitialized = func(*Server) { close(ready) }
+	go srv.Start()
+	<-ready
+	defer srv.Shutdown(context.Background())
+
+	if err := srv.Admin.BanIP("192.0.2.1", "spam"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Admin.BanIP("203.0.113.7", "spam"); err != nil {
+		t.Fatal(err)
+	}
+
+	connect := func(remoteAddr, xff string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("X-Forwarded-For", xff)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := connect("192.0.2.1:1234", "198.51.100.1"); code != http.StatusForbidden {
+		t.Errorf("banned client spoofing X-Forwarded-For got %d", code)
+	}
+	if code := connect("10.0.0.1:1234", "198.51.100.1, 203.0.113.7"); code != http.StatusForbidden {
+		t.Errorf("banned client spoofing X-Forwarded-For through a proxy got %d", code)
+	}
+	if code := connect("10.0.0.1:1234", "[::FFFF:203.0.113.7]:5678"); code != http.StatusForbidden {
+		t.Errorf("banned client forwarded as an IPv4-mapped address got %d", code)
+	}
+}
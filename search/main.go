@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"os"
 
 	"github.com/fiatjaf/relayer"
 	"github.com/fiatjaf/relayer/storage/elasticsearch"
@@ -11,6 +14,16 @@ import (
 )
 
 type Relay struct {
+	ReindexTags         bool `envconfig:"REINDEX_TAGS"`
+	MigrateReplaceables bool `envconfig:"MIGRATE_REPLACEABLES"`
+
+	ElasticsearchURL      []string `envconfig:"ES_URL"`
+	ElasticsearchUsername string   `envconfig:"ES_USERNAME"`
+	ElasticsearchPassword string   `envconfig:"ES_PASSWORD"`
+	ElasticsearchAPIKey   string   `envconfig:"ES_API_KEY"`
+	// ElasticsearchCACert is the path to a PEM-encoded CA certificate.
+	ElasticsearchCACert string `envconfig:"ES_CA_CERT"`
+
 	storage *elasticsearch.ElasticsearchStorage
 }
 
@@ -22,7 +35,30 @@ func (r *Relay) Storage() relayer.Storage {
 	return r.storage
 }
 
-func (r *Relay) OnInitialized(*relayer.Server) {}
+func (r *Relay) OnInitialized(s *relayer.Server) {
+	if r.ReindexTags {
+		// indices created by older versions need their tags indexed by name
+		go func() {
+			n, err := r.storage.ReindexTags(context.Background())
+			if err != nil {
+				s.Log.Errorf("failed to reindex tags: %v", err)
+				return
+			}
+			s.Log.Infof("reindexed tags of %d events", n)
+		}()
+	}
+	if r.MigrateReplaceables {
+		// older versions stored every version of replaceable events
+		go func() {
+			n, err := r.storage.MigrateReplaceables(context.Background())
+			if err != nil {
+				s.Log.Errorf("failed to migrate replaceable events: %v", err)
+				return
+			}
+			s.Log.Infof("migrated %d replaceable events", n)
+		}()
+	}
+}
 
 func (r *Relay) Init() error {
 	err := envconfig.Process("", r)
@@ -57,8 +93,39 @@ func main() {
 		log.Fatalf("failed to read from env: %v", err)
 		return
 	}
-	r.storage = &elasticsearch.ElasticsearchStorage{}
-	if err := relayer.Start(&r); err != nil {
+	var settings relayer.Settings
+	if err := envconfig.Process("", &settings); err != nil {
+		log.Fatalf("failed to read from env: %v", err)
+		return
+	}
+
+	var caCert []byte
+	if r.ElasticsearchCACert != "" {
+		var err error
+		if caCert, err = os.ReadFile(r.ElasticsearchCACert); err != nil {
+			log.Fatalf("failed to read CA certificate: %v", err)
+			return
+		}
+	}
+
+	srv := relayer.NewServer(net.JoinHostPort(settings.Host, settings.Port), &r)
+	srv.MetricsPath = settings.MetricsPath
+	proxies, err := relayer.ParseTrustedProxies(settings.TrustedProxies)
+	if err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+		return
+	}
+	srv.TrustedProxies = proxies
+	srv.SetDebug(settings.Debug)
+	r.storage = &elasticsearch.ElasticsearchStorage{
+		Addresses: r.ElasticsearchURL,
+		Username:  r.ElasticsearchUsername,
+		Password:  r.ElasticsearchPassword,
+		APIKey:    r.ElasticsearchAPIKey,
+		CACert:    caCert,
+		Log:       srv.Log,
+	}
+	if err := srv.Start(); err != nil {
 		log.Fatalf("server terminated: %v", err)
 	}
 }
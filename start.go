@@ -6,8 +6,10 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -20,6 +22,19 @@ import (
 type Settings struct {
 	Host string `envconfig:"HOST" default:"0.0.0.0"`
 	Port string `envconfig:"PORT" default:"7447"`
+
+	// MetricsPath is where metrics are served, if set, see [Server.MetricsPath].
+	MetricsPath string `envconfig:"METRICS_PATH"`
+
+	// Debug turns on logging of every client message, see [Server.SetDebug].
+	Debug bool `envconfig:"DEBUG"`
+
+	// ShutdownDelay is how long /readyz fails before the server stops, see [Server.ShutdownDelay].
+	ShutdownDelay time.Duration `envconfig:"SHUTDOWN_DELAY"`
+
+	// TrustedProxies are the addresses or CIDR ranges of the reverse proxies
+	// in front of the relay, see [Server.TrustedProxies].
+	TrustedProxies []string `envconfig:"TRUSTED_PROXIES"`
 }
 
 // Start calls StartConf with Settings parsed from the process environment.
@@ -34,8 +49,16 @@ func Start(relay Relay) error {
 // StartConf creates a new Server, passing it host:port for the address,
 // and starts serving propagating any error returned from [Server.Start].
 func StartConf(s Settings, relay Relay) error {
+	proxies, err := ParseTrustedProxies(s.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("trusted proxies: %w", err)
+	}
 	addr := net.JoinHostPort(s.Host, s.Port)
 	srv := NewServer(addr, relay)
+	srv.TrustedProxies = proxies
+	srv.MetricsPath = s.MetricsPath
+	srv.SetDebug(s.Debug)
+	srv.ShutdownDelay = s.ShutdownDelay
 	return srv.Start()
 }
 
@@ -58,6 +81,31 @@ type Server struct {
 	// outputting to stderr.
 	Log Logger
 
+	// Metrics collected by the server, which relays can add theirs to.
+	// They are served at MetricsPath by Start, only if it is set since they
+	// shouldn't be public: put it behind authentication or on a path only
+	// reachable internally.
+	Metrics     *Metrics
+	MetricsPath string
+	metrics     serverMetrics
+
+	// Admin, if set, is served by Start. See [Admin].
+	Admin *Admin
+
+	// Tracer times the stages of handling EVENT and REQ messages, see [Tracer].
+	// It is NoopTracer by default.
+	Tracer Tracer
+
+	// ShutdownDelay is how long Shutdown keeps serving with /readyz failing,
+	// so that probes notice and load balancers stop sending new clients,
+	// before it stops the server.
+	ShutdownDelay time.Duration
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For and
+	// X-Forwarded-Proto headers are trusted. Clients are otherwise identified
+	// by the address they connect from, for IP bans.
+	TrustedProxies []netip.Prefix
+
 	addr       string
 	relay      Relay
 	router     *mux.Router
@@ -65,7 +113,11 @@ type Server struct {
 
 	// keep a connection reference to all connected clients for Server.Shutdown
 	clientsMu sync.Mutex
-	clients   map[*websocket.Conn]struct{}
+	clients   map[*websocket.Conn]*WebSocket
+
+	// set by Shutdown, for /readyz
+	shuttingDown int32
+	debug        int32
 }
 
 // NewServer creates a relay server with sensible defaults.
@@ -73,18 +125,25 @@ type Server struct {
 func NewServer(addr string, relay Relay) *Server {
 	srv := &Server{
 		Log:     defaultLogger(relay.Name() + ": "),
+		Metrics: NewMetrics(),
+		Tracer:  NoopTracer,
 		addr:    addr,
 		relay:   relay,
 		router:  mux.NewRouter(),
-		clients: make(map[*websocket.Conn]struct{}),
+		clients: make(map[*websocket.Conn]*WebSocket),
 	}
+	srv.metrics = newServerMetrics(srv)
 	srv.router.Path("/").Headers("Upgrade", "websocket").HandlerFunc(srv.handleWebsocket)
 	srv.router.Path("/").Headers("Accept", "application/nostr+json").HandlerFunc(srv.handleNIP11)
+	srv.router.Path("/healthz").HandlerFunc(srv.handleHealthz)
+	srv.router.Path("/readyz").HandlerFunc(srv.handleReadyz)
 	return srv
 }
 
 // Router returns an http.Handler used to handle server's in-flight HTTP requests.
-// By default, the router is setup to handle websocket upgrade and NIP-11 requests.
+// By default, the router is setup to handle websocket upgrade and NIP-11 requests,
+// as well as liveness and readiness probes at /healthz and /readyz.
+// See [HealthChecker].
 //
 // In a larger system, where the relay server is not the only HTTP handler,
 // prefer using s as http.Handler instead of the returned router.
@@ -134,13 +193,42 @@ func (s *Server) startListener(ln net.Listener) error {
 		return fmt.Errorf("storage init: %w", err)
 	}
 
+	// metrics from implementations, if any
+	for _, impl := range []any{s.relay, s.relay.Storage()} {
+		if reporter, ok := impl.(MetricsReporter); ok {
+			reporter.RegisterMetrics(s.Metrics)
+		}
+	}
+	if s.MetricsPath != "" {
+		s.router.Path(s.MetricsPath).Handler(s.Metrics)
+	}
+
+	if s.Admin != nil {
+		if err := s.Admin.mount(s); err != nil {
+			return fmt.Errorf("admin: %w", err)
+		}
+	}
+
+	// send events from other instances to our subscribers
+	if bus := eventBus(s.relay); bus != defaultEventBus {
+		if err := bus.Subscribe(notifyListeners); err != nil {
+			return fmt.Errorf("event bus: %w", err)
+		}
+	}
+
 	// push events from implementations, if any
-	if inj, ok := s.relay.(Injector); ok {
-		go func() {
-			for event := range inj.InjectEvents() {
-				notifyListeners(&event)
+	for _, impl := range []any{s.relay, s.relay.Storage()} {
+		if inj, ok := impl.(Injector); ok {
+			if events := inj.InjectEvents(); events != nil {
+				go func() {
+					for event := range events {
+						if err := publish(s.relay, &event); err != nil {
+							s.logger().Error("failed to publish injected event", "event", event.ID, "error", err)
+						}
+					}
+				}()
 			}
-		}()
+		}
 	}
 
 	s.httpServer = &http.Server{
@@ -155,7 +243,7 @@ func (s *Server) startListener(ln net.Listener) error {
 	s.relay.OnInitialized(s)
 
 	// start accepting incoming requests
-	s.Log.Infof("listening on %s", s.addr)
+	s.logger().Info("listening", "addr", s.addr)
 	err := s.httpServer.Serve(ln)
 	if err == http.ErrServerClosed {
 		err = nil
@@ -164,12 +252,20 @@ func (s *Server) startListener(ln net.Listener) error {
 }
 
 // Shutdown stops serving HTTP requests and send a websocket close control message
-// to all connected clients.
+// to all connected clients. From then on, /readyz reports the server as unavailable,
+// for ShutdownDelay, or until ctx is done, before the server stops.
 //
 // If the relay is ShutdownAware, Shutdown calls its OnShutdown, passing the context as is.
 // Note that the HTTP server make some time to shutdown and so the context deadline,
 // if any, may have been shortened by the time OnShutdown is called.
 func (s *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+	if s.ShutdownDelay > 0 {
+		select {
+		case <-time.After(s.ShutdownDelay):
+		case <-ctx.Done():
+		}
+	}
 	err := s.httpServer.Shutdown(ctx)
 	if f, ok := s.relay.(ShutdownAware); ok {
 		f.OnShutdown(ctx)
@@ -177,6 +273,30 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return err
 }
 
+func (s *Server) isShuttingDown() bool {
+	return atomic.LoadInt32(&s.shuttingDown) == 1
+}
+
+// SetDebug turns logging of every message received from clients on or off,
+// at the debug level. It can be called at any time.
+func (s *Server) SetDebug(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&s.debug, v)
+}
+
+// Debugging reports whether messages from clients are being logged.
+func (s *Server) Debugging() bool {
+	return atomic.LoadInt32(&s.debug) == 1
+}
+
+// logger returns Log as a StructuredLogger.
+func (s *Server) logger() StructuredLogger {
+	return Structured(s.Log)
+}
+
 func (s *Server) disconnectAllClients() {
 	s.clientsMu.Lock()
 	defer s.clientsMu.Unlock()
@@ -193,8 +313,9 @@ func defaultLogger(prefix string) Logger {
 	return stdLogger{l}
 }
 
+// stdLogger marks warnings and errors, the standard logger having no levels.
 type stdLogger struct{ log *log.Logger }
 
 func (l stdLogger) Infof(format string, v ...any)    { l.log.Printf(format, v...) }
-func (l stdLogger) Warningf(format string, v ...any) { l.log.Printf(format, v...) }
-func (l stdLogger) Errorf(format string, v ...any)   { l.log.Printf(format, v...) }
+func (l stdLogger) Warningf(format string, v ...any) { l.log.Printf("warning: "+format, v...) }
+func (l stdLogger) Errorf(format string, v ...any)   { l.log.Printf("error: "+format, v...) }
@@ -2,10 +2,14 @@ package relayer
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"testing"
 	"time"
 
+	"github.com/fiatjaf/relayer/storage"
+	"github.com/fiatjaf/relayer/storage/memory"
 	"github.com/gorilla/websocket"
 	"github.com/nbd-wtf/go-nostr"
 )
@@ -77,7 +81,7 @@ func TestServerStartShutdown(t *testing.T) {
 
 func TestServerShutdownWebsocket(t *testing.T) {
 	// set up a new relay server
-	srv := startTestRelay(t, &testRelay{storage: &testStorage{}})
+	srv := startTestRelay(t, &testRelay{storage: &memory.MemoryBackend{}})
 
 	// connect a client to it
 	ctx1, cancel := context.WithTimeout(context.Background(), 2*time.Second)
@@ -104,3 +108,157 @@ func TestServerShutdownWebsocket(t *testing.T) {
 		t.Error("client took too long to disconnect")
 	}
 }
+
+func TestServerStorageInjector(t *testing.T) {
+	store := &injectingStorage{MemoryBackend: &memory.MemoryBackend{}, events: make(chan nostr.Event)}
+	srv := startTestRelay(t, &testRelay{storage: store})
+	defer srv.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client, err := nostr.RelayConnect(ctx, "ws://"+srv.Addr())
+	if err != nil {
+		t.Fatalf("nostr.RelayConnect: %v", err)
+	}
+	defer client.Close()
+	sub := client.Subscribe(ctx, nostr.Filters{{Kinds: []int{1}}})
+	<-sub.EndOfStoredEvents
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	evt := nostr.Event{PubKey: pk, Kind: 1, CreatedAt: time.Now(), Tags: nostr.Tags{}}
+	evt.Sign(sk)
+	store.events <- evt
+	select {
+	case got := <-sub.Events:
+		if got == nil {
+			t.Error("subscription closed")
+		} else if got.ID != evt.ID {
+			t.Errorf("got event %s; want %s", got.ID, evt.ID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("injected event not received")
+	}
+}
+
+type injectingStorage struct {
+	*memory.MemoryBackend
+	events chan nostr.Event
+}
+
+func (s *injectingStorage) InjectEvents() chan nostr.Event { return s.events }
+
+func TestServerReqAccepter(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	rl := &privateRelay{testRelay: &testRelay{storage: &memory.MemoryBackend{}}, reader: pk}
+	ready := make(chan struct{})
+	rl.onInitialized = func(*Server) { close(ready) }
+	srv := NewServer("127.0.0.1:0", rl)
+	go srv.Start()
+	<-ready
+	defer srv.Shutdown(context.Background())
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var challenge []string
+	if err := conn.ReadJSON(&challenge); err != nil || challenge[0] != "AUTH" {
+		t.Fatalf("got %v %v instead of AUTH", challenge, err)
+	}
+
+	var msg []any
+	conn.WriteJSON([]any{"REQ", "sub", nostr.Filter{Kinds: []int{1}}})
+	if err := conn.ReadJSON(&msg); err != nil || msg[0] != "NOTICE" {
+		t.Errorf("got %v %v instead of NOTICE before AUTH", msg, err)
+	}
+
+	auth := nostr.Event{
+		PubKey:    pk,
+		Kind:      22242,
+		CreatedAt: time.Now(),
+		Tags:      nostr.Tags{{"relay", rl.ServiceURL()}, {"challenge", challenge[1]}},
+	}
+	auth.Sign(sk)
+	conn.WriteJSON([]any{"AUTH", auth})
+	if err := conn.ReadJSON(&msg); err != nil || msg[0] != "OK" || msg[2] != true {
+		t.Fatalf("got %v %v instead of OK", msg, err)
+	}
+	conn.WriteJSON([]any{"REQ", "sub", nostr.Filter{Kinds: []int{1}}})
+	if err := conn.ReadJSON(&msg); err != nil || msg[0] != "EOSE" {
+		t.Errorf("got %v %v instead of EOSE after AUTH", msg, err)
+	}
+}
+
+func TestServerFilterTooLarge(t *testing.T) {
+	rl := &testRelay{storage: &testStorage{
+		queryEvents: func(f *nostr.Filter) ([]nostr.Event, error) {
+			if len(f.Authors) > 1 {
+				return nil, fmt.Errorf("%w: too many authors", storage.ErrFilterTooLarge)
+			}
+			return nil, nil
+		},
+	}}
+	srv := startTestRelay(t, rl)
+	defer srv.Shutdown(context.Background())
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var msg []any
+	conn.WriteJSON([]any{"REQ", "sub", nostr.Filter{Authors: []string{"a", "b"}}})
+	if err := conn.ReadJSON(&msg); err != nil || msg[0] != "NOTICE" || msg[1] != "error: filter too large: too many authors" {
+		t.Errorf("got %v %v instead of NOTICE", msg, err)
+	}
+	conn.WriteJSON([]any{"REQ", "sub", nostr.Filter{Authors: []string{"a"}}})
+	if err := conn.ReadJSON(&msg); err != nil || msg[0] != "EOSE" {
+		t.Errorf("got %v %v instead of EOSE", msg, err)
+	}
+}
+
+type privateRelay struct {
+	*testRelay
+	reader string
+}
+
+func (pr *privateRelay) ServiceURL() string { return "wss://relay.example.com" }
+
+func (pr *privateRelay) AcceptReq(ctx context.Context, id string, filters nostr.Filters, authedPubkey string) bool {
+	return authedPubkey == pr.reader
+}
+
+func TestServerNIP11Fees(t *testing.T) {
+	rl := &paidRelay{&testRelay{storage: &memory.MemoryBackend{}}}
+	ready := make(chan struct{})
+	rl.onInitialized = func(*Server) { close(ready) }
+	srv := NewServer("127.0.0.1:0", rl)
+	go srv.Start()
+	<-ready
+	defer srv.Shutdown(context.Background())
+
+	req, _ := http.NewRequest("GET", "http://"+srv.Addr(), nil)
+	req.Header.Set("Accept", "application/nostr+json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var doc nip11Document
+	json.NewDecoder(res.Body).Decode(&doc)
+	if doc.Fees == nil || len(doc.Fees.Subscription) != 1 || doc.Fees.Subscription[0].Period != 2592000 {
+		t.Errorf("fees = %+v", doc.Fees)
+	}
+}
+
+type paidRelay struct{ *testRelay }
+
+func (pr *paidRelay) NIP11Fees() Fees {
+	return Fees{Subscription: []Fee{{Amount: 1000000, Unit: "msats", Period: 2592000}}}
+}
@@ -0,0 +1,190 @@
+// Package cache implements a storage wrapper keeping the results of recent
+// queries in memory, for relays whose clients keep asking for the same events,
+// such as profiles and contact lists.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// CachedStorage answers queries from a least recently used cache of results,
+// passing misses on to Storage.
+//
+// Cached results are invalidated when a matching event is saved, or when
+// one of the events they hold is deleted or replaced, through this storage.
+// Events written to Storage by other means, such as other relay instances
+// sharing a database, are only noticed if Storage is a [relayer.Injector];
+// otherwise TTL bounds how long results can be stale.
+type CachedStorage struct {
+	Storage relayer.Storage
+
+	// MaxEntries is the number of results kept, 1000 by default.
+	MaxEntries int
+
+	// TTL, if positive, is how long results are kept at most.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *entry, most recently used first
+
+	// generation is incremented by every write, so that results of queries
+	// running concurrently with a write are not cached
+	generation uint64
+
+	// counters for Stats
+	hits, misses, evictions, invalidations uint64
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+type entry struct {
+	key     string
+	filter  nostr.Filter
+	events  []nostr.Event
+	expires time.Time
+}
+
+// Stats are counters of a [CachedStorage] since Init.
+type Stats struct {
+	Hits          uint64
+	Misses        uint64
+	Evictions     uint64
+	Invalidations uint64
+	Entries       int
+}
+
+func (c *CachedStorage) Init() error {
+	if c.MaxEntries <= 0 {
+		c.MaxEntries = 1000
+	}
+	c.entries = make(map[string]*list.Element)
+	c.lru = list.New()
+	return c.Storage.Init()
+}
+
+// Stats returns the cache counters.
+func (c *CachedStorage) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Evictions:     c.evictions,
+		Invalidations: c.invalidations,
+		Entries:       c.lru.Len(),
+	}
+}
+
+// CheckHealth checks Storage, if it is a [relayer.HealthChecker].
+func (c *CachedStorage) CheckHealth(ctx context.Context) error {
+	if checker, ok := c.Storage.(relayer.HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// InjectEvents forwards the events injected by Storage, if any,
+// invalidating the cached results they affect.
+func (c *CachedStorage) InjectEvents() chan nostr.Event {
+	inj, ok := c.Storage.(relayer.Injector)
+	if !ok {
+		return nil
+	}
+	events := inj.InjectEvents()
+	if events == nil {
+		return nil
+	}
+
+	forwarded := make(chan nostr.Event)
+	go func() {
+		defer close(forwarded)
+		for evt := range events {
+			c.invalidate(&evt, true)
+			forwarded <- evt
+		}
+	}()
+	return forwarded
+}
+
+func (c *CachedStorage) timeNow() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// filterKey returns the same key for filters matching the same events,
+// whatever the order of their values. Nil and empty lists are told apart,
+// as the latter match nothing.
+func filterKey(filter *nostr.Filter) string {
+	var key struct {
+		IDs     []string   `json:"ids"`
+		Kinds   []int      `json:"kinds"`
+		Authors []string   `json:"authors"`
+		Tags    [][]string `json:"tags"`
+		Since   *int64     `json:"since"`
+		Until   *int64     `json:"until"`
+		Limit   int        `json:"limit"`
+		Search  string     `json:"search"`
+	}
+	key.IDs = sortedSet(filter.IDs)
+	key.Kinds = sortedKinds(filter.Kinds)
+	key.Authors = sortedSet(filter.Authors)
+	for name, values := range filter.Tags {
+		key.Tags = append(key.Tags, append([]string{name}, sortedSet(values)...))
+	}
+	sort.Slice(key.Tags, func(i, j int) bool { return key.Tags[i][0] < key.Tags[j][0] })
+	if filter.Since != nil {
+		since := filter.Since.Unix()
+		key.Since = &since
+	}
+	if filter.Until != nil {
+		until := filter.Until.Unix()
+		key.Until = &until
+	}
+	key.Limit = filter.Limit
+	key.Search = filter.Search
+
+	b, _ := json.Marshal(key)
+	return string(b)
+}
+
+func sortedSet(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	unique := sorted[:0]
+	for i, v := range sorted {
+		if i == 0 || v != sorted[i-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+func sortedKinds(kinds []int) []int {
+	if kinds == nil {
+		return nil
+	}
+	sorted := append([]int{}, kinds...)
+	sort.Ints(sorted)
+	unique := sorted[:0]
+	for i, k := range sorted {
+		if i == 0 || k != sorted[i-1] {
+			unique = append(unique, k)
+		}
+	}
+	return unique
+}
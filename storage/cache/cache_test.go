@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/fiatjaf/relayer/storage/memory"
+	"github.com/fiatjaf/relayer/storage/storagetest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) relayer.Storage {
+		c := &CachedStorage{Storage: &memory.MemoryBackend{}}
+		if err := c.Init(); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	})
+}
+
+func TestFilterKey(t *testing.T) {
+	since := time.Unix(1670000000, 0)
+	a := nostr.Filter{
+		Authors: []string{"bb", "aa"},
+		Kinds:   []int{1, 0, 1},
+		Tags:    nostr.TagMap{"p": {"y", "x"}, "e": {"z"}},
+		Since:   &since,
+	}
+	b := nostr.Filter{
+		Authors: []string{"aa", "bb", "aa"},
+		Kinds:   []int{0, 1},
+		Tags:    nostr.TagMap{"e": {"z"}, "p": {"x", "y"}},
+		Since:   &since,
+	}
+	if filterKey(&a) != filterKey(&b) {
+		t.Errorf("different keys for equivalent filters:\n%s\n%s", filterKey(&a), filterKey(&b))
+	}
+
+	for _, other := range []nostr.Filter{
+		{Authors: []string{"aa", "bb"}, Kinds: []int{0, 1}, Tags: nostr.TagMap{"e": {"z"}, "p": {"x", "y"}}},
+		{Authors: []string{"aa", "bb"}, Kinds: []int{0, 1}, Tags: nostr.TagMap{"e": {"z"}, "p": {"x", "y"}}, Since: &since, Limit: 1},
+		{Authors: []string{"aa", "bb"}, Kinds: []int{0, 1}, Tags: nostr.TagMap{"e": {"z"}, "q": {"x", "y"}}, Since: &since},
+	} {
+		if filterKey(&a) == filterKey(&other) {
+			t.Errorf("same key for different filters: %s", filterKey(&other))
+		}
+	}
+	if filterKey(&nostr.Filter{}) == filterKey(&nostr.Filter{Kinds: []int{}}) {
+		t.Error("same key for nil and empty kinds")
+	}
+}
+
+func TestCachedStorage(t *testing.T) {
+	c := &CachedStorage{Storage: &memory.MemoryBackend{}, MaxEntries: 2}
+	c.Init()
+
+	alice := nostr.Event{ID: "a1", PubKey: "alice", Kind: 0, CreatedAt: time.Unix(1, 0), Tags: nostr.Tags{}}
+	bob := nostr.Event{ID: "b1", PubKey: "bob", Kind: 1, CreatedAt: time.Unix(2, 0), Tags: nostr.Tags{}}
+	c.SaveEvent(&alice)
+	c.SaveEvent(&bob)
+
+	profile := nostr.Filter{Authors: []string{"alice"}, Kinds: []int{0}}
+	byID := nostr.Filter{IDs: []string{"a1"}}
+	notes := nostr.Filter{Kinds: []int{1}}
+
+	expect := func(f nostr.Filter, wantIDs ...string) {
+		t.Helper()
+		events, err := c.QueryEvents(&f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != len(wantIDs) {
+			t.Fatalf("got %d events; want %v", len(events), wantIDs)
+		}
+		for i, id := range wantIDs {
+			if events[i].ID != id {
+				t.Fatalf("got event %s; want %s", events[i].ID, id)
+			}
+		}
+	}
+	expectStats := func(hits, misses, evictions, invalidations uint64) {
+		t.Helper()
+		s := c.Stats()
+		if s.Hits != hits || s.Misses != misses || s.Evictions != evictions || s.Invalidations != invalidations {
+			t.Fatalf("stats = %+v; want %d hits, %d misses, %d evictions, %d invalidations",
+				s, hits, misses, evictions, invalidations)
+		}
+	}
+
+	expect(profile, "a1")
+	expect(profile, "a1")
+	expect(byID, "a1")
+	expectStats(1, 2, 0, 0)
+
+	// saving an unrelated event keeps both
+	other := nostr.Event{ID: "b2", PubKey: "bob", Kind: 1, CreatedAt: time.Unix(3, 0), Tags: nostr.Tags{}}
+	c.SaveEvent(&other)
+	expect(profile, "a1")
+	expect(byID, "a1")
+	expectStats(3, 2, 0, 0)
+
+	// a new profile invalidates the profile query and the one by id of the replaced event
+	newer := nostr.Event{ID: "a2", PubKey: "alice", Kind: 0, CreatedAt: time.Unix(4, 0), Tags: nostr.Tags{}}
+	c.SaveEvent(&newer)
+	expectStats(3, 2, 0, 2)
+	expect(profile, "a2")
+	expect(byID)
+	expectStats(3, 4, 0, 2)
+
+	// least recently used entries are evicted
+	expect(notes, "b2", "b1")
+	expectStats(3, 5, 1, 2)
+	expect(profile, "a2")
+	expectStats(3, 6, 2, 2)
+
+	// deleting an event drops the results holding it
+	c.DeleteEvent("b2", "bob")
+	expect(notes, "b1")
+	expectStats(3, 7, 2, 3)
+}
+
+func TestCachedStorageTTL(t *testing.T) {
+	now := time.Unix(1670000000, 0)
+	c := &CachedStorage{Storage: &memory.MemoryBackend{}, TTL: time.Minute, now: func() time.Time { return now }}
+	c.Init()
+
+	f := nostr.Filter{Kinds: []int{1}}
+	c.QueryEvents(&f)
+	c.QueryEvents(&f)
+	now = now.Add(2 * time.Minute)
+	c.QueryEvents(&f)
+	if s := c.Stats(); s.Hits != 1 || s.Misses != 2 {
+		t.Errorf("stats = %+v; want 1 hit and 2 misses", s)
+	}
+}
+
+// pruningStorage keeps only the latest event of each author and kind,
+// deleting the older ones in AfterSave like postgres and pebble.
+type pruningStorage struct{ memory.MemoryBackend }
+
+func (s *pruningStorage) BeforeSave(evt *nostr.Event) {}
+
+func (s *pruningStorage) AfterSave(evt *nostr.Event) {
+	older, _ := s.MemoryBackend.QueryEvents(&nostr.Filter{Authors: []string{evt.PubKey}, Kinds: []int{evt.Kind}, Until: &evt.CreatedAt})
+	for _, old := range older {
+		if old.ID != evt.ID {
+			s.MemoryBackend.DeleteEvent(old.ID, old.PubKey)
+		}
+	}
+}
+
+func TestCachedStoragePrune(t *testing.T) {
+	c := &CachedStorage{Storage: &pruningStorage{}}
+	c.Init()
+
+	save := func(evt nostr.Event) {
+		c.BeforeSave(&evt)
+		c.SaveEvent(&evt)
+		c.AfterSave(&evt)
+	}
+	save(nostr.Event{ID: "n1", PubKey: "alice", Kind: 1, CreatedAt: time.Unix(1, 0), Tags: nostr.Tags{}})
+
+	until := time.Unix(1, 500000000)
+	for _, f := range []nostr.Filter{{IDs: []string{"n1"}}, {Authors: []string{"alice"}, Until: &until}} {
+		if events, _ := c.QueryEvents(&f); len(events) != 1 {
+			t.Fatalf("%v: got %d events", f, len(events))
+		}
+	}
+
+	// a newer note prunes n1, which isn't returned from the cache anymore
+	save(nostr.Event{ID: "n2", PubKey: "alice", Kind: 1, CreatedAt: time.Unix(2, 0), Tags: nostr.Tags{}})
+	for _, f := range []nostr.Filter{{IDs: []string{"n1"}}, {Authors: []string{"alice"}, Until: &until}} {
+		if events, _ := c.QueryEvents(&f); len(events) != 0 {
+			t.Errorf("%v: got pruned event %s", f, events[0].ID)
+		}
+	}
+}
+
+// contextStorage records the contexts it is called with.
+type contextStorage struct {
+	memory.MemoryBackend
+	contexts []context.Context
+}
+
+func (s *contextStorage) QueryEventsContext(ctx context.Context, filter *nostr.Filter) ([]nostr.Event, error) {
+	s.contexts = append(s.contexts, ctx)
+	return s.QueryEvents(filter)
+}
+
+func (s *contextStorage) SaveEventContext(ctx context.Context, evt *nostr.Event) error {
+	s.contexts = append(s.contexts, ctx)
+	return s.SaveEvent(evt)
+}
+
+func (s *contextStorage) DeleteEventContext(ctx context.Context, id string, pubkey string) error {
+	s.contexts = append(s.contexts, ctx)
+	return s.DeleteEvent(id, pubkey)
+}
+
+func TestCachedStorageContext(t *testing.T) {
+	inner := &contextStorage{}
+	c := &CachedStorage{Storage: inner}
+	c.Init()
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, true)
+	evt := nostr.Event{ID: "a", PubKey: "alice", Kind: 1, CreatedAt: time.Unix(1, 0), Tags: nostr.Tags{}}
+	c.SaveEventContext(ctx, &evt)
+	c.QueryEventsContext(ctx, &nostr.Filter{IDs: []string{"a"}})
+	c.DeleteEventContext(ctx, "a", "alice")
+
+	if len(inner.contexts) != 3 {
+		t.Fatalf("storage called with a context %d times, want 3", len(inner.contexts))
+	}
+	for i, got := range inner.contexts {
+		if got.Value(key{}) == nil {
+			t.Errorf("call %d: context not passed on", i)
+		}
+	}
+}
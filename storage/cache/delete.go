@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// DeleteEvent deletes the event from Storage and drops the cached results holding it.
+func (c *CachedStorage) DeleteEvent(id string, pubkey string) error {
+	return c.DeleteEventContext(context.Background(), id, pubkey)
+}
+
+// DeleteEventContext is like DeleteEvent, passing ctx on to Storage
+// if it is a [relayer.ContextDeleter].
+func (c *CachedStorage) DeleteEventContext(ctx context.Context, id string, pubkey string) error {
+	var err error
+	if cd, ok := c.Storage.(relayer.ContextDeleter); ok {
+		err = cd.DeleteEventContext(ctx, id, pubkey)
+	} else {
+		err = c.Storage.DeleteEvent(id, pubkey)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*entry).holds(func(held *nostr.Event) bool { return held.ID == id }) {
+			c.remove(elem)
+			c.invalidations++
+		}
+		elem = next
+	}
+
+	return err
+}
+
+func (c *CachedStorage) BeforeDelete(id string, pubkey string) {
+	if deleter, ok := c.Storage.(relayer.AdvancedDeleter); ok {
+		deleter.BeforeDelete(id, pubkey)
+	}
+}
+
+func (c *CachedStorage) AfterDelete(id string, pubkey string) {
+	if deleter, ok := c.Storage.(relayer.AdvancedDeleter); ok {
+		deleter.AfterDelete(id, pubkey)
+	}
+}
@@ -0,0 +1,26 @@
+package cache
+
+import "github.com/fiatjaf/relayer"
+
+// RegisterMetrics exposes [Stats], along with the metrics of the wrapped storage.
+func (c *CachedStorage) RegisterMetrics(m *relayer.Metrics) {
+	m.CounterFunc("relayer_cache_hits_total", "Queries answered from the cache.", func() float64 {
+		return float64(c.Stats().Hits)
+	})
+	m.CounterFunc("relayer_cache_misses_total", "Queries passed to the underlying storage.", func() float64 {
+		return float64(c.Stats().Misses)
+	})
+	m.CounterFunc("relayer_cache_evictions_total", "Entries evicted to make room for newer ones.", func() float64 {
+		return float64(c.Stats().Evictions)
+	})
+	m.CounterFunc("relayer_cache_invalidations_total", "Entries dropped because of a new or deleted event.", func() float64 {
+		return float64(c.Stats().Invalidations)
+	})
+	m.GaugeFunc("relayer_cache_entries", "Query results currently cached.", func() float64 {
+		return float64(c.Stats().Entries)
+	})
+
+	if reporter, ok := c.Storage.(relayer.MetricsReporter); ok {
+		reporter.RegisterMetrics(m)
+	}
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// QueryEvents returns the cached results for filter, or queries Storage and
+// caches its results.
+func (c *CachedStorage) QueryEvents(filter *nostr.Filter) ([]nostr.Event, error) {
+	return c.QueryEventsContext(context.Background(), filter)
+}
+
+// QueryEventsContext is like QueryEvents, passing ctx on to Storage
+// if it is a [relayer.ContextQuerier].
+func (c *CachedStorage) QueryEventsContext(ctx context.Context, filter *nostr.Filter) ([]nostr.Event, error) {
+	if filter == nil {
+		return nil, errors.New("filter cannot be null")
+	}
+	key := filterKey(filter)
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		e := elem.Value.(*entry)
+		if e.expires.IsZero() || c.timeNow().Before(e.expires) {
+			c.lru.MoveToFront(elem)
+			events := append([]nostr.Event(nil), e.events...)
+			c.hits++
+			c.mu.Unlock()
+			return events, nil
+		}
+		c.remove(elem)
+	}
+	generation := c.generation
+	c.misses++
+	c.mu.Unlock()
+
+	var events []nostr.Event
+	var err error
+	if cq, ok := c.Storage.(relayer.ContextQuerier); ok {
+		events, err = cq.QueryEventsContext(ctx, filter)
+	} else {
+		events, err = c.Storage.QueryEvents(filter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		// something was written meanwhile, the results may already be stale
+		return events, nil
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	e := &entry{
+		key:    key,
+		filter: *filter,
+		events: append([]nostr.Event(nil), events...),
+	}
+	if c.TTL > 0 {
+		e.expires = c.timeNow().Add(c.TTL)
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.MaxEntries {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+
+	return events, nil
+}
+
+// remove drops an entry from the cache, c.mu being held.
+func (c *CachedStorage) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*entry).key)
+}
+
+func (c *CachedStorage) BeforeQuery(filter *nostr.Filter) {
+	if querier, ok := c.Storage.(relayer.AdvancedQuerier); ok {
+		querier.BeforeQuery(filter)
+	}
+}
+
+func (c *CachedStorage) AfterQuery(events []nostr.Event, filter *nostr.Filter) {
+	if querier, ok := c.Storage.(relayer.AdvancedQuerier); ok {
+		querier.AfterQuery(events, filter)
+	}
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func (c *CachedStorage) SaveEvent(evt *nostr.Event) error {
+	return c.SaveEventContext(context.Background(), evt)
+}
+
+// SaveEventContext is like SaveEvent, passing ctx on to Storage
+// if it is a [relayer.ContextSaver].
+func (c *CachedStorage) SaveEventContext(ctx context.Context, evt *nostr.Event) error {
+	var err error
+	if cs, ok := c.Storage.(relayer.ContextSaver); ok {
+		err = cs.SaveEventContext(ctx, evt)
+	} else {
+		err = c.Storage.SaveEvent(evt)
+	}
+	c.invalidate(evt, false)
+	return err
+}
+
+func (c *CachedStorage) BeforeSave(evt *nostr.Event) {
+	if saver, ok := c.Storage.(relayer.AdvancedSaver); ok {
+		saver.BeforeSave(evt)
+	}
+}
+
+// AfterSave invalidates the cache again, as storages may delete events
+// around saving, such as the older events of the same author and kind
+// pruned by postgres and pebble, whatever the kind.
+func (c *CachedStorage) AfterSave(evt *nostr.Event) {
+	if saver, ok := c.Storage.(relayer.AdvancedSaver); ok {
+		saver.AfterSave(evt)
+		c.invalidate(evt, true)
+	}
+}
+
+// invalidate drops the cached results which saving evt may have changed:
+// those of filters matching it and, as it may replace or prune them, those
+// holding events of the same author and kind if it is replaceable or if
+// prunes is set.
+func (c *CachedStorage) invalidate(evt *nostr.Event, prunes bool) {
+	replaces := prunes || isReplaceable(evt.Kind)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		e := elem.Value.(*entry)
+		if e.filter.Matches(evt) || (replaces && e.holds(func(held *nostr.Event) bool {
+			return held.PubKey == evt.PubKey && held.Kind == evt.Kind
+		})) {
+			c.remove(elem)
+			c.invalidations++
+		}
+		elem = next
+	}
+}
+
+// holds reports whether any of the cached events satisfies fn.
+func (e *entry) holds(fn func(*nostr.Event) bool) bool {
+	for i := range e.events {
+		if fn(&e.events[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isReplaceable reports whether saving an event of this kind
+// may delete other events, in any of the storages.
+func isReplaceable(kind int) bool {
+	return kind == nostr.KindSetMetadata || kind == nostr.KindContactList || kind == nostr.KindRecommendServer ||
+		(10000 <= kind && kind < 20000) || (30000 <= kind && kind < 40000)
+}
@@ -5,42 +5,83 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
+	"log"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/aquasecurity/esquery"
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/fiatjaf/relayer"
 	"github.com/nbd-wtf/go-nostr"
 )
 
+// stdLogger logs through the standard logger.
+type stdLogger struct{}
+
+func (stdLogger) Infof(format string, v ...any)    { log.Printf(format, v...) }
+func (stdLogger) Warningf(format string, v ...any) { log.Printf(format, v...) }
+func (stdLogger) Errorf(format string, v ...any)   { log.Printf(format, v...) }
+
 type IndexedEvent struct {
 	Event         nostr.Event `json:"event"`
 	ContentSearch string      `json:"content_search"`
+	// Tags holds the values of single-letter tags by tag name,
+	// for exact #x filters as per NIP-12.
+	Tags map[string][]string `json:"tags"`
+	// Profile is set for kind-0 events.
+	Profile *Profile `json:"profile,omitempty"`
+	// Language is the ISO-639-1 code of the content language, if known.
+	Language string `json:"language,omitempty"`
 }
 
-var indexMapping = `
+var indexMappings = `
 {
-	"settings": {
-		"number_of_shards": 1,
-		"number_of_replicas": 0
-	},
-	"mappings": {
-		"dynamic": false,
-		"properties": {
-			"event": {
-				"dynamic": false,
-				"properties": {
-					"id": {"type": "keyword"},
-					"pubkey": {"type": "keyword"},
-					"kind": {"type": "integer"},
-					"tags": {"type": "keyword"},
-					"created_at": {"type": "date"}
-				}
-			},
-			"content_search": {"type": "text"}
-		}
+	"dynamic": false,
+	"properties": {
+		"event": {
+			"dynamic": false,
+			"properties": {
+				"id": {"type": "keyword"},
+				"pubkey": {"type": "keyword"},
+				"kind": {"type": "integer"},
+				"created_at": {"type": "date"}
+			}
+		},
+		"tags": {"type": "flattened"},
+		"content_search": {"type": "text"},
+		"profile": {
+			"properties": {
+				"name": {"type": "text"},
+				"display_name": {"type": "text"},
+				"about": {"type": "text"},
+				"nip05": {"type": "text"},
+				"domain": {"type": "keyword"}
+			}
+		},
+		"language": {"type": "keyword"}
+	}
+}
+`
+
+// upgradeMapping adds fields introduced since the first version of indexMappings
+// to existing indices. See also [ElasticsearchStorage.ReindexTags] and
+// [ElasticsearchStorage.MigrateReplaceables].
+var upgradeMapping = `
+{
+	"properties": {
+		"tags": {"type": "flattened"},
+		"profile": {
+			"properties": {
+				"name": {"type": "text"},
+				"display_name": {"type": "text"},
+				"about": {"type": "text"},
+				"nip05": {"type": "text"},
+				"domain": {"type": "keyword"}
+			}
+		},
+		"language": {"type": "keyword"}
 	}
 }
 `
@@ -48,6 +89,34 @@ var indexMapping = `
 type ElasticsearchStorage struct {
 	IndexName string
 
+	// Addresses of the cluster nodes. If empty, the comma-separated list
+	// in the ES_URL environment variable is used, and then http://localhost:9200.
+	Addresses []string
+	// Username and Password for basic authentication, or APIKey,
+	// base64-encoded as returned by the create API key API.
+	Username string
+	Password string
+	APIKey   string
+	// CACert is a PEM-encoded certificate authority to trust, for clusters
+	// using self-signed certificates.
+	CACert []byte
+
+	// NumberOfShards and NumberOfReplicas are used when creating the index.
+	// They default to 1 and 0, which suits a single node.
+	NumberOfShards   int
+	NumberOfReplicas int
+
+	// Bulk indexer tuning: number of workers (default 2), size in bytes
+	// at which pending items are flushed (default 5MB) and maximum time
+	// items wait before being flushed (default 3s).
+	BulkWorkers       int
+	BulkFlushBytes    int
+	BulkFlushInterval time.Duration
+
+	// Log receives errors happening in the background, such as bulk indexer
+	// flush failures. It defaults to the standard logger.
+	Log relayer.Logger
+
 	es *elasticsearch.Client
 	bi esutil.BulkIndexer
 }
@@ -57,37 +126,58 @@ func (ess *ElasticsearchStorage) Init() error {
 	if ess.IndexName == "" {
 		ess.IndexName = "events"
 	}
-
-	cfg := elasticsearch.Config{}
-	if x := os.Getenv("ES_URL"); x != "" {
-		cfg.Addresses = strings.Split(x, ",")
+	if ess.Log == nil {
+		ess.Log = stdLogger{}
 	}
-	es, err := elasticsearch.NewClient(cfg)
+
+	es, err := elasticsearch.NewClient(ess.config())
 	if err != nil {
 		return err
 	}
 
-	res, err := es.Indices.Create(ess.IndexName, es.Indices.Create.WithBody(strings.NewReader(indexMapping)))
+	res, err := es.Indices.Create(ess.IndexName, es.Indices.Create.WithBody(strings.NewReader(ess.indexBody())))
 	if err != nil {
-		return err
+		return fmt.Errorf("elasticsearch: %w", err)
 	}
+	defer res.Body.Close()
 	if res.IsError() {
-		body, _ := io.ReadAll(res.Body)
-		txt := string(body)
-		if !strings.Contains(txt, "resource_already_exists_exception") {
-			return fmt.Errorf("%s", txt)
+		err := responseError(res)
+		if e, ok := err.(*Error); !ok || e.Type != "resource_already_exists_exception" {
+			return err
+		}
+
+		// make sure existing indices have all the fields
+		res, err := es.Indices.PutMapping([]string{ess.IndexName}, strings.NewReader(upgradeMapping))
+		if err != nil {
+			return fmt.Errorf("elasticsearch: %w", err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("failed to update mapping: %w", responseError(res))
 		}
 	}
 
 	// bulk indexer
+	workers := ess.BulkWorkers
+	if workers <= 0 {
+		workers = 2
+	}
+	flushInterval := ess.BulkFlushInterval
+	if flushInterval <= 0 {
+		flushInterval = 3 * time.Second
+	}
 	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
 		Index:         ess.IndexName,
 		Client:        es,
-		NumWorkers:    2,
-		FlushInterval: 3 * time.Second,
+		NumWorkers:    workers,
+		FlushBytes:    ess.BulkFlushBytes,
+		FlushInterval: flushInterval,
+		OnError: func(ctx context.Context, err error) {
+			ess.Log.Errorf("elasticsearch bulk indexer: %v", err)
+		},
 	})
 	if err != nil {
-		return fmt.Errorf("error creating the indexer: %s", err)
+		return fmt.Errorf("error creating the indexer: %w", err)
 	}
 
 	ess.es = es
@@ -96,90 +186,149 @@ func (ess *ElasticsearchStorage) Init() error {
 	return nil
 }
 
-func (ess *ElasticsearchStorage) DeleteEvent(id string, pubkey string) error {
-	// todo: is pubkey match required?
+func (ess *ElasticsearchStorage) config() elasticsearch.Config {
+	cfg := elasticsearch.Config{
+		Addresses: ess.Addresses,
+		Username:  ess.Username,
+		Password:  ess.Password,
+		APIKey:    ess.APIKey,
+		CACert:    ess.CACert,
+	}
+	if len(cfg.Addresses) == 0 {
+		if x := os.Getenv("ES_URL"); x != "" {
+			cfg.Addresses = strings.Split(x, ",")
+		}
+	}
+	return cfg
+}
 
-	done := make(chan error)
-	err := ess.bi.Add(
-		context.Background(),
-		esutil.BulkIndexerItem{
-			Action:     "delete",
-			DocumentID: id,
-			OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
-				close(done)
-			},
-			OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-				if err != nil {
-					done <- err
-				} else {
-					// ok if deleted item not found
-					if res.Status == 404 {
-						close(done)
-						return
-					}
-					txt, _ := json.Marshal(res)
-					err := fmt.Errorf("ERROR: %s", txt)
-					done <- err
-				}
-			},
-		},
+// indexBody is the body of the request creating the index.
+func (ess *ElasticsearchStorage) indexBody() string {
+	shards := ess.NumberOfShards
+	if shards <= 0 {
+		shards = 1
+	}
+	return fmt.Sprintf(`{"settings": {"number_of_shards": %d, "number_of_replicas": %d}, "mappings": %s}`,
+		shards, ess.NumberOfReplicas, indexMappings)
+}
+
+// CheckHealth fails if the cluster is unreachable or the health of the index is red.
+func (ess *ElasticsearchStorage) CheckHealth(ctx context.Context) error {
+	es := ess.es
+	res, err := es.Cluster.Health(
+		es.Cluster.Health.WithContext(ctx),
+		es.Cluster.Health.WithIndex(ess.IndexName),
 	)
 	if err != nil {
+		return fmt.Errorf("elasticsearch: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return responseError(res)
+	}
+
+	var health struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&health); err != nil {
 		return err
 	}
+	if health.Status == "red" {
+		return fmt.Errorf("elasticsearch: index %s health is red", ess.IndexName)
+	}
+	return nil
+}
 
-	err = <-done
-	return err
+// Stats returns the bulk indexer counters, such as the number of events
+// indexed and failed so far.
+func (ess *ElasticsearchStorage) Stats() esutil.BulkIndexerStats {
+	return ess.bi.Stats()
 }
 
-func (ess *ElasticsearchStorage) SaveEvent(event *nostr.Event) error {
+// DeleteEvent deletes the event with the given id only if it was signed by pubkey.
+func (ess *ElasticsearchStorage) DeleteEvent(id string, pubkey string) error {
+	// replaceable events are not stored under their id,
+	// so it is simpler to always delete by query
+	return ess.deleteByQuery(esquery.Bool().
+		Must(esquery.Term("event.id", id)).
+		Must(esquery.Term("event.pubkey", pubkey)))
+}
+
+// indexedEvent returns the document event is stored as.
+func indexedEvent(event *nostr.Event) *IndexedEvent {
 	ie := &IndexedEvent{
 		Event: *event,
+		Tags:  indexTags(event.Tags),
 	}
 
 	// post processing: index for FTS
-	// some ideas:
-	// - index kind=0 fields a set of dedicated mapped fields
-	//   (or use a separate index for profiles with a dedicated mapping)
+	// some more ideas:
 	// - if it's valid JSON just index the "values" and not the keys
-	// - more content introspection: language detection
 	// - denormalization... attach profile + ranking signals to events
-	if event.Kind != 4 {
+	switch event.Kind {
+	case nostr.KindSetMetadata:
+		ie.Profile = parseProfile(event.Content)
+		if ie.Profile != nil {
+			ie.Language = detectLanguage(&nostr.Event{Tags: event.Tags, Content: ie.Profile.About})
+		}
+	case nostr.KindEncryptedDirectMessage:
+		// nothing to search for
+	default:
 		ie.ContentSearch = event.Content
+		ie.Language = detectLanguage(event)
 	}
+	return ie
+}
 
-	data, err := json.Marshal(ie)
+func (ess *ElasticsearchStorage) SaveEvent(event *nostr.Event) error {
+	data, err := json.Marshal(indexedEvent(event))
 	if err != nil {
 		return err
 	}
 
+	item := esutil.BulkIndexerItem{
+		Action:     "index",
+		DocumentID: documentID(event),
+		Body:       bytes.NewReader(data),
+	}
+	if isReplaceable(event.Kind) || isParameterizedReplaceable(event.Kind) {
+		// only overwrite versions created at the same time or before
+		version := event.CreatedAt.Unix()
+		item.Version = &version
+		item.VersionType = "external_gte"
+	}
+
 	done := make(chan error)
 
 	// adapted from:
 	// https://github.com/elastic/go-elasticsearch/blob/main/_examples/bulk/indexer.go#L196
-	err = ess.bi.Add(
-		context.Background(),
-		esutil.BulkIndexerItem{
-			Action:     "index",
-			DocumentID: event.ID,
-			Body:       bytes.NewReader(data),
-			OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
-				close(done)
-			},
-			OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-				if err != nil {
-					done <- err
-				} else {
-					err := fmt.Errorf("ERROR: %s: %s", res.Error.Type, res.Error.Reason)
-					done <- err
-				}
-			},
-		},
-	)
-	if err != nil {
+	item.OnSuccess = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+		close(done)
+	}
+	item.OnFailure = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+		if err == nil && res.Error.Type == "version_conflict_engine_exception" {
+			// a newer version is already stored
+			close(done)
+			return
+		}
+		done <- itemError(res, err)
+	}
+	if err := ess.bi.Add(context.Background(), item); err != nil {
 		return err
 	}
 
-	err = <-done
-	return err
+	return <-done
+}
+
+// indexTags groups the values of single-letter tags by tag name.
+func indexTags(tags nostr.Tags) map[string][]string {
+	indexed := make(map[string][]string)
+	for _, tag := range tags {
+		if len(tag) < 2 || len(tag[0]) != 1 {
+			continue
+		}
+		indexed[tag[0]] = append(indexed[tag[0]], tag[1])
+	}
+	return indexed
 }
@@ -0,0 +1,71 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestResponseError(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   Error
+	}{
+		{400, `{"error":{"type":"resource_already_exists_exception","reason":"index [events] already exists"},"status":400}`,
+			Error{400, "resource_already_exists_exception", "index [events] already exists"}},
+		{401, `{"error":"unauthorized","status":401}`, Error{401, "", "unauthorized"}},
+		{502, `Bad Gateway`, Error{502, "", "Bad Gateway"}},
+	}
+	for _, tt := range tests {
+		res := &esapi.Response{StatusCode: tt.status, Body: io.NopCloser(strings.NewReader(tt.body))}
+		err := responseError(res)
+		var e *Error
+		if !errors.As(err, &e) || *e != tt.want {
+			t.Errorf("responseError(%s) = %#v; want %#v", tt.body, err, tt.want)
+		}
+	}
+}
+
+func TestIndexBody(t *testing.T) {
+	ess := &ElasticsearchStorage{NumberOfShards: 3, NumberOfReplicas: 2}
+	var body struct {
+		Settings struct {
+			Shards   int `json:"number_of_shards"`
+			Replicas int `json:"number_of_replicas"`
+		}
+		Mappings map[string]any
+	}
+	if err := json.Unmarshal([]byte(ess.indexBody()), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Settings.Shards != 3 || body.Settings.Replicas != 2 || body.Mappings["properties"] == nil {
+		t.Errorf("indexBody = %s", ess.indexBody())
+	}
+}
+
+func TestDocumentID(t *testing.T) {
+	tests := []struct {
+		evt  nostr.Event
+		want string
+	}{
+		{nostr.Event{ID: "id", PubKey: "pk", Kind: 1}, "id"},
+		{nostr.Event{ID: "id", PubKey: "pk", Kind: 0}, "pk:0"},
+		{nostr.Event{ID: "id", PubKey: "pk", Kind: 10002}, "pk:10002"},
+		{nostr.Event{ID: "id", PubKey: "pk", Kind: 30023, Tags: nostr.Tags{{"d", "post"}}}, "pk:30023:post"},
+		{nostr.Event{ID: "id", PubKey: "pk", Kind: 30023}, "pk:30023:"},
+		// too long for an _id
+		{nostr.Event{ID: "id", PubKey: "pk", Kind: 30023, Tags: nostr.Tags{{"d", strings.Repeat("a", 600)}}},
+			"pk:30023:ba35c170729417f1499e0886e7e12fcdb4ab00ad411110ae1e888c766d4ed70d"},
+	}
+	for _, tt := range tests {
+		if got := documentID(&tt.evt); got != tt.want {
+			t.Errorf("documentID(kind %d) = %q; want %q", tt.evt.Kind, got, tt.want)
+		}
+	}
+}
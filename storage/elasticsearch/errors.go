@@ -0,0 +1,61 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// Error is returned when Elasticsearch answers a request with an error,
+// as opposed to failing to reach the cluster at all.
+type Error struct {
+	// Status is the HTTP status of the response, or of the bulk item.
+	Status int
+	// Type and Reason are taken from the error object of the response,
+	// such as "index_not_found_exception". Reason holds the whole response
+	// body if it couldn't be parsed.
+	Type   string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("elasticsearch: status %d: %s", e.Status, e.Reason)
+	}
+	return fmt.Sprintf("elasticsearch: status %d: %s: %s", e.Status, e.Type, e.Reason)
+}
+
+// responseError reads the error of a response for which res.IsError is true.
+func responseError(res *esapi.Response) error {
+	body, _ := io.ReadAll(res.Body)
+
+	var r struct {
+		Error json.RawMessage `json:"error"`
+	}
+	var details struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(body, &r); err == nil && len(r.Error) > 0 {
+		if err := json.Unmarshal(r.Error, &details); err == nil && details.Type != "" {
+			return &Error{Status: res.StatusCode, Type: details.Type, Reason: details.Reason}
+		}
+		// some errors are plain strings
+		var reason string
+		if err := json.Unmarshal(r.Error, &reason); err == nil {
+			return &Error{Status: res.StatusCode, Reason: reason}
+		}
+	}
+	return &Error{Status: res.StatusCode, Reason: string(body)}
+}
+
+// itemError converts the failure of a bulk indexer item into an error.
+func itemError(res esutil.BulkIndexerResponseItem, err error) error {
+	if err != nil {
+		return fmt.Errorf("elasticsearch: %w", err)
+	}
+	return &Error{Status: res.Status, Type: res.Error.Type, Reason: res.Error.Reason}
+}
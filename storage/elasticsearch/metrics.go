@@ -0,0 +1,19 @@
+package elasticsearch
+
+import "github.com/fiatjaf/relayer"
+
+// RegisterMetrics exposes the statistics of the bulk indexer.
+func (ess *ElasticsearchStorage) RegisterMetrics(m *relayer.Metrics) {
+	m.CounterFunc("relayer_elasticsearch_indexed_total", "Events indexed by the bulk indexer.", func() float64 {
+		return float64(ess.Stats().NumIndexed)
+	})
+	m.CounterFunc("relayer_elasticsearch_failed_total", "Bulk indexer operations which failed.", func() float64 {
+		return float64(ess.Stats().NumFailed)
+	})
+	m.CounterFunc("relayer_elasticsearch_flushes_total", "Bulk requests sent to elasticsearch.", func() float64 {
+		return float64(ess.Stats().NumFlushed)
+	})
+	m.CounterFunc("relayer_elasticsearch_requests_total", "Requests sent by the bulk indexer.", func() float64 {
+		return float64(ess.Stats().NumRequests)
+	})
+}
@@ -6,9 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log"
 	"reflect"
+	"sort"
 
 	"github.com/aquasecurity/esquery"
 	"github.com/elastic/go-elasticsearch/v8/esutil"
@@ -29,6 +28,15 @@ type EsSearchResult struct {
 	}
 }
 
+// searchFields are matched against the search text, profile names weighing more.
+var searchFields = []string{
+	"content_search",
+	"profile.name^3",
+	"profile.display_name^3",
+	"profile.nip05^2",
+	"profile.about",
+}
+
 func buildDsl(filter *nostr.Filter) ([]byte, error) {
 	dsl := esquery.Bool()
 
@@ -58,14 +66,14 @@ func buildDsl(filter *nostr.Filter) ([]byte, error) {
 		dsl.Must(esquery.Terms("event.kind", toInterfaceSlice(filter.Kinds)...))
 	}
 
-	// tags
-	if len(filter.Tags) > 0 {
-		tagQ := esquery.Bool()
-		for char, terms := range filter.Tags {
-			vs := toInterfaceSlice(append(terms, char))
-			tagQ.Should(esquery.Terms("event.tags", vs...))
-		}
-		dsl.Must(tagQ)
+	// tags: events must have any of the values for each of the tag names
+	names := make([]string, 0, len(filter.Tags))
+	for name := range filter.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dsl.Must(esquery.Terms("tags."+name, toInterfaceSlice(filter.Tags[name])...))
 	}
 
 	// since
@@ -78,9 +86,16 @@ func buildDsl(filter *nostr.Filter) ([]byte, error) {
 		dsl.Must(esquery.Range("event.created_at").Lt(filter.Until.Unix()))
 	}
 
-	// search
+	// search, with the NIP-50 extensions parsed by parseSearch.
+	// domain: is resolved into authors before building the query.
 	if filter.Search != "" {
-		dsl.Must(esquery.Match("content_search", filter.Search))
+		q := parseSearch(filter.Search)
+		if q.Text != "" {
+			dsl.Must(esquery.MultiMatch(q.Text).Fields(searchFields...))
+		}
+		if q.Language != "" {
+			dsl.Must(esquery.Term("language", q.Language))
+		}
 	}
 
 	return json.Marshal(esquery.Query(dsl))
@@ -91,7 +106,12 @@ func (ess *ElasticsearchStorage) getByID(filter *nostr.Filter) ([]nostr.Event, e
 		esutil.NewJSONReader(filter),
 		ess.es.Mget.WithIndex(ess.IndexName))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("elasticsearch: %w", err)
+	}
+	defer got.Body.Close()
+
+	if got.IsError() {
+		return nil, responseError(got)
 	}
 
 	var mgetResponse struct {
@@ -105,12 +125,29 @@ func (ess *ElasticsearchStorage) getByID(filter *nostr.Filter) ([]nostr.Event, e
 	}
 
 	events := make([]nostr.Event, 0, len(mgetResponse.Docs))
+	found := make(map[string]struct{}, len(mgetResponse.Docs))
 	for _, e := range mgetResponse.Docs {
 		if e.Found {
 			events = append(events, e.Source.Event)
+			found[e.Source.Event.ID] = struct{}{}
 		}
 	}
 
+	// replaceable events are stored under a different document id
+	var missing []string
+	for _, id := range filter.IDs {
+		if _, ok := found[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		more, err := ess.search(&nostr.Filter{IDs: missing}, searchQuery{})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, more...)
+	}
+
 	return events, nil
 }
 
@@ -124,6 +161,20 @@ func (ess *ElasticsearchStorage) QueryEvents(filter *nostr.Filter) ([]nostr.Even
 		return ess.getByID(filter)
 	}
 
+	search := parseSearch(filter.Search)
+	if search.Domain != "" {
+		f, err := ess.withDomainAuthors(filter, search.Domain)
+		if err != nil || f == nil {
+			return nil, err
+		}
+		filter = f
+	}
+
+	return ess.search(filter, search)
+}
+
+// search runs the query built from filter, with q parsed from filter.Search.
+func (ess *ElasticsearchStorage) search(filter *nostr.Filter, q searchQuery) ([]nostr.Event, error) {
 	dsl, err := buildDsl(filter)
 	if err != nil {
 		return nil, err
@@ -134,6 +185,12 @@ func (ess *ElasticsearchStorage) QueryEvents(filter *nostr.Filter) ([]nostr.Even
 		limit = filter.Limit
 	}
 
+	// rank by relevance when searching, unless asked otherwise
+	sortBy := []string{"event.created_at:desc"}
+	if q.Text != "" && q.Sort == "relevance" {
+		sortBy = []string{"_score:desc", "event.created_at:desc"}
+	}
+
 	es := ess.es
 	res, err := es.Search(
 		es.Search.WithContext(context.Background()),
@@ -141,17 +198,15 @@ func (ess *ElasticsearchStorage) QueryEvents(filter *nostr.Filter) ([]nostr.Even
 
 		es.Search.WithBody(bytes.NewReader(dsl)),
 		es.Search.WithSize(limit),
-		es.Search.WithSort("event.created_at:desc"),
+		es.Search.WithSort(sortBy...),
 	)
 	if err != nil {
-		log.Fatalf("Error getting response: %s", err)
+		return nil, fmt.Errorf("elasticsearch: %w", err)
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
-		txt, _ := io.ReadAll(res.Body)
-		fmt.Println("oh no", string(txt))
-		return nil, fmt.Errorf("%s", txt)
+		return nil, responseError(res)
 	}
 
 	var r EsSearchResult
@@ -167,13 +222,62 @@ func (ess *ElasticsearchStorage) QueryEvents(filter *nostr.Filter) ([]nostr.Even
 	return events, nil
 }
 
+// withDomainAuthors returns a copy of filter restricted to authors whose profile
+// has a NIP-05 identifier at domain, or nil if there are none.
+func (ess *ElasticsearchStorage) withDomainAuthors(filter *nostr.Filter, domain string) (*nostr.Filter, error) {
+	dsl, err := json.Marshal(esquery.Query(esquery.Bool().
+		Must(esquery.Term("event.kind", nostr.KindSetMetadata)).
+		Must(esquery.Term("profile.domain", domain))))
+	if err != nil {
+		return nil, err
+	}
+
+	es := ess.es
+	res, err := es.Search(
+		es.Search.WithContext(context.Background()),
+		es.Search.WithIndex(ess.IndexName),
+		es.Search.WithBody(bytes.NewReader(dsl)),
+		es.Search.WithSize(1000),
+		es.Search.WithSourceIncludes("event.pubkey"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("elasticsearch: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+
+	var r EsSearchResult
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+
+	var authors []string
+	for _, hit := range r.Hits.Hits {
+		pubkey := hit.Source.Event.PubKey
+		if filter.Authors == nil || nostr.ContainsPrefixOf(filter.Authors, pubkey) {
+			authors = append(authors, pubkey)
+		}
+	}
+	if len(authors) == 0 {
+		return nil, nil
+	}
+
+	f := *filter
+	f.Authors = authors
+	return &f, nil
+}
+
 func isGetByID(filter *nostr.Filter) bool {
 	isGetById := len(filter.IDs) > 0 &&
 		len(filter.Authors) == 0 &&
 		len(filter.Kinds) == 0 &&
 		len(filter.Tags) == 0 &&
 		filter.Since == nil &&
-		filter.Until == nil
+		filter.Until == nil &&
+		filter.Search == ""
 
 	if isGetById {
 		for _, id := range filter.IDs {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
@@ -34,6 +35,43 @@ func TestQuery(t *testing.T) {
 
 }
 
+func TestQueryTags(t *testing.T) {
+	filter := &nostr.Filter{
+		Tags: nostr.TagMap{
+			"p": []string{"aaa", "bbb"},
+			"e": []string{"p"},
+		},
+	}
+
+	dsl, err := buildDsl(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"query":{"bool":{"must":[{"terms":{"tags.e":["p"]}},{"terms":{"tags.p":["aaa","bbb"]}}]}}}`
+	if string(dsl) != want {
+		t.Errorf("buildDsl = %s; want %s", dsl, want)
+	}
+}
+
+func TestIndexTags(t *testing.T) {
+	tags := nostr.Tags{
+		{"e", "abc", "wss://relay"},
+		{"p", "aaa"},
+		{"e", "def"},
+		{"client", "x"},
+		{"t"},
+	}
+	got := indexTags(tags)
+	want := map[string][]string{
+		"e": {"abc", "def"},
+		"p": {"aaa"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("indexTags = %v; want %v", got, want)
+	}
+}
+
 func pprint(j []byte) {
 	var dst bytes.Buffer
 	err := json.Indent(&dst, j, "", "    ")
@@ -0,0 +1,58 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// reindexTagsQuery fills the tags field from event.tags on documents indexed
+// before tags were indexed by name.
+var reindexTagsQuery = `
+{
+	"query": {
+		"bool": {
+			"must_not": {"exists": {"field": "tags"}}
+		}
+	},
+	"script": {
+		"lang": "painless",
+		"source": "Map m = new HashMap(); for (def t : ctx._source.event.tags) { if (t.size() > 1 && t[0].length() == 1) { m.computeIfAbsent(t[0], k -> new ArrayList()).add(t[1]); } } ctx._source.tags = m;"
+	}
+}
+`
+
+// ReindexTags updates documents indexed by previous versions of this package,
+// which only had a flat list of tag names and values, so that they match
+// #x filters. It returns the number of documents updated.
+//
+// It is safe to call it more than once, although documents without any
+// single-letter tag are updated again every time.
+func (ess *ElasticsearchStorage) ReindexTags(ctx context.Context) (int, error) {
+	es := ess.es
+	res, err := es.UpdateByQuery(
+		[]string{ess.IndexName},
+		es.UpdateByQuery.WithContext(ctx),
+		es.UpdateByQuery.WithBody(strings.NewReader(reindexTagsQuery)),
+		es.UpdateByQuery.WithConflicts("proceed"),
+		es.UpdateByQuery.WithRefresh(true),
+		es.UpdateByQuery.WithWaitForCompletion(true),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("elasticsearch: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return 0, responseError(res)
+	}
+
+	var r struct {
+		Updated int `json:"updated"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return 0, err
+	}
+	return r.Updated, nil
+}
@@ -0,0 +1,228 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aquasecurity/esquery"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func isReplaceable(kind int) bool {
+	return kind == nostr.KindSetMetadata || kind == nostr.KindContactList || (10000 <= kind && kind < 20000)
+}
+
+func isParameterizedReplaceable(kind int) bool {
+	return 30000 <= kind && kind < 40000
+}
+
+func dTag(evt *nostr.Event) string {
+	if tag := evt.Tags.GetFirst([]string{"d", ""}); tag != nil {
+		return tag.Value()
+	}
+	return ""
+}
+
+// maxDocumentIDLength is the maximum size of an _id in bytes.
+const maxDocumentIDLength = 512
+
+// documentID returns the id of the document an event is stored as:
+// its own id, or pubkey:kind[:d] for replaceable events so that newer
+// versions overwrite older ones. d is replaced by its SHA-256 hash
+// when the id would be too long for Elasticsearch.
+func documentID(evt *nostr.Event) string {
+	switch {
+	case isReplaceable(evt.Kind):
+		return evt.PubKey + ":" + strconv.Itoa(evt.Kind)
+	case isParameterizedReplaceable(evt.Kind):
+		id := evt.PubKey + ":" + strconv.Itoa(evt.Kind) + ":" + dTag(evt)
+		if len(id) > maxDocumentIDLength {
+			hash := sha256.Sum256([]byte(dTag(evt)))
+			id = evt.PubKey + ":" + strconv.Itoa(evt.Kind) + ":" + hex.EncodeToString(hash[:])
+		}
+		return id
+	default:
+		return evt.ID
+	}
+}
+
+// replaceableKindsQuery matches the documents of replaceable events.
+var replaceableKindsQuery = `
+{
+	"query": {
+		"bool": {
+			"should": [
+				{"terms": {"event.kind": [0, 3]}},
+				{"range": {"event.kind": {"gte": 10000, "lt": 20000}}},
+				{"range": {"event.kind": {"gte": 30000, "lt": 40000}}}
+			],
+			"minimum_should_match": 1
+		}
+	},
+	"_source": ["event"]
+}
+`
+
+// MigrateReplaceables moves replaceable events stored under their own id,
+// as they were by previous versions of this package, to the document of
+// their pubkey, kind and d tag, keeping only the most recent version. An
+// event with an empty d tag and one without any share the same document.
+// It returns the number of documents migrated.
+//
+// It only needs to be called once after upgrading, but is safe to call again.
+func (ess *ElasticsearchStorage) MigrateReplaceables(ctx context.Context) (int, error) {
+	es := ess.es
+	res, err := es.Search(
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(ess.IndexName),
+		es.Search.WithBody(strings.NewReader(replaceableKindsQuery)),
+		es.Search.WithScroll(time.Minute),
+		es.Search.WithSize(500),
+	)
+	var scrollID string
+	defer func() {
+		if scrollID != "" {
+			if res, err := es.ClearScroll(es.ClearScroll.WithScrollID(scrollID)); err == nil {
+				res.Body.Close()
+			}
+		}
+	}()
+
+	migrated := 0
+	for {
+		if err != nil {
+			return migrated, fmt.Errorf("elasticsearch: %w", err)
+		}
+		var page struct {
+			ScrollID string `json:"_scroll_id"`
+			Hits     struct {
+				Hits []struct {
+					ID     string `json:"_id"`
+					Source struct {
+						Event nostr.Event `json:"event"`
+					} `json:"_source"`
+				} `json:"hits"`
+			} `json:"hits"`
+		}
+		if err := decodeResponse(res, &page); err != nil {
+			return migrated, err
+		}
+		scrollID = page.ScrollID
+		if len(page.Hits.Hits) == 0 {
+			return migrated, nil
+		}
+
+		var legacy []nostr.Event
+		for _, hit := range page.Hits.Hits {
+			if hit.ID != documentID(&hit.Source.Event) {
+				legacy = append(legacy, hit.Source.Event)
+			}
+		}
+		if len(legacy) > 0 {
+			if err := ess.migrate(ctx, legacy); err != nil {
+				return migrated, err
+			}
+			migrated += len(legacy)
+		}
+
+		res, err = es.Scroll(
+			es.Scroll.WithContext(ctx),
+			es.Scroll.WithScrollID(page.ScrollID),
+			es.Scroll.WithScroll(time.Minute),
+		)
+	}
+}
+
+// migrate indexes events under their document id, unless a newer version
+// is there already, then deletes the documents stored under their own id.
+func (ess *ElasticsearchStorage) migrate(ctx context.Context, events []nostr.Event) error {
+	var index, delete bytes.Buffer
+	for i := range events {
+		evt := &events[i]
+		data, err := json.Marshal(indexedEvent(evt))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&index, `{"index": {"_id": %q, "version": %d, "version_type": "external_gte"}}`+"\n%s\n",
+			documentID(evt), evt.CreatedAt.Unix(), data)
+		fmt.Fprintf(&delete, `{"delete": {"_id": %q}}`+"\n", evt.ID)
+	}
+
+	// only delete the old documents once all of them are stored again
+	for _, body := range []*bytes.Buffer{&index, &delete} {
+		res, err := ess.es.Bulk(body,
+			ess.es.Bulk.WithContext(ctx),
+			ess.es.Bulk.WithIndex(ess.IndexName),
+			ess.es.Bulk.WithRefresh("true"),
+		)
+		if err != nil {
+			return fmt.Errorf("elasticsearch: %w", err)
+		}
+		var r struct {
+			Items []map[string]struct {
+				Status int `json:"status"`
+				Error  struct {
+					Type   string `json:"type"`
+					Reason string `json:"reason"`
+				} `json:"error"`
+			} `json:"items"`
+		}
+		if err := decodeResponse(res, &r); err != nil {
+			return err
+		}
+		for _, item := range r.Items {
+			for _, result := range item {
+				switch {
+				case result.Error.Type == "" || result.Status == 404:
+				case result.Error.Type == "version_conflict_engine_exception":
+					// a newer version is stored already
+				default:
+					return &Error{Status: result.Status, Type: result.Error.Type, Reason: result.Error.Reason}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// decodeResponse decodes the body of res into v, failing on error responses.
+func decodeResponse(res *esapi.Response, v any) error {
+	defer res.Body.Close()
+	if res.IsError() {
+		return responseError(res)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (ess *ElasticsearchStorage) deleteByQuery(q esquery.Mappable) error {
+	body, err := json.Marshal(esquery.Query(q))
+	if err != nil {
+		return err
+	}
+
+	es := ess.es
+	res, err := es.DeleteByQuery(
+		[]string{ess.IndexName},
+		bytes.NewReader(body),
+		es.DeleteByQuery.WithContext(context.Background()),
+		es.DeleteByQuery.WithConflicts("proceed"),
+		es.DeleteByQuery.WithRefresh(true),
+	)
+	if err != nil {
+		return fmt.Errorf("elasticsearch: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return responseError(res)
+	}
+	return nil
+}
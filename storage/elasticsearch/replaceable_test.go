@@ -0,0 +1,97 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMigrateReplaceables(t *testing.T) {
+	type doc struct {
+		version int64
+		event   nostr.Event
+	}
+	docs := make(map[string]doc)
+	store := func(id string, evt nostr.Event) {
+		docs[id] = doc{evt.CreatedAt.Unix(), evt}
+	}
+	profile := func(id string, at int64) nostr.Event {
+		return nostr.Event{ID: id, PubKey: "pk", Kind: 0, CreatedAt: time.Unix(at, 0)}
+	}
+	// versions stored under their own id, and the current one
+	store("old", profile("old", 1))
+	store("newest", profile("newest", 3))
+	store("pk:0", profile("current", 2))
+	// an empty d tag and no d tag are the same
+	store("empty", nostr.Event{ID: "empty", PubKey: "pk", Kind: 30023, CreatedAt: time.Unix(5, 0), Tags: nostr.Tags{{"d", ""}}})
+	store("nod", nostr.Event{ID: "nod", PubKey: "pk", Kind: 30023, CreatedAt: time.Unix(4, 0)})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/events/_search":
+			var hits []map[string]any
+			for id, d := range docs {
+				hits = append(hits, map[string]any{"_id": id, "_source": map[string]any{"event": d.event}})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"_scroll_id": "scroll", "hits": map[string]any{"hits": hits}})
+		case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/_search/scroll"):
+			w.Write([]byte(`{}`))
+		case r.URL.Path == "/_search/scroll":
+			w.Write([]byte(`{"_scroll_id": "scroll", "hits": {"hits": []}}`))
+		case r.URL.Path == "/events/_bulk":
+			var items []map[string]any
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				var action map[string]struct {
+					ID      string `json:"_id"`
+					Version int64  `json:"version"`
+				}
+				json.Unmarshal(scanner.Bytes(), &action)
+				if a, ok := action["delete"]; ok {
+					delete(docs, a.ID)
+					items = append(items, map[string]any{"delete": map[string]any{"status": 200}})
+					continue
+				}
+				a := action["index"]
+				scanner.Scan()
+				var ie IndexedEvent
+				json.Unmarshal(scanner.Bytes(), &ie)
+				if existing, ok := docs[a.ID]; ok && existing.version > a.Version {
+					items = append(items, map[string]any{"index": map[string]any{"status": 409,
+						"error": map[string]any{"type": "version_conflict_engine_exception"}}})
+					continue
+				}
+				docs[a.ID] = doc{a.Version, ie.Event}
+				items = append(items, map[string]any{"index": map[string]any{"status": 201}})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"items": items})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	es, _ := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	ess := &ElasticsearchStorage{IndexName: "events", es: es}
+	n, err := ess.MigrateReplaceables(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("migrated %d documents; want 4", n)
+	}
+	if len(docs) != 2 || docs["pk:0"].event.ID != "newest" || docs["pk:30023:"].event.ID != "empty" {
+		t.Errorf("documents after migration: %v", docs)
+	}
+}
@@ -0,0 +1,127 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Profile holds the kind-0 metadata fields indexed for search.
+type Profile struct {
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	About       string `json:"about,omitempty"`
+	NIP05       string `json:"nip05,omitempty"`
+	// Domain is the domain part of NIP05, for domain: searches.
+	// It is not verified.
+	Domain string `json:"domain,omitempty"`
+}
+
+// parseProfile returns nil if content is not valid kind-0 metadata.
+func parseProfile(content string) *Profile {
+	var p Profile
+	if err := json.Unmarshal([]byte(content), &p); err != nil {
+		return nil
+	}
+	p.Domain = ""
+	if _, domain, ok := strings.Cut(p.NIP05, "@"); ok {
+		p.Domain = strings.ToLower(domain)
+	}
+	return &p
+}
+
+// searchQuery is the NIP-50 search string of a filter, split into
+// the text to search for and the extensions supported here.
+type searchQuery struct {
+	Text string
+	// Language is an ISO-639-1 code, from "language:<code>".
+	Language string
+	// Domain is a NIP-05 domain, from "domain:<domain>".
+	Domain string
+	// Sort is either "relevance", the default, or "recent", from "sort:<order>".
+	Sort string
+}
+
+// parseSearch extracts key:value extensions from a NIP-50 search string.
+// Unsupported extensions are dropped, as NIP-50 suggests.
+func parseSearch(s string) searchQuery {
+	q := searchQuery{Sort: "relevance"}
+	var words []string
+	for _, word := range strings.Fields(s) {
+		key, value, ok := strings.Cut(word, ":")
+		if !ok || key == "" || value == "" || strings.HasPrefix(value, "//") {
+			// not an extension, possibly a url
+			words = append(words, word)
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "language":
+			q.Language = strings.ToLower(value)
+		case "domain":
+			q.Domain = strings.ToLower(value)
+		case "sort":
+			switch strings.ToLower(value) {
+			case "recent", "new", "newest":
+				q.Sort = "recent"
+			case "relevance", "relevant":
+				q.Sort = "relevance"
+			}
+		}
+	}
+	q.Text = strings.Join(words, " ")
+	return q
+}
+
+// scripts mapped to the language they are (almost) exclusively used for.
+// note that Han is checked last, as Japanese mixes it with kana.
+var scriptLanguages = []struct {
+	table    *unicode.RangeTable
+	language string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Han, "zh"},
+}
+
+// detectLanguage returns the ISO-639-1 language of an event, taken from
+// a NIP-32 "l" tag if present, or otherwise guessed from the writing system
+// of its content when that is unambiguous. It returns "" if unknown.
+func detectLanguage(evt *nostr.Event) string {
+	for _, tag := range evt.Tags {
+		if len(tag) >= 3 && tag[0] == "l" && tag[2] == "ISO-639-1" {
+			return strings.ToLower(tag[1])
+		}
+	}
+
+	counts := make(map[string]int)
+	letters := 0
+	for _, r := range evt.Content {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, sl := range scriptLanguages {
+			if unicode.Is(sl.table, r) {
+				counts[sl.language]++
+				break
+			}
+		}
+	}
+
+	// kana is a strong enough signal on its own
+	if counts["ja"] > 0 {
+		return "ja"
+	}
+	for _, sl := range scriptLanguages {
+		if counts[sl.language]*2 > letters {
+			return sl.language
+		}
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package elasticsearch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		search string
+		want   searchQuery
+	}{
+		{"steve", searchQuery{Text: "steve", Sort: "relevance"}},
+		{"bitcoin language:EN sort:new", searchQuery{Text: "bitcoin", Language: "en", Sort: "recent"}},
+		{"domain:Example.com", searchQuery{Domain: "example.com", Sort: "relevance"}},
+		{"see https://example.com include:spam", searchQuery{Text: "see https://example.com", Sort: "relevance"}},
+		{"time 12:30", searchQuery{Text: "time", Sort: "relevance"}},
+	}
+	for _, tt := range tests {
+		if got := parseSearch(tt.search); got != tt.want {
+			t.Errorf("parseSearch(%q) = %+v; want %+v", tt.search, got, tt.want)
+		}
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	p := parseProfile(`{"name":"steve","display_name":"Steve","nip05":"steve@Example.com","picture":"x"}`)
+	if p == nil || p.Name != "steve" || p.DisplayName != "Steve" || p.Domain != "example.com" {
+		t.Errorf("parseProfile = %+v", p)
+	}
+	if p := parseProfile("not json"); p != nil {
+		t.Errorf("parseProfile(invalid) = %+v; want nil", p)
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		evt  nostr.Event
+		want string
+	}{
+		{nostr.Event{Content: "hello world"}, ""},
+		{nostr.Event{Content: "hola", Tags: nostr.Tags{{"L", "ISO-639-1"}, {"l", "ES", "ISO-639-1"}}}, "es"},
+		{nostr.Event{Content: "こんにちは世界"}, "ja"},
+		{nostr.Event{Content: "你好，世界"}, "zh"},
+		{nostr.Event{Content: "안녕하세요 여러분, nostr"}, "ko"},
+		{nostr.Event{Content: "καλημέρα"}, "el"},
+	}
+	for _, tt := range tests {
+		if got := detectLanguage(&tt.evt); got != tt.want {
+			t.Errorf("detectLanguage(%q) = %q; want %q", tt.evt.Content, got, tt.want)
+		}
+	}
+}
+
+func TestQuerySearch(t *testing.T) {
+	dsl, err := buildDsl(&nostr.Filter{Search: "steve language:en domain:example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{`"multi_match"`, `"profile.name^3"`, `{"term":{"language":{"value":"en"}}}`} {
+		if !strings.Contains(string(dsl), want) {
+			t.Errorf("buildDsl = %s; want it to contain %s", dsl, want)
+		}
+	}
+	if strings.Contains(string(dsl), "domain") {
+		t.Errorf("buildDsl = %s; domain should be resolved to authors beforehand", dsl)
+	}
+}
@@ -3,3 +3,12 @@ package storage
 import "errors"
 
 var ErrDupEvent = errors.New("duplicate: event already exists")
+
+// ErrNewerVersion is returned by storages when saving a replaceable event
+// older than the one they hold, which stays. Like [ErrDupEvent] the event
+// isn't saved, nor sent to subscribers.
+var ErrNewerVersion = errors.New("duplicate: a newer version of this event exists")
+
+// ErrFilterTooLarge is wrapped by the errors of storages refusing filters
+// with more values than they handle. The relay reports these to clients.
+var ErrFilterTooLarge = errors.New("filter too large")
@@ -0,0 +1,11 @@
+package memory
+
+func (b *MemoryBackend) DeleteEvent(id string, pubkey string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if evt, ok := b.ids[id]; ok && evt.PubKey == pubkey {
+		b.remove(evt)
+	}
+	return nil
+}
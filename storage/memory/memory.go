@@ -0,0 +1,39 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// MemoryBackend keeps events in memory, following the same rules as the SQL
+// backends. It is meant for tests and for relays which don't need persistence.
+//
+// The zero value is ready to use, although Init should still be called.
+type MemoryBackend struct {
+	// MaxEvents, if positive, is the maximum number of events kept.
+	// Once reached, the oldest events by created_at are evicted to make room
+	// for new ones.
+	MaxEvents int
+
+	mu sync.RWMutex
+	// events sorted by created_at desc, then id
+	events []*nostr.Event
+	ids    map[string]*nostr.Event
+}
+
+func (b *MemoryBackend) Init() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ids == nil {
+		b.ids = make(map[string]*nostr.Event)
+	}
+	return nil
+}
+
+// Len returns the number of events currently stored.
+func (b *MemoryBackend) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.events)
+}
@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/fiatjaf/relayer/storage"
+	"github.com/fiatjaf/relayer/storage/storagetest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) relayer.Storage {
+		b := &MemoryBackend{}
+		if err := b.Init(); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
+
+func TestMemoryBackend(t *testing.T) {
+	b := &MemoryBackend{}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	meta1 := event("a", "alice", 0, 10)
+	meta2 := event("b", "alice", 0, 5)
+	note := event("c", "alice", 1, 20)
+	other := event("d", "bob", 1, 20)
+	for _, evt := range []nostr.Event{meta1, meta2, note, other} {
+		if err := b.SaveEvent(&evt); err != nil {
+			t.Fatalf("SaveEvent(%s): %v", evt.ID, err)
+		}
+	}
+	if err := b.SaveEvent(&note); err != storage.ErrDupEvent {
+		t.Errorf("SaveEvent of a duplicate: %v; want %v", err, storage.ErrDupEvent)
+	}
+
+	// replaceable events replace past ones regardless of created_at, like the SQL backends
+	got, _ := b.QueryEvents(&nostr.Filter{})
+	if ids := idsOf(got); ids != "[c d b]" {
+		t.Errorf("QueryEvents = %s; want [c d b]", ids)
+	}
+
+	// only the author can delete
+	b.DeleteEvent("d", "alice")
+	b.DeleteEvent("c", "alice")
+	got, _ = b.QueryEvents(&nostr.Filter{Kinds: []int{1}})
+	if ids := idsOf(got); ids != "[d]" {
+		t.Errorf("QueryEvents after deletion = %s; want [d]", ids)
+	}
+}
+
+func TestMemoryBackendEviction(t *testing.T) {
+	b := &MemoryBackend{MaxEvents: 50}
+	b.Init()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			evt := event(fmt.Sprintf("%03d", i), "alice", 1, int64(i))
+			b.SaveEvent(&evt)
+			b.QueryEvents(&nostr.Filter{Authors: []string{"alice"}, Limit: 10})
+		}(i)
+	}
+	wg.Wait()
+
+	if n := b.Len(); n != 50 {
+		t.Errorf("Len() = %d; want 50", n)
+	}
+	got, _ := b.QueryEvents(&nostr.Filter{Limit: 1000})
+	if len(got) != 50 || got[0].ID != "199" || got[49].ID != "150" {
+		t.Errorf("QueryEvents returned %d events, from %s to %s; want 50 from 199 to 150",
+			len(got), got[0].ID, got[len(got)-1].ID)
+	}
+}
+
+func event(id, pubkey string, kind int, createdAt int64) nostr.Event {
+	return nostr.Event{
+		ID:        id,
+		PubKey:    pubkey,
+		Kind:      kind,
+		CreatedAt: time.Unix(createdAt, 0),
+		Tags:      nostr.Tags{},
+	}
+}
+
+func idsOf(events []nostr.Event) string {
+	ids := make([]string, len(events))
+	for i, evt := range events {
+		ids[i] = evt.ID
+	}
+	return fmt.Sprint(ids)
+}
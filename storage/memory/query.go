@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func (b *MemoryBackend) QueryEvents(filter *nostr.Filter) (events []nostr.Event, err error) {
+	if filter == nil {
+		err = errors.New("filter cannot be null")
+		return
+	}
+
+	limit := filter.Limit
+	if limit < 1 || limit > 100 {
+		limit = 100
+	}
+	search := strings.ToLower(filter.Search)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, evt := range b.events {
+		// since and until are exclusive, as in the SQL backends
+		if filter.Until != nil && !evt.CreatedAt.Before(*filter.Until) {
+			continue
+		}
+		if filter.Since != nil && !evt.CreatedAt.After(*filter.Since) {
+			// events are sorted, so there is nothing else to find
+			break
+		}
+		if !filter.Matches(evt) {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(evt.Content), search) {
+			continue
+		}
+
+		events = append(events, *evt)
+		if len(events) == limit {
+			break
+		}
+	}
+
+	return events, nil
+}
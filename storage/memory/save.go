@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"sort"
+
+	"github.com/fiatjaf/relayer/storage"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func (b *MemoryBackend) SaveEvent(evt *nostr.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ids == nil {
+		b.ids = make(map[string]*nostr.Event)
+	}
+	if _, ok := b.ids[evt.ID]; ok {
+		return storage.ErrDupEvent
+	}
+
+	// react to different kinds of events
+	if evt.Kind == nostr.KindSetMetadata || evt.Kind == nostr.KindContactList || (10000 <= evt.Kind && evt.Kind < 20000) {
+		// delete past events from this user
+		b.removeWhere(func(old *nostr.Event) bool {
+			return old.PubKey == evt.PubKey && old.Kind == evt.Kind
+		})
+	} else if evt.Kind == nostr.KindRecommendServer {
+		// delete past recommend_server events equal to this one
+		b.removeWhere(func(old *nostr.Event) bool {
+			return old.PubKey == evt.PubKey && old.Kind == evt.Kind && old.Content == evt.Content
+		})
+	}
+
+	stored := *evt
+	stored.Tags = make(nostr.Tags, len(evt.Tags))
+	for i, tag := range evt.Tags {
+		stored.Tags[i] = append(nostr.Tag(nil), tag...)
+	}
+	i := sort.Search(len(b.events), func(i int) bool { return before(&stored, b.events[i]) })
+	b.events = append(b.events, nil)
+	copy(b.events[i+1:], b.events[i:])
+	b.events[i] = &stored
+	b.ids[stored.ID] = &stored
+
+	// evict the oldest ones
+	if b.MaxEvents > 0 {
+		for len(b.events) > b.MaxEvents {
+			oldest := b.events[len(b.events)-1]
+			b.events = b.events[:len(b.events)-1]
+			delete(b.ids, oldest.ID)
+		}
+	}
+
+	return nil
+}
+
+// before reports whether a sorts before b, that is, most recent first.
+func before(a, b *nostr.Event) bool {
+	if a.CreatedAt.Equal(b.CreatedAt) {
+		return a.ID < b.ID
+	}
+	return a.CreatedAt.After(b.CreatedAt)
+}
+
+// remove deletes evt from the backend. b.mu must be held.
+func (b *MemoryBackend) remove(evt *nostr.Event) {
+	i := sort.Search(len(b.events), func(i int) bool { return !before(b.events[i], evt) })
+	if i < len(b.events) && b.events[i] == evt {
+		b.events = append(b.events[:i], b.events[i+1:]...)
+	}
+	delete(b.ids, evt.ID)
+}
+
+// removeWhere deletes all events for which fn returns true. b.mu must be held.
+func (b *MemoryBackend) removeWhere(fn func(*nostr.Event) bool) {
+	kept := b.events[:0]
+	for _, evt := range b.events {
+		if fn(evt) {
+			delete(b.ids, evt.ID)
+		} else {
+			kept = append(kept, evt)
+		}
+	}
+	for i := len(kept); i < len(b.events); i++ {
+		b.events[i] = nil
+	}
+	b.events = kept
+}
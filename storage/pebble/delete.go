@@ -0,0 +1,76 @@
+package pebble
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func (b *PebbleBackend) DeleteEvent(id string, pubkey string) error {
+	rawid, err := decodeHex32(id)
+	if err != nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	evt, err := b.getEvent(rawid)
+	if err != nil {
+		return err
+	}
+	if evt == nil || evt.PubKey != pubkey {
+		// nothing to delete
+		return nil
+	}
+
+	batch := b.DB.NewBatch()
+	defer batch.Close()
+	if err := b.deleteEvent(batch, evt); err != nil {
+		return err
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+func (b *PebbleBackend) BeforeDelete(id string, pubkey string) {
+	// do nothing
+}
+
+func (b *PebbleBackend) AfterDelete(id string, pubkey string) {
+	// do nothing
+}
+
+// deleteEvent adds the removal of evt and all its index entries to batch.
+func (b *PebbleBackend) deleteEvent(batch *pebble.Batch, evt *nostr.Event) error {
+	id, err := decodeHex32(evt.ID)
+	if err != nil {
+		return err
+	}
+	pubkey, err := decodeHex32(evt.PubKey)
+	if err != nil {
+		return err
+	}
+
+	batch.Delete(eventKey(id), nil)
+	for _, k := range indexKeys(evt, id, pubkey) {
+		batch.Delete(k, nil)
+	}
+
+	if isReplaceable(evt.Kind) || isParameterizedReplaceable(evt.Kind) {
+		rkey := replaceableKey(pubkey, evt.Kind, dTag(evt))
+		val, closer, err := b.DB.Get(rkey)
+		if err == nil {
+			current := bytes.Equal(val, id)
+			closer.Close()
+			if current {
+				batch.Delete(rkey, nil)
+			}
+		} else if !errors.Is(err, pebble.ErrNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package pebble
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var errShortBuffer = errors.New("encoded event is truncated")
+
+// encodeEvent serializes evt into a compact binary form:
+//
+//	id [32] | pubkey [32] | sig [64] | created_at [4] | kind [4] |
+//	content length + content | number of tags |
+//	for each tag: number of items | for each item: length + item
+//
+// where lengths and counts are uvarints.
+func encodeEvent(evt *nostr.Event) ([]byte, error) {
+	id, err := decodeHex32(evt.ID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id: %w", err)
+	}
+	pubkey, err := decodeHex32(evt.PubKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pubkey: %w", err)
+	}
+	sig, err := hex.DecodeString(evt.Sig)
+	if err != nil || len(sig) != 64 {
+		return nil, fmt.Errorf("invalid sig %q", evt.Sig)
+	}
+
+	buf := make([]byte, 0, 32+32+64+4+4+len(evt.Content)+16)
+	buf = append(buf, id...)
+	buf = append(buf, pubkey...)
+	buf = append(buf, sig...)
+	buf = appendUint32(buf, timestamp(evt.CreatedAt.Unix()))
+	buf = appendUint32(buf, uint32(evt.Kind))
+	buf = appendString(buf, evt.Content)
+	buf = appendUvarint(buf, uint64(len(evt.Tags)))
+	for _, tag := range evt.Tags {
+		buf = appendUvarint(buf, uint64(len(tag)))
+		for _, item := range tag {
+			buf = appendString(buf, item)
+		}
+	}
+	return buf, nil
+}
+
+// decodeEvent is the inverse of encodeEvent.
+func decodeEvent(buf []byte) (nostr.Event, error) {
+	var evt nostr.Event
+	if len(buf) < 32+32+64+4+4 {
+		return evt, errShortBuffer
+	}
+
+	evt.ID = hex.EncodeToString(buf[0:32])
+	evt.PubKey = hex.EncodeToString(buf[32:64])
+	evt.Sig = hex.EncodeToString(buf[64:128])
+	evt.CreatedAt = time.Unix(int64(binary.BigEndian.Uint32(buf[128:132])), 0)
+	evt.Kind = int(binary.BigEndian.Uint32(buf[132:136]))
+	buf = buf[136:]
+
+	var err error
+	if evt.Content, buf, err = readString(buf); err != nil {
+		return evt, err
+	}
+
+	ntags, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return evt, errShortBuffer
+	}
+	buf = buf[n:]
+	evt.Tags = make(nostr.Tags, 0, ntags)
+	for i := uint64(0); i < ntags; i++ {
+		nitems, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return evt, errShortBuffer
+		}
+		buf = buf[n:]
+		tag := make(nostr.Tag, nitems)
+		for j := range tag {
+			if tag[j], buf, err = readString(buf); err != nil {
+				return evt, err
+			}
+		}
+		evt.Tags = append(evt.Tags, tag)
+	}
+
+	return evt, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func readString(buf []byte) (string, []byte, error) {
+	l, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < l {
+		return "", nil, errShortBuffer
+	}
+	return string(buf[n : n+int(l)]), buf[n+int(l):], nil
+}
+
+func decodeHex32(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 32 {
+		return nil, fmt.Errorf("%q is not 32 bytes long", s)
+	}
+	return b, nil
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+// timestamp converts unix seconds to the uint32 stored, clamping those
+// out of range rather than wrapping them around.
+func timestamp(unix int64) uint32 {
+	switch {
+	case unix < 0:
+		return 0
+	case unix > 1<<32-1:
+		return 1<<32 - 1
+	default:
+		return uint32(unix)
+	}
+}
@@ -0,0 +1,14 @@
+package pebble
+
+import (
+	"github.com/cockroachdb/pebble"
+)
+
+func (b *PebbleBackend) Init() error {
+	db, err := pebble.Open(b.Path, nil)
+	if err != nil {
+		return err
+	}
+	b.DB = db
+	return nil
+}
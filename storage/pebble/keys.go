@@ -0,0 +1,112 @@
+package pebble
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// key prefixes. every index key ends with created_at [4] | id [32], so that
+// iterating an index backwards yields events in reverse chronological order.
+const (
+	prefixEvent       byte = 'e' // id -> encoded event
+	prefixCreatedAt   byte = 'c' // created_at | id
+	prefixPubkey      byte = 'p' // pubkey [32] | created_at | id
+	prefixKind        byte = 'k' // kind [4] | created_at | id
+	prefixTag         byte = 't' // tag name [1] | value hash [8] | created_at | id
+	prefixReplaceable byte = 'r' // pubkey [32] | kind [4] | d tag hash [8] -> id
+)
+
+func eventKey(id []byte) []byte {
+	return append([]byte{prefixEvent}, id...)
+}
+
+func pubkeyPrefix(pubkey []byte) []byte {
+	return append([]byte{prefixPubkey}, pubkey...)
+}
+
+func kindPrefix(kind int) []byte {
+	return appendUint32([]byte{prefixKind}, uint32(kind))
+}
+
+func tagPrefix(name string, value string) []byte {
+	h := sha256.Sum256([]byte(value))
+	return append([]byte{prefixTag, name[0]}, h[0:8]...)
+}
+
+func replaceableKey(pubkey []byte, kind int, d string) []byte {
+	k := appendUint32(append([]byte{prefixReplaceable}, pubkey...), uint32(kind))
+	if isParameterizedReplaceable(kind) {
+		h := sha256.Sum256([]byte(d))
+		k = append(k, h[0:8]...)
+	}
+	return k
+}
+
+// indexKey appends created_at and id to an index prefix.
+func indexKey(prefix []byte, createdAt uint32, id []byte) []byte {
+	k := make([]byte, 0, len(prefix)+4+32)
+	k = append(k, prefix...)
+	k = appendUint32(k, createdAt)
+	return append(k, id...)
+}
+
+// parseIndexKey extracts created_at and id from the tail of an index key.
+func parseIndexKey(key []byte) (createdAt uint32, id []byte) {
+	tail := key[len(key)-36:]
+	return binary.BigEndian.Uint32(tail[0:4]), tail[4:36]
+}
+
+// indexKeys returns all index keys for evt, except the replaceable one.
+func indexKeys(evt *nostr.Event, id, pubkey []byte) [][]byte {
+	ts := timestamp(evt.CreatedAt.Unix())
+	keys := [][]byte{
+		indexKey([]byte{prefixCreatedAt}, ts, id),
+		indexKey(pubkeyPrefix(pubkey), ts, id),
+		indexKey(kindPrefix(evt.Kind), ts, id),
+	}
+
+	// index single-letter tags only, as per NIP-12
+	seen := make(map[string]struct{})
+	for _, tag := range evt.Tags {
+		if len(tag) < 2 || len(tag[0]) != 1 {
+			continue
+		}
+		k := indexKey(tagPrefix(tag[0], tag[1]), ts, id)
+		if _, ok := seen[string(k)]; ok {
+			continue
+		}
+		seen[string(k)] = struct{}{}
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// prefixUpperBound returns the smallest key greater than all keys starting with prefix.
+func prefixUpperBound(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil // no upper bound
+}
+
+func isReplaceable(kind int) bool {
+	return kind == nostr.KindSetMetadata || kind == nostr.KindContactList || (10000 <= kind && kind < 20000)
+}
+
+func isParameterizedReplaceable(kind int) bool {
+	return 30000 <= kind && kind < 40000
+}
+
+func dTag(evt *nostr.Event) string {
+	if tag := evt.Tags.GetFirst([]string{"d", ""}); tag != nil {
+		return tag.Value()
+	}
+	return ""
+}
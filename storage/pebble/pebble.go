@@ -0,0 +1,22 @@
+package pebble
+
+import (
+	"sync"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// PebbleBackend is an embedded storage keeping events in a pebble key-value
+// database at Path, so that a relay can run as a single binary without cgo.
+type PebbleBackend struct {
+	*pebble.DB
+	Path string
+
+	// MaxEventsPerKind, if positive, is the number of most recent events kept
+	// for each pubkey and kind after a save. Older ones are pruned.
+	MaxEventsPerKind int
+
+	// serializes writes, so that duplicates and replaceable events are
+	// checked and stored atomically
+	mu sync.Mutex
+}
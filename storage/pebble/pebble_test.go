@@ -0,0 +1,177 @@
+package pebble
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/fiatjaf/relayer/storage"
+	"github.com/fiatjaf/relayer/storage/storagetest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) relayer.Storage {
+		b := &PebbleBackend{Path: t.TempDir()}
+		if err := b.Init(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { b.Close() })
+		return b
+	})
+}
+
+func TestEncodeDecode(t *testing.T) {
+	evt := signedEvent(t, nostr.GeneratePrivateKey(), 1, 1670000000, nostr.Tags{
+		{"e", "abc", "wss://relay"},
+		{"t", ""},
+		{},
+	}, "hello\x00world")
+
+	data, err := encodeEvent(&evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeEvent(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, evt) {
+		t.Errorf("decodeEvent = %+v; want %+v", got, evt)
+	}
+
+	if _, err := decodeEvent(data[:len(data)-1]); err == nil {
+		t.Error("decodeEvent of truncated data succeeded")
+	}
+}
+
+func TestPebbleBackend(t *testing.T) {
+	b := &PebbleBackend{Path: t.TempDir()}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	sk1, sk2 := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	note1 := signedEvent(t, sk1, 1, 100, nostr.Tags{{"t", "nostr"}}, "one")
+	note2 := signedEvent(t, sk2, 1, 200, nostr.Tags{{"t", "nostr"}, {"p", "abc"}}, "two")
+	note3 := signedEvent(t, sk1, 7, 300, nostr.Tags{{"e", note2.ID}}, "+")
+	meta1 := signedEvent(t, sk1, 0, 400, nostr.Tags{}, `{"name":"old"}`)
+	meta2 := signedEvent(t, sk1, 0, 500, nostr.Tags{}, `{"name":"new"}`)
+	meta0 := signedEvent(t, sk1, 0, 50, nostr.Tags{}, `{"name":"older"}`)
+	for _, evt := range []nostr.Event{note1, note2, note3, meta1, meta2} {
+		if err := b.SaveEvent(&evt); err != nil {
+			t.Fatalf("SaveEvent(%s): %v", evt.Content, err)
+		}
+	}
+	if err := b.SaveEvent(&meta0); err != storage.ErrNewerVersion {
+		t.Errorf("SaveEvent of an older replaceable event: %v; want %v", err, storage.ErrNewerVersion)
+	}
+	if err := b.SaveEvent(&note1); err != storage.ErrDupEvent {
+		t.Errorf("SaveEvent of a duplicate: %v; want %v", err, storage.ErrDupEvent)
+	}
+
+	since, until := time.Unix(100, 0), time.Unix(300, 0)
+	farUntil, negativeSince := time.Unix(9999999999, 0), time.Unix(-5, 0)
+	tests := []struct {
+		name   string
+		filter nostr.Filter
+		want   []nostr.Event
+	}{
+		{"all", nostr.Filter{}, []nostr.Event{meta2, note3, note2, note1}},
+		{"limit", nostr.Filter{Limit: 2}, []nostr.Event{meta2, note3}},
+		{"ids", nostr.Filter{IDs: []string{note1.ID, note2.ID[:7]}}, []nostr.Event{note2, note1}},
+		{"author", nostr.Filter{Authors: []string{note1.PubKey}}, []nostr.Event{meta2, note3, note1}},
+		{"author prefix", nostr.Filter{Authors: []string{note2.PubKey[:5]}}, []nostr.Event{note2}},
+		{"kinds", nostr.Filter{Kinds: []int{0, 7}}, []nostr.Event{meta2, note3}},
+		{"author and kind", nostr.Filter{Authors: []string{note1.PubKey}, Kinds: []int{1}}, []nostr.Event{note1}},
+		{"tag", nostr.Filter{Tags: nostr.TagMap{"t": {"nostr"}}}, []nostr.Event{note2, note1}},
+		{"tags", nostr.Filter{Tags: nostr.TagMap{"t": {"nostr"}, "p": {"abc"}}}, []nostr.Event{note2}},
+		{"since until", nostr.Filter{Since: &since, Until: &until}, []nostr.Event{note2}},
+		{"far until", nostr.Filter{Until: &farUntil}, []nostr.Event{meta2, note3, note2, note1}},
+		{"negative since", nostr.Filter{Since: &negativeSince}, []nostr.Event{meta2, note3, note2, note1}},
+		{"search", nostr.Filter{Search: "TW"}, []nostr.Event{note2}},
+		{"empty kinds", nostr.Filter{Kinds: []int{}}, nil},
+	}
+	for _, tt := range tests {
+		got, err := b.QueryEvents(&tt.filter)
+		if err != nil {
+			t.Errorf("%s: QueryEvents: %v", tt.name, err)
+			continue
+		}
+		if !sameEvents(got, tt.want) {
+			t.Errorf("%s: QueryEvents = %v; want %v", tt.name, contents(got), contents(tt.want))
+		}
+	}
+
+	// only the author can delete
+	if err := b.DeleteEvent(note2.ID, note1.PubKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteEvent(note1.ID, note1.PubKey); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := b.QueryEvents(&nostr.Filter{Kinds: []int{1}})
+	if !sameEvents(got, []nostr.Event{note2}) {
+		t.Errorf("QueryEvents after deletion = %v; want [two]", contents(got))
+	}
+}
+
+func TestPebbleBackendPrune(t *testing.T) {
+	b := &PebbleBackend{Path: t.TempDir(), MaxEventsPerKind: 2}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	sk := nostr.GeneratePrivateKey()
+	var notes []nostr.Event
+	for i := 0; i < 4; i++ {
+		evt := signedEvent(t, sk, 1, int64(100+i), nostr.Tags{}, "note")
+		b.SaveEvent(&evt)
+		b.AfterSave(&evt)
+		notes = append(notes, evt)
+	}
+
+	got, _ := b.QueryEvents(&nostr.Filter{})
+	if !sameEvents(got, []nostr.Event{notes[3], notes[2]}) {
+		t.Errorf("QueryEvents after pruning returned %d events; want the 2 most recent", len(got))
+	}
+}
+
+func signedEvent(t *testing.T, sk string, kind int, createdAt int64, tags nostr.Tags, content string) nostr.Event {
+	t.Helper()
+	pk, _ := nostr.GetPublicKey(sk)
+	evt := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: time.Unix(createdAt, 0),
+		Kind:      kind,
+		Tags:      tags,
+		Content:   content,
+	}
+	if err := evt.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	return evt
+}
+
+func sameEvents(a, b []nostr.Event) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return false
+		}
+	}
+	return true
+}
+
+func contents(events []nostr.Event) []string {
+	res := make([]string, len(events))
+	for i, evt := range events {
+		res[i] = evt.Content
+	}
+	return res
+}
@@ -0,0 +1,305 @@
+package pebble
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func (b *PebbleBackend) QueryEvents(filter *nostr.Filter) (events []nostr.Event, err error) {
+	if filter == nil {
+		err = errors.New("filter cannot be null")
+		return
+	}
+
+	limit := filter.Limit
+	if limit < 1 || limit > 100 {
+		limit = 100
+	}
+
+	if filter.Kinds != nil && len(filter.Kinds) == 0 {
+		// kinds being [] mean you won't get anything
+		return
+	}
+	for _, values := range filter.Tags {
+		if len(values) == 0 {
+			// any tag set to [] is wrong
+			return
+		}
+	}
+
+	// since and until are exclusive, as in the SQL backends
+	var since, until uint32 = 0, 1<<32 - 1
+	if filter.Since != nil {
+		since = timestamp(filter.Since.Unix() + 1)
+	}
+	if filter.Until != nil {
+		until = timestamp(filter.Until.Unix())
+	}
+	if since >= until {
+		return
+	}
+
+	// pick the most selective index available and merge its entries,
+	// most recent first, checking the whole filter against each event
+	var sources []source
+	switch {
+	case filter.IDs != nil:
+		sources, err = b.idSources(filter.IDs)
+	case filter.Authors != nil:
+		for _, author := range filter.Authors {
+			var src source
+			if src, err = b.pubkeySource(author, since, until); err != nil {
+				break
+			}
+			sources = append(sources, src)
+		}
+	case len(filter.Tags) > 0:
+		// any tag will do, as events must match all of them anyway
+		names := make([]string, 0, len(filter.Tags))
+		for name := range filter.Tags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		name := names[0]
+		if len(name) != 1 {
+			// only single-letter tags are indexed
+			return
+		}
+		for _, value := range filter.Tags[name] {
+			sources = append(sources, b.rangeSource(tagPrefix(name, value), since, until))
+		}
+	case filter.Kinds != nil:
+		for _, kind := range filter.Kinds {
+			sources = append(sources, b.rangeSource(kindPrefix(kind), since, until))
+		}
+	default:
+		sources = append(sources, b.rangeSource([]byte{prefixCreatedAt}, since, until))
+	}
+	defer func() {
+		for _, src := range sources {
+			src.close()
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(mergeHeap, 0, len(sources))
+	for _, src := range sources {
+		if src.next() {
+			merged = append(merged, src)
+		}
+	}
+	heap.Init(&merged)
+
+	search := strings.ToLower(filter.Search)
+	seen := make(map[string]struct{})
+	for len(merged) > 0 && len(events) < limit {
+		src := merged[0]
+		ts, id := src.current()
+		if ts < since || ts >= until {
+			// only id sources aren't bounded by time already
+		} else if _, ok := seen[string(id)]; !ok {
+			seen[string(id)] = struct{}{}
+
+			evt, err := b.getEvent(id)
+			if err != nil {
+				return nil, err
+			}
+			if evt != nil && filter.Matches(evt) &&
+				(search == "" || strings.Contains(strings.ToLower(evt.Content), search)) {
+				events = append(events, *evt)
+			}
+		}
+
+		if src.next() {
+			heap.Fix(&merged, 0)
+		} else {
+			heap.Pop(&merged)
+		}
+	}
+
+	return events, nil
+}
+
+func (b *PebbleBackend) BeforeQuery(filter *nostr.Filter) {
+	// do nothing
+}
+
+func (b *PebbleBackend) AfterQuery(events []nostr.Event, filter *nostr.Filter) {
+	// do nothing
+}
+
+// idSources looks up events by full ids or id prefixes.
+func (b *PebbleBackend) idSources(ids []string) ([]source, error) {
+	var entries []entry
+	for _, id := range ids {
+		if len(id) == 64 {
+			rawid, err := hex.DecodeString(id)
+			if err != nil {
+				continue
+			}
+			evt, err := b.getEvent(rawid)
+			if err != nil {
+				return nil, err
+			}
+			if evt != nil {
+				entries = append(entries, entry{timestamp(evt.CreatedAt.Unix()), rawid})
+			}
+			continue
+		}
+
+		err := b.scanPrefix(prefixEvent, id, func(key []byte, value []byte) {
+			evt, err := decodeEvent(value)
+			if err == nil {
+				entries = append(entries, entry{timestamp(evt.CreatedAt.Unix()), key[1:33]})
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return []source{newSliceSource(entries)}, nil
+}
+
+// pubkeySource returns events from a full pubkey or a pubkey prefix.
+func (b *PebbleBackend) pubkeySource(author string, since, until uint32) (source, error) {
+	if len(author) == 64 {
+		pubkey, err := hex.DecodeString(author)
+		if err != nil {
+			return newSliceSource(nil), nil
+		}
+		return b.rangeSource(pubkeyPrefix(pubkey), since, until), nil
+	}
+
+	// entries for different pubkeys are interleaved, so they have to be sorted
+	var entries []entry
+	err := b.scanPrefix(prefixPubkey, author, func(key []byte, _ []byte) {
+		ts, id := parseIndexKey(key)
+		if since <= ts && ts < until {
+			entries = append(entries, entry{ts, id})
+		}
+	})
+	return newSliceSource(entries), err
+}
+
+// scanPrefix calls fn for every key with the given prefix byte followed by
+// 32 bytes whose hex representation starts with hexPrefix.
+// the key and value passed to fn are only valid during the call.
+func (b *PebbleBackend) scanPrefix(prefix byte, hexPrefix string, fn func(key, value []byte)) error {
+	// decode as many full bytes as possible, and check the last nibble by hand
+	even := hexPrefix[:len(hexPrefix)-len(hexPrefix)%2]
+	raw, err := hex.DecodeString(even)
+	if err != nil || len(raw) > 32 {
+		return nil
+	}
+
+	lower := append([]byte{prefix}, raw...)
+	iter := b.DB.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: prefixUpperBound(lower)})
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		if len(key) < 33 {
+			continue
+		}
+		if len(even) != len(hexPrefix) && !strings.HasPrefix(hex.EncodeToString(key[1:33]), hexPrefix) {
+			continue
+		}
+		fn(append([]byte(nil), key...), iter.Value())
+	}
+
+	return iter.Error()
+}
+
+// rangeSource iterates over index entries under prefix created between since
+// (inclusive) and until (exclusive), most recent first.
+func (b *PebbleBackend) rangeSource(prefix []byte, since, until uint32) source {
+	lower := appendUint32(append([]byte(nil), prefix...), since)
+	upper := appendUint32(append([]byte(nil), prefix...), until)
+	return &iterSource{
+		iter: b.DB.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: upper}),
+	}
+}
+
+type entry struct {
+	createdAt uint32
+	id        []byte
+}
+
+// source yields index entries in reverse chronological order.
+type source interface {
+	next() bool
+	current() (createdAt uint32, id []byte)
+	close()
+}
+
+type iterSource struct {
+	iter    *pebble.Iterator
+	started bool
+	entry   entry
+}
+
+func (s *iterSource) next() bool {
+	var valid bool
+	if !s.started {
+		s.started = true
+		valid = s.iter.Last()
+	} else {
+		valid = s.iter.Prev()
+	}
+	if !valid {
+		return false
+	}
+	s.entry.createdAt, s.entry.id = parseIndexKey(s.iter.Key())
+	return true
+}
+
+func (s *iterSource) current() (uint32, []byte) { return s.entry.createdAt, s.entry.id }
+func (s *iterSource) close()                    { s.iter.Close() }
+
+type sliceSource struct {
+	entries []entry
+	pos     int
+}
+
+func newSliceSource(entries []entry) *sliceSource {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].createdAt > entries[j].createdAt })
+	return &sliceSource{entries: entries, pos: -1}
+}
+
+func (s *sliceSource) next() bool {
+	s.pos++
+	return s.pos < len(s.entries)
+}
+
+func (s *sliceSource) current() (uint32, []byte) {
+	return s.entries[s.pos].createdAt, s.entries[s.pos].id
+}
+
+func (s *sliceSource) close() {}
+
+// mergeHeap keeps the source with the most recent current entry on top.
+type mergeHeap []source
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	ti, _ := h[i].current()
+	tj, _ := h[j].current()
+	return ti > tj
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)   { *h = append(*h, x.(source)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
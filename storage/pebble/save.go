@@ -0,0 +1,125 @@
+package pebble
+
+import (
+	"errors"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/fiatjaf/relayer/storage"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func (b *PebbleBackend) SaveEvent(evt *nostr.Event) error {
+	data, err := encodeEvent(evt)
+	if err != nil {
+		return err
+	}
+	id, pubkey := data[0:32], data[32:64]
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, closer, err := b.DB.Get(eventKey(id)); err == nil {
+		closer.Close()
+		return storage.ErrDupEvent
+	} else if !errors.Is(err, pebble.ErrNotFound) {
+		return err
+	}
+
+	batch := b.DB.NewBatch()
+	defer batch.Close()
+
+	// react to different kinds of events
+	if isReplaceable(evt.Kind) || isParameterizedReplaceable(evt.Kind) {
+		rkey := replaceableKey(pubkey, evt.Kind, dTag(evt))
+		if previous, err := b.getReplaceable(rkey); err != nil {
+			return err
+		} else if previous != nil {
+			if previous.CreatedAt.After(evt.CreatedAt) {
+				return storage.ErrNewerVersion
+			}
+			if err := b.deleteEvent(batch, previous); err != nil {
+				return err
+			}
+		}
+		batch.Set(rkey, id, nil)
+	}
+
+	batch.Set(eventKey(id), data, nil)
+	for _, k := range indexKeys(evt, id, pubkey) {
+		batch.Set(k, nil, nil)
+	}
+
+	return batch.Commit(pebble.Sync)
+}
+
+func (b *PebbleBackend) BeforeSave(evt *nostr.Event) {
+	// do nothing
+}
+
+func (b *PebbleBackend) AfterSave(evt *nostr.Event) {
+	if b.MaxEventsPerKind <= 0 {
+		return
+	}
+
+	// delete all but the most recent ones for this pubkey and kind
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pubkey, err := decodeHex32(evt.PubKey)
+	if err != nil {
+		return
+	}
+	prefix := pubkeyPrefix(pubkey)
+	iter := b.DB.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: prefixUpperBound(prefix)})
+	defer iter.Close()
+
+	batch := b.DB.NewBatch()
+	defer batch.Close()
+
+	kept := 0
+	for iter.Last(); iter.Valid(); iter.Prev() {
+		_, id := parseIndexKey(iter.Key())
+		old, err := b.getEvent(id)
+		if err != nil || old == nil || old.Kind != evt.Kind {
+			continue
+		}
+		if kept < b.MaxEventsPerKind {
+			kept++
+			continue
+		}
+		b.deleteEvent(batch, old)
+	}
+
+	batch.Commit(pebble.Sync)
+}
+
+// getEvent returns nil if the event doesn't exist.
+func (b *PebbleBackend) getEvent(id []byte) (*nostr.Event, error) {
+	val, closer, err := b.DB.Get(eventKey(id))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	evt, err := decodeEvent(val)
+	if err != nil {
+		return nil, err
+	}
+	return &evt, nil
+}
+
+// getReplaceable returns the event currently stored under rkey, if any.
+func (b *PebbleBackend) getReplaceable(rkey []byte) (*nostr.Event, error) {
+	val, closer, err := b.DB.Get(rkey)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	id := append([]byte(nil), val...)
+	closer.Close()
+
+	return b.getEvent(id)
+}
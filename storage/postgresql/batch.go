@@ -0,0 +1,264 @@
+package postgresql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/relayer/storage"
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const maxBatchSize = 1000
+
+var errClosed = errors.New("postgres backend closed")
+
+type saveRequest struct {
+	evt    *nostr.Event
+	result chan error
+}
+
+// batcher hands saved events over to writeBatches, until it is closed.
+type batcher struct {
+	mu      sync.RWMutex
+	closed  bool
+	pending chan saveRequest
+	done    chan struct{}
+}
+
+// close waits for the events queued so far to be written.
+func (bt *batcher) close() {
+	bt.mu.Lock()
+	if !bt.closed {
+		bt.closed = true
+		close(bt.pending)
+	}
+	bt.mu.Unlock()
+	<-bt.done
+}
+
+// startBatching starts the goroutine writing batches of saved events.
+func (b *PostgresBackend) startBatching() {
+	if b.BatchSize > maxBatchSize {
+		b.BatchSize = maxBatchSize
+	}
+	if b.BatchDelay <= 0 {
+		b.BatchDelay = 10 * time.Millisecond
+	}
+	b.batches = &batcher{
+		pending: make(chan saveRequest, b.BatchSize),
+		done:    make(chan struct{}),
+	}
+	go b.writeBatches()
+}
+
+// queueEvent waits for evt to be written along with other events, or for
+// ctx to be done.
+func (b *PostgresBackend) queueEvent(ctx context.Context, evt *nostr.Event) error {
+	req := saveRequest{evt: evt, result: make(chan error, 1)}
+	b.batches.mu.RLock()
+	if b.batches.closed {
+		b.batches.mu.RUnlock()
+		return errClosed
+	}
+	select {
+	case b.batches.pending <- req:
+	case <-ctx.Done():
+		b.batches.mu.RUnlock()
+		return ctx.Err()
+	}
+	b.batches.mu.RUnlock()
+
+	select {
+	case err := <-req.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *PostgresBackend) writeBatches() {
+	defer close(b.batches.done)
+	for first := range b.batches.pending {
+		batch := []saveRequest{first}
+		timer := time.NewTimer(b.BatchDelay)
+	collect:
+		for len(batch) < b.BatchSize {
+			select {
+			case req, ok := <-b.batches.pending:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, req)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		results, err := b.writeBatch(batch)
+		if err != nil {
+			// find out which events failed by saving them one by one
+			for i, req := range batch {
+				results[i] = b.saveEvent(context.Background(), req.evt)
+				if results[i] == nil {
+					b.DB.Exec(pruneQuery, req.evt.PubKey, req.evt.Kind)
+				}
+			}
+		}
+		for i, req := range batch {
+			req.result <- results[i]
+		}
+	}
+}
+
+// planBatch finds out which events of a batch to insert so that the outcome
+// is the same as saving them one after the other: replaceable events delete
+// the previous ones, including those earlier in the batch, which are then
+// not inserted at all. Events found twice get storage.ErrDupEvent.
+func planBatch(events []*nostr.Event) (results []error, inserted []int) {
+	results = make([]error, len(events))
+	latest := make(map[string]int)
+	seen := make(map[string]bool)
+	for i, evt := range events {
+		if seen[evt.ID] {
+			results[i] = storage.ErrDupEvent
+			continue
+		}
+		seen[evt.ID] = true
+		if key := replacementKey(evt); key != "" {
+			latest[key] = i
+		}
+	}
+
+	for i, evt := range events {
+		if results[i] != nil {
+			continue
+		}
+		if key := replacementKey(evt); key != "" && latest[key] != i {
+			// deleted right away by a later event in the batch
+			continue
+		}
+		inserted = append(inserted, i)
+	}
+	return results, inserted
+}
+
+// writeBatch saves events in a single transaction, as planned by planBatch,
+// returning the result of each save. Nothing is saved if it fails.
+func (b *PostgresBackend) writeBatch(batch []saveRequest) ([]error, error) {
+	events := make([]*nostr.Event, len(batch))
+	for i, req := range batch {
+		events[i] = req.evt
+	}
+	results, inserted := planBatch(events)
+
+	// past events replaced by the ones in the batch
+	var replacedPubkeys, recommendPubkeys, recommendContents []string
+	var replacedKinds, recommendKinds []int64
+	for _, i := range inserted {
+		evt := events[i]
+		if replacementKey(evt) == "" {
+			continue
+		}
+		if evt.Kind == nostr.KindRecommendServer {
+			recommendPubkeys = append(recommendPubkeys, evt.PubKey)
+			recommendKinds = append(recommendKinds, int64(evt.Kind))
+			recommendContents = append(recommendContents, evt.Content)
+		} else {
+			replacedPubkeys = append(replacedPubkeys, evt.PubKey)
+			replacedKinds = append(replacedKinds, int64(evt.Kind))
+		}
+	}
+
+	tx, err := b.DB.Beginx()
+	if err != nil {
+		return results, err
+	}
+	defer tx.Rollback()
+
+	// delete past events replaced by the ones in the batch
+	if len(replacedPubkeys) > 0 {
+		_, err := tx.Exec(`DELETE FROM event WHERE (pubkey, kind) IN (
+          SELECT * FROM unnest($1::text[], $2::integer[]))`,
+			pq.StringArray(replacedPubkeys), pq.Int64Array(replacedKinds))
+		if err != nil {
+			return results, err
+		}
+	}
+	if len(recommendPubkeys) > 0 {
+		_, err := tx.Exec(`DELETE FROM event WHERE (pubkey, kind, content) IN (
+          SELECT * FROM unnest($1::text[], $2::integer[], $3::text[]))`,
+			pq.StringArray(recommendPubkeys), pq.Int64Array(recommendKinds), pq.StringArray(recommendContents))
+		if err != nil {
+			return results, err
+		}
+	}
+
+	// insert all the others at once
+	if len(inserted) > 0 {
+		values := make([]string, len(inserted))
+		params := make([]any, 0, len(inserted)*7)
+		for n, i := range inserted {
+			evt := events[i]
+			tagsj, _ := json.Marshal(evt.Tags)
+			p := n * 7
+			values[n] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d)", p+1, p+2, p+3, p+4, p+5, p+6, p+7)
+			params = append(params, evt.ID, evt.PubKey, evt.CreatedAt.Unix(), evt.Kind, tagsj, evt.Content, evt.Sig)
+		}
+
+		var ids []string
+		err := tx.Select(&ids, `
+        INSERT INTO event (id, pubkey, created_at, kind, tags, content, sig)
+        VALUES `+strings.Join(values, ", ")+`
+        ON CONFLICT (id) DO NOTHING
+        RETURNING id`, params...)
+		if err != nil {
+			return results, err
+		}
+
+		added := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			added[id] = true
+		}
+		for _, i := range inserted {
+			if !added[events[i].ID] {
+				results[i] = storage.ErrDupEvent
+			}
+		}
+	}
+
+	// prune older events, once per pubkey and kind
+	pruned := make(map[string]bool)
+	for _, i := range inserted {
+		evt := events[i]
+		key := evt.PubKey + ":" + fmt.Sprint(evt.Kind)
+		if results[i] != nil || pruned[key] {
+			continue
+		}
+		pruned[key] = true
+		if _, err := tx.Exec(pruneQuery, evt.PubKey, evt.Kind); err != nil {
+			return results, err
+		}
+	}
+
+	return results, tx.Commit()
+}
+
+// replacementKey identifies the events an event replaces, or is empty
+// if it doesn't replace any.
+func replacementKey(evt *nostr.Event) string {
+	switch {
+	case evt.Kind == nostr.KindSetMetadata || evt.Kind == nostr.KindContactList || (10000 <= evt.Kind && evt.Kind < 20000):
+		return fmt.Sprintf("%s:%d", evt.PubKey, evt.Kind)
+	case evt.Kind == nostr.KindRecommendServer:
+		return fmt.Sprintf("%s:%d:%s", evt.PubKey, evt.Kind, evt.Content)
+	default:
+		return ""
+	}
+}
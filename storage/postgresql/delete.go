@@ -1,6 +1,23 @@
 package postgresql
 
+import (
+	"context"
+
+	"github.com/fiatjaf/relayer"
+)
+
 func (b PostgresBackend) DeleteEvent(id string, pubkey string) error {
-	_, err := b.DB.Exec("DELETE FROM event WHERE id = $1 AND pubkey = $2", id, pubkey)
+	return b.DeleteEventContext(context.Background(), id, pubkey)
+}
+
+// DeleteEventContext is like DeleteEvent, cancelling the deletion when ctx
+// is done and timing it in a "sql" span, see [relayer.StartSpan].
+func (b PostgresBackend) DeleteEventContext(ctx context.Context, id string, pubkey string) error {
+	ctx, span := relayer.StartSpan(ctx, "sql", "delete", id)
+	defer span.End()
+	_, err := b.DB.ExecContext(ctx, "DELETE FROM event WHERE id = $1 AND pubkey = $2", id, pubkey)
+	if err != nil {
+		span.RecordError(err)
+	}
 	return err
 }
@@ -1,6 +1,8 @@
 package postgresql
 
 import (
+	"context"
+
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/reflectx"
 	_ "github.com/lib/pq"
@@ -43,5 +45,20 @@ CREATE INDEX IF NOT EXISTS timeidx ON event (created_at DESC);
 CREATE INDEX IF NOT EXISTS kindidx ON event (kind);
 CREATE INDEX IF NOT EXISTS arbitrarytagvalues ON event USING gin (tagvalues);
     `)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if b.BatchSize > 1 {
+		b.startBatching()
+	}
+	if b.Notify {
+		return b.listen()
+	}
+	return nil
+}
+
+// CheckHealth pings the database.
+func (b *PostgresBackend) CheckHealth(ctx context.Context) error {
+	return b.DB.PingContext(ctx)
 }
@@ -0,0 +1,24 @@
+package postgresql
+
+import "github.com/fiatjaf/relayer"
+
+// RegisterMetrics exposes the state of the connection pool and of the batch queue.
+func (b *PostgresBackend) RegisterMetrics(m *relayer.Metrics) {
+	m.GaugeFunc("relayer_postgres_connections_open", "Connections open to the database.", func() float64 {
+		return float64(b.DB.Stats().OpenConnections)
+	})
+	m.GaugeFunc("relayer_postgres_connections_in_use", "Connections to the database currently in use.", func() float64 {
+		return float64(b.DB.Stats().InUse)
+	})
+	m.CounterFunc("relayer_postgres_connection_waits_total", "Times a query waited for a free connection.", func() float64 {
+		return float64(b.DB.Stats().WaitCount)
+	})
+	m.CounterFunc("relayer_postgres_connection_wait_seconds_total", "Time spent waiting for free connections.", func() float64 {
+		return b.DB.Stats().WaitDuration.Seconds()
+	})
+	if b.batches != nil {
+		m.GaugeFunc("relayer_postgres_batch_queue_length", "Events waiting to be written in a batch.", func() float64 {
+			return float64(len(b.batches.pending))
+		})
+	}
+}
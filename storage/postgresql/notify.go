@@ -0,0 +1,119 @@
+package postgresql
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const defaultNotifyChannel = "relayer_events"
+
+// listen starts forwarding events saved by other instances to InjectEvents.
+func (b *PostgresBackend) listen() error {
+	if b.NotifyChannel == "" {
+		b.NotifyChannel = defaultNotifyChannel
+	}
+
+	var instance [8]byte
+	if _, err := rand.Read(instance[:]); err != nil {
+		return err
+	}
+	b.instance = hex.EncodeToString(instance[:])
+	b.injected = make(chan nostr.Event, 100)
+
+	// the listener reconnects by itself and listens again on the channel
+	listener := pq.NewListener(b.DatabaseURL, time.Second, time.Minute,
+		func(ev pq.ListenerEventType, err error) {
+			switch ev {
+			case pq.ListenerEventDisconnected:
+				b.logf("postgres listener disconnected: %v", err)
+			case pq.ListenerEventReconnected:
+				b.logf("postgres listener reconnected, events saved meanwhile by other instances were not pushed")
+			case pq.ListenerEventConnectionAttemptFailed:
+				b.logf("postgres listener failed to reconnect: %v", err)
+			}
+		})
+	if err := listener.Listen(b.NotifyChannel); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to listen on %s: %w", b.NotifyChannel, err)
+	}
+
+	b.listener = listener
+	b.stopListening = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-b.stopListening:
+				close(b.injected)
+				return
+			case n := <-listener.Notify:
+				// n is nil after a reconnection
+				if n != nil {
+					b.forward(n.Extra)
+				}
+			case <-time.After(90 * time.Second):
+				// make sure the connection is still alive
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// forward loads the event referred to by a notification payload
+// and sends it to InjectEvents, unless it was saved by this instance.
+// Events are dropped when InjectEvents isn't read fast enough.
+func (b *PostgresBackend) forward(payload string) {
+	instance, id, ok := strings.Cut(payload, ":")
+	if !ok || instance == b.instance {
+		return
+	}
+
+	events, err := b.QueryEvents(&nostr.Filter{IDs: []string{id}})
+	if err != nil {
+		b.logf("failed to load notified event %s: %v", id, err)
+		return
+	}
+	for _, evt := range events {
+		select {
+		case b.injected <- evt:
+		default:
+			b.logf("dropped notified event %s, InjectEvents isn't read fast enough", evt.ID)
+		}
+	}
+}
+
+// stopListener stops forwarding notifications, closing InjectEvents, and
+// closes the listener.
+func (b *PostgresBackend) stopListener() {
+	close(b.stopListening)
+	b.listener.Close()
+}
+
+// notify tells the other instances about an event saved by this one.
+func (b *PostgresBackend) notify(evt *nostr.Event) {
+	if _, err := b.DB.Exec(`SELECT pg_notify($1, $2)`, b.NotifyChannel, b.instance+":"+evt.ID); err != nil {
+		b.logf("failed to notify event %s: %v", evt.ID, err)
+	}
+}
+
+// InjectEvents returns the events saved by other instances sharing the database,
+// if Notify is set, so that they are sent to subscribers of this one.
+func (b *PostgresBackend) InjectEvents() chan nostr.Event {
+	return b.injected
+}
+
+func (b *PostgresBackend) logf(format string, v ...any) {
+	if b.Log != nil {
+		b.Log.Errorf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
@@ -1,10 +1,52 @@
 package postgresql
 
 import (
+	"time"
+
+	"github.com/fiatjaf/relayer"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
 )
 
 type PostgresBackend struct {
 	*sqlx.DB
 	DatabaseURL string
+
+	// Notify makes instances sharing the database push events saved by each
+	// other to their subscribers, using LISTEN/NOTIFY on NotifyChannel,
+	// "relayer_events" by default. Relays using a [relayer.EventBus] don't need it.
+	Notify        bool
+	NotifyChannel string
+
+	// BatchSize, if above 1, makes concurrent saves be written together, in
+	// transactions of up to BatchSize events (at most 1000), each save waiting
+	// up to BatchDelay (10ms by default) for others to join. Results are the
+	// same as when saving events one after the other.
+	BatchSize  int
+	BatchDelay time.Duration
+
+	// Log receives errors happening in the background, such as lost
+	// connections of the notifications listener. It defaults to the standard logger.
+	Log relayer.Logger
+
+	instance      string
+	injected      chan nostr.Event
+	listener      *pq.Listener
+	stopListening chan struct{}
+	batches       *batcher
+}
+
+// Close stops listening to notifications, if Notify is set, closing
+// InjectEvents, waits for the events being batched to be written, and
+// closes the database. Saves fail from then on.
+func (b *PostgresBackend) Close() error {
+	if b.batches != nil {
+		b.batches.close()
+	}
+	if b.listener != nil {
+		b.stopListener()
+		b.listener = nil
+	}
+	return b.DB.Close()
 }
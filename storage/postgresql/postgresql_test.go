@@ -0,0 +1,262 @@
+package postgresql
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/fiatjaf/relayer/storage"
+	"github.com/fiatjaf/relayer/storage/storagetest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// tests needing a database use the one in POSTGRESQL_TEST_DATABASE,
+// whose event table is emptied along the way.
+func testBackend(t *testing.T) *PostgresBackend {
+	url := os.Getenv("POSTGRESQL_TEST_DATABASE")
+	if url == "" {
+		t.Skip("POSTGRESQL_TEST_DATABASE not set")
+	}
+	b := &PostgresBackend{DatabaseURL: url}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	if _, err := b.DB.Exec("TRUNCATE event"); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) relayer.Storage {
+		return testBackend(t)
+	})
+}
+
+func TestConformanceBatched(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) relayer.Storage {
+		b := testBackend(t)
+		b.BatchSize = 10
+		b.startBatching()
+		return b
+	})
+}
+
+func TestBatchFailures(t *testing.T) {
+	b := testBackend(t)
+	b.BatchSize = 10
+	b.BatchDelay = 100 * time.Millisecond
+	b.startBatching()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	errs := make(chan error)
+	for _, content := range []string{"hello", "null \x00 byte", "world"} {
+		evt := nostr.Event{PubKey: pk, CreatedAt: time.Now(), Kind: 1, Tags: nostr.Tags{}, Content: content}
+		evt.Sign(sk)
+		go func() { errs <- b.SaveEvent(&evt) }()
+	}
+	failed := 0
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("%d saves failed; want only the one with a null byte", failed)
+	}
+}
+
+func TestBatchClose(t *testing.T) {
+	b := &PostgresBackend{BatchSize: 10}
+	b.startBatching()
+	b.batches.close()
+	if err := b.SaveEvent(&nostr.Event{}); err != errClosed {
+		t.Errorf("SaveEvent after closing = %v", err)
+	}
+}
+
+func TestPlanBatch(t *testing.T) {
+	events := []*nostr.Event{
+		{ID: "1", PubKey: "alice", Kind: 1},
+		{ID: "2", PubKey: "alice", Kind: 0},
+		{ID: "3", PubKey: "bob", Kind: 0},
+		{ID: "1", PubKey: "alice", Kind: 1},
+		{ID: "4", PubKey: "alice", Kind: 0},
+		{ID: "5", PubKey: "alice", Kind: 2, Content: "wss://a"},
+		{ID: "6", PubKey: "alice", Kind: 2, Content: "wss://b"},
+		{ID: "7", PubKey: "alice", Kind: 2, Content: "wss://a"},
+	}
+	results, inserted := planBatch(events)
+
+	wantInserted := []int{0, 2, 4, 6, 7}
+	if fmt.Sprint(inserted) != fmt.Sprint(wantInserted) {
+		t.Errorf("inserted %v; want %v", inserted, wantInserted)
+	}
+	for i, err := range results {
+		if want := i == 3; (err == storage.ErrDupEvent) != want {
+			t.Errorf("result %d = %v", i, err)
+		}
+	}
+}
+
+const (
+	id1 = "971b9489b4fd4e41a85951607922b982d981fa9d55318bc304f21f390721404c"
+	id2 = "a5ba5c8a6b0e8a2ad6e5d7a4e2b0c39b1b4a5b1d1e6c1a0e7c8a7b6d5c4b3a29"
+)
+
+func TestBuildQuery(t *testing.T) {
+	since := time.Unix(1670000000, 0)
+	tests := []struct {
+		name   string
+		filter nostr.Filter
+		where  string
+		params int
+	}{
+		{"empty", nostr.Filter{}, "true", 1},
+		{"full ids", nostr.Filter{IDs: []string{id1, id2}}, "id = ANY($1)", 2},
+		{"mixed ids", nostr.Filter{IDs: []string{id1, "abc", "ABC", "xyz"}}, "(id = ANY($1) OR id LIKE $2)", 3},
+		{"authors and kinds", nostr.Filter{Authors: []string{"abc"}, Kinds: []int{0, 1}}, "pubkey LIKE $1 AND kind IN (0,1)", 2},
+		{"tags", nostr.Filter{Tags: nostr.TagMap{"p": {"aaa"}, "e": {"bbb", "ccc"}}, Since: &since},
+			"tagvalues && $1" +
+				" AND EXISTS (SELECT 1 FROM jsonb_array_elements(tags) AS t WHERE t->>0 = $2 AND t->>1 = ANY($3))" +
+				" AND EXISTS (SELECT 1 FROM jsonb_array_elements(tags) AS t WHERE t->>0 = $4 AND t->>1 = ANY($5))" +
+				" AND created_at > $6", 7},
+	}
+	for _, tt := range tests {
+		query, params, err := buildQuery(&tt.filter)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		where := query[strings.Index(query, "WHERE ")+6 : strings.Index(query, " ORDER BY")]
+		if where != tt.where || len(params) != tt.params {
+			t.Errorf("%s: got %q with %d params; want %q with %d", tt.name, where, len(params), tt.where, tt.params)
+		}
+	}
+}
+
+func TestBuildQueryLimits(t *testing.T) {
+	many := make([]string, 501)
+	for i := range many {
+		many[i] = id1
+	}
+	tests := []struct {
+		filter nostr.Filter
+		err    error
+	}{
+		{nostr.Filter{IDs: many}, ErrTooManyIDs},
+		{nostr.Filter{Authors: many}, ErrTooManyAuthors},
+		{nostr.Filter{Kinds: make([]int, 11)}, ErrTooManyKinds},
+		{nostr.Filter{Tags: nostr.TagMap{"e": many[:11]}}, ErrTooManyTagValues},
+	}
+	for _, tt := range tests {
+		if _, _, err := buildQuery(&tt.filter); !errors.Is(err, tt.err) {
+			t.Errorf("buildQuery error = %v; want %v", err, tt.err)
+		}
+	}
+
+	// these can't match anything but are valid
+	for _, f := range []nostr.Filter{{IDs: []string{}}, {Kinds: []int{}}, {Authors: []string{"not hex"}}} {
+		if query, _, err := buildQuery(&f); query != "" || err != nil {
+			t.Errorf("buildQuery(%v) = %q, %v; want no query", f, query, err)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	b := testBackend(t)
+
+	tests := []struct {
+		name   string
+		filter nostr.Filter
+		index  string
+	}{
+		{"ids", nostr.Filter{IDs: []string{id1, id2}}, "ididx"},
+		{"id prefix", nostr.Filter{IDs: []string{"971b"}}, "ididx"},
+		{"authors", nostr.Filter{Authors: []string{id1}}, "pubkeyprefix"},
+		{"tags", nostr.Filter{Tags: nostr.TagMap{"e": {id1}}}, "arbitrarytagvalues"},
+	}
+	for _, tt := range tests {
+		query, params, err := buildQuery(&tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tx, err := b.DB.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the table is too small for the planner to bother with indexes otherwise
+		tx.Exec("SET LOCAL enable_seqscan = off")
+		var plan []string
+		err = tx.Select(&plan, "EXPLAIN "+query, params...)
+		tx.Rollback()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !strings.Contains(strings.Join(plan, "\n"), tt.index) {
+			t.Errorf("%s: plan doesn't use %s:\n%s", tt.name, tt.index, strings.Join(plan, "\n"))
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	a := testBackend(t)
+	b := &PostgresBackend{DatabaseURL: a.DatabaseURL, Notify: true}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	c := &PostgresBackend{DatabaseURL: a.DatabaseURL, Notify: true}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	evt := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: time.Now(),
+		Kind:      1,
+		Tags:      nostr.Tags{},
+		Content:   "hello",
+	}
+	evt.Sign(sk)
+	if err := b.SaveEvent(&evt); err != nil {
+		t.Fatal(err)
+	}
+	b.AfterSave(&evt)
+
+	select {
+	case got := <-c.InjectEvents():
+		if got.ID != evt.ID {
+			t.Errorf("other instance got event %s; want %s", got.ID, evt.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("other instance didn't get the event")
+	}
+
+	select {
+	case got := <-b.InjectEvents():
+		t.Errorf("instance got its own event %s back", got.ID)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	c.Close()
+	select {
+	case _, ok := <-c.InjectEvents():
+		if ok {
+			t.Error("got an event after closing")
+		}
+	case <-time.After(time.Second):
+		t.Error("InjectEvents not closed")
+	}
+}
@@ -1,81 +1,115 @@
 package postgresql
 
 import (
+	"context"
 	"database/sql"
-	"encoding/hex"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/fiatjaf/relayer"
+	"github.com/fiatjaf/relayer/storage"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/nbd-wtf/go-nostr"
 )
 
+// QueryEvents fails with one of these errors when a filter has more values than allowed.
+// They all wrap storage.ErrFilterTooLarge.
+var (
+	ErrTooManyIDs       = fmt.Errorf("%w: too many ids", storage.ErrFilterTooLarge)
+	ErrTooManyAuthors   = fmt.Errorf("%w: too many authors", storage.ErrFilterTooLarge)
+	ErrTooManyKinds     = fmt.Errorf("%w: too many kinds", storage.ErrFilterTooLarge)
+	ErrTooManyTagValues = fmt.Errorf("%w: too many tag values", storage.ErrFilterTooLarge)
+)
+
 func (b PostgresBackend) QueryEvents(filter *nostr.Filter) (events []nostr.Event, err error) {
-	var conditions []string
-	var params []any
+	return b.QueryEventsContext(context.Background(), filter)
+}
 
+// QueryEventsContext is like QueryEvents, cancelling the query when ctx is
+// done and timing it in a "sql" span, see [relayer.StartSpan].
+func (b PostgresBackend) QueryEventsContext(ctx context.Context, filter *nostr.Filter) (events []nostr.Event, err error) {
 	if filter == nil {
 		err = errors.New("filter cannot be null")
 		return
 	}
 
+	query, params, err := buildQuery(filter)
+	if err != nil || query == "" {
+		return nil, err
+	}
+
+	ctx, span := relayer.StartSpan(ctx, "sql", "query", query)
+	defer span.End()
+
+	rows, err := b.DB.QueryContext(ctx, query, params...)
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		return nil, fmt.Errorf("failed to fetch events using query %q: %w", query, err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var evt nostr.Event
+		var timestamp int64
+		err := rows.Scan(&evt.ID, &evt.PubKey, &timestamp,
+			&evt.Kind, &evt.Tags, &evt.Content, &evt.Sig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		evt.CreatedAt = time.Unix(timestamp, 0)
+		events = append(events, evt)
+	}
+
+	return events, rows.Err()
+}
+
+// buildQuery returns the SQL query for filter and its parameters,
+// or an empty query if nothing can match the filter.
+func buildQuery(filter *nostr.Filter) (query string, params []any, err error) {
+	var conditions []string
+
 	if filter.IDs != nil {
 		if len(filter.IDs) > 500 {
-			// too many ids, fail everything
-			return
+			return "", nil, ErrTooManyIDs
 		}
 
-		likeids := make([]string, 0, len(filter.IDs))
-		for _, id := range filter.IDs {
-			// to prevent sql attack here we will check if
-			// these ids are valid 32byte hex
-			parsed, err := hex.DecodeString(id)
-			if err != nil || len(parsed) != 32 {
-				continue
-			}
-			likeids = append(likeids, fmt.Sprintf("id LIKE '%x%%'", parsed))
-		}
-		if len(likeids) == 0 {
+		cond, ps := prefixCondition("id", filter.IDs)
+		if cond == "" {
 			// ids being [] mean you won't get anything
-			return
+			return "", nil, nil
 		}
-		conditions = append(conditions, "("+strings.Join(likeids, " OR ")+")")
+		conditions = append(conditions, cond)
+		params = append(params, ps...)
 	}
 
 	if filter.Authors != nil {
 		if len(filter.Authors) > 500 {
-			// too many authors, fail everything
-			return
+			return "", nil, ErrTooManyAuthors
 		}
 
-		likekeys := make([]string, 0, len(filter.Authors))
-		for _, key := range filter.Authors {
-			// to prevent sql attack here we will check if
-			// these keys are valid 32byte hex
-			parsed, err := hex.DecodeString(key)
-			if err != nil || len(parsed) != 32 {
-				continue
-			}
-			likekeys = append(likekeys, fmt.Sprintf("pubkey LIKE '%x%%'", parsed))
-		}
-		if len(likekeys) == 0 {
+		cond, ps := prefixCondition("pubkey", filter.Authors)
+		if cond == "" {
 			// authors being [] mean you won't get anything
-			return
+			return "", nil, nil
 		}
-		conditions = append(conditions, "("+strings.Join(likekeys, " OR ")+")")
+		conditions = append(conditions, cond)
+		params = append(params, ps...)
 	}
 
 	if filter.Kinds != nil {
 		if len(filter.Kinds) > 10 {
-			// too many kinds, fail everything
-			return
+			return "", nil, ErrTooManyKinds
 		}
 
 		if len(filter.Kinds) == 0 {
 			// kinds being [] mean you won't get anything
-			return
+			return "", nil, nil
 		}
 		// no sql injection issues since these are ints
 		inkinds := make([]string, len(filter.Kinds))
@@ -85,33 +119,30 @@ func (b PostgresBackend) QueryEvents(filter *nostr.Filter) (events []nostr.Event
 		conditions = append(conditions, `kind IN (`+strings.Join(inkinds, ",")+`)`)
 	}
 
-	tagQuery := make([]string, 0, 1)
+	var tagValues []string
 	for _, values := range filter.Tags {
 		if len(values) == 0 {
 			// any tag set to [] is wrong
-			return
+			return "", nil, nil
 		}
 
-		// add these tags to the query
-		tagQuery = append(tagQuery, values...)
-
-		if len(tagQuery) > 10 {
-			// too many tags, fail everything
-			return
+		tagValues = append(tagValues, values...)
+		if len(tagValues) > 10 {
+			return "", nil, ErrTooManyTagValues
 		}
 	}
 
-	if len(tagQuery) > 0 {
-		arrayBuild := make([]string, len(tagQuery))
-		for i, tagValue := range tagQuery {
-			arrayBuild[i] = "?"
-			params = append(params, tagValue)
-		}
+	if len(tagValues) > 0 {
+		// tagvalues is indexed, but only has the values of the tags,
+		// so their names are then checked against the tags themselves
+		conditions = append(conditions, "tagvalues && ?")
+		params = append(params, pq.StringArray(tagValues))
 
-		// we use a very bad implementation in which we only check the tag values and
-		// ignore the tag names
-		conditions = append(conditions,
-			"tagvalues && ARRAY["+strings.Join(arrayBuild, ",")+"]")
+		for _, name := range sortedKeys(filter.Tags) {
+			conditions = append(conditions, "EXISTS (SELECT 1 FROM jsonb_array_elements(tags) AS t"+
+				" WHERE t->>0 = ? AND t->>1 = ANY(?))")
+			params = append(params, name, pq.StringArray(filter.Tags[name]))
+		}
 	}
 
 	if filter.Since != nil {
@@ -134,30 +165,55 @@ func (b PostgresBackend) QueryEvents(filter *nostr.Filter) (events []nostr.Event
 		params = append(params, filter.Limit)
 	}
 
-	query := b.DB.Rebind(`SELECT
+	query = sqlx.Rebind(sqlx.DOLLAR, `SELECT
       id, pubkey, created_at, kind, tags, content, sig
-    FROM event WHERE ` +
-		strings.Join(conditions, " AND ") +
+    FROM event WHERE `+
+		strings.Join(conditions, " AND ")+
 		" ORDER BY created_at DESC LIMIT ?")
 
-	rows, err := b.DB.Query(query, params...)
-	if err != nil && err != sql.ErrNoRows {
-		return nil, fmt.Errorf("failed to fetch events using query %q: %w", query, err)
-	}
-
-	defer rows.Close()
+	return query, params, nil
+}
 
-	for rows.Next() {
-		var evt nostr.Event
-		var timestamp int64
-		err := rows.Scan(&evt.ID, &evt.PubKey, &timestamp,
-			&evt.Kind, &evt.Tags, &evt.Content, &evt.Sig)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
+// prefixCondition matches column against full-length hex values with a
+// single array parameter, so that the index can be used for an exact lookup,
+// and against shorter ones as prefixes. Values which are not lowercase hex
+// strings of at most 32 bytes are ignored. It returns an empty condition if
+// no value is valid.
+func prefixCondition(column string, values []string) (string, []any) {
+	var full []string
+	var conditions []string
+	var params []any
+	for _, v := range values {
+		// to prevent sql attack here we will check if
+		// these are valid hex strings of at most 32 bytes
+		if len(v) == 0 || len(v) > 64 || strings.Trim(v, "0123456789abcdef") != "" {
+			continue
 		}
-		evt.CreatedAt = time.Unix(timestamp, 0)
-		events = append(events, evt)
+		if len(v) == 64 {
+			full = append(full, v)
+		} else {
+			conditions = append(conditions, column+" LIKE ?")
+			params = append(params, v+"%")
+		}
+	}
+	if len(full) > 0 {
+		conditions = append([]string{column + " = ANY(?)"}, conditions...)
+		params = append([]any{pq.StringArray(full)}, params...)
+	}
+	if len(conditions) == 0 {
+		return "", nil
 	}
+	if len(conditions) == 1 {
+		return conditions[0], params
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", params
+}
 
-	return events, nil
+func sortedKeys(tags nostr.TagMap) []string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
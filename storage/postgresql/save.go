@@ -1,26 +1,57 @@
 package postgresql
 
 import (
+	"context"
 	"encoding/json"
 
+	"github.com/fiatjaf/relayer"
 	"github.com/fiatjaf/relayer/storage"
 	"github.com/nbd-wtf/go-nostr"
 )
 
+// pruneQuery deletes all but the 100 most recent events for each key.
+const pruneQuery = `DELETE FROM event WHERE pubkey = $1 AND kind = $2 AND created_at < (
+      SELECT created_at FROM event WHERE pubkey = $1
+      ORDER BY created_at DESC OFFSET 100 LIMIT 1
+    )`
+
 func (b *PostgresBackend) SaveEvent(evt *nostr.Event) error {
+	return b.SaveEventContext(context.Background(), evt)
+}
+
+// SaveEventContext is like SaveEvent, giving up when ctx is done and timing
+// the save in a "sql" span, see [relayer.StartSpan]. Batched events are only
+// waited for until ctx is done, they are still saved.
+func (b *PostgresBackend) SaveEventContext(ctx context.Context, evt *nostr.Event) error {
+	ctx, span := relayer.StartSpan(ctx, "sql", "batched", b.batches != nil)
+	defer span.End()
+
+	var err error
+	if b.batches != nil {
+		err = b.queueEvent(ctx, evt)
+	} else {
+		err = b.saveEvent(ctx, evt)
+	}
+	if err != nil && err != storage.ErrDupEvent {
+		span.RecordError(err)
+	}
+	return err
+}
+
+func (b *PostgresBackend) saveEvent(ctx context.Context, evt *nostr.Event) error {
 	// react to different kinds of events
 	if evt.Kind == nostr.KindSetMetadata || evt.Kind == nostr.KindContactList || (10000 <= evt.Kind && evt.Kind < 20000) {
 		// delete past events from this user
-		b.DB.Exec(`DELETE FROM event WHERE pubkey = $1 AND kind = $2`, evt.PubKey, evt.Kind)
+		b.DB.ExecContext(ctx, `DELETE FROM event WHERE pubkey = $1 AND kind = $2`, evt.PubKey, evt.Kind)
 	} else if evt.Kind == nostr.KindRecommendServer {
 		// delete past recommend_server events equal to this one
-		b.DB.Exec(`DELETE FROM event WHERE pubkey = $1 AND kind = $2 AND content = $3`,
+		b.DB.ExecContext(ctx, `DELETE FROM event WHERE pubkey = $1 AND kind = $2 AND content = $3`,
 			evt.PubKey, evt.Kind, evt.Content)
 	}
 
 	// insert
 	tagsj, _ := json.Marshal(evt.Tags)
-	res, err := b.DB.Exec(`
+	res, err := b.DB.ExecContext(ctx, `
         INSERT INTO event (id, pubkey, created_at, kind, tags, content, sig)
         VALUES ($1, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT (id) DO NOTHING
@@ -46,9 +77,13 @@ func (b *PostgresBackend) BeforeSave(evt *nostr.Event) {
 }
 
 func (b *PostgresBackend) AfterSave(evt *nostr.Event) {
-	// delete all but the 100 most recent ones for each key
-	b.DB.Exec(`DELETE FROM event WHERE pubkey = $1 AND kind = $2 AND created_at < (
-      SELECT created_at FROM event WHERE pubkey = $1
-      ORDER BY created_at DESC OFFSET 100 LIMIT 1
-    )`, evt.PubKey, evt.Kind)
+	if b.Notify {
+		b.notify(evt)
+	}
+
+	if b.batches != nil {
+		// already done along with the batch
+		return
+	}
+	b.DB.Exec(pruneQuery, evt.PubKey, evt.Kind)
 }
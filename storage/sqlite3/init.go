@@ -1,6 +1,12 @@
 package sqlite3
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/reflectx"
 	_ "github.com/mattn/go-sqlite3"
@@ -28,6 +34,79 @@ CREATE TABLE IF NOT EXISTS event (
   content text NOT NULL,
   sig text NOT NULL
 );
+
+CREATE INDEX IF NOT EXISTS pubkeytimeidx ON event (pubkey, created_at DESC);
+CREATE INDEX IF NOT EXISTS timeidx ON event (created_at DESC);
     `)
-	return err
+	if err != nil {
+		return err
+	}
+	if err := b.migrateUniqueIDs(); err != nil {
+		return err
+	}
+
+	b.check = &quickCheck{}
+	b.check.mu.Lock()
+	defer b.check.mu.Unlock()
+	b.quickCheck(context.Background())
+	return nil
+}
+
+// migrateUniqueIDs deletes the duplicates older versions, which didn't
+// enforce unique ids, may have stored, before creating the unique index.
+func (b *SQLite3Backend) migrateUniqueIDs() error {
+	var exists bool
+	if err := b.DB.Get(&exists, `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'index' AND name = 'ididx')`); err != nil || exists {
+		return err
+	}
+	tx, err := b.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM event WHERE rowid NOT IN (SELECT min(rowid) FROM event GROUP BY id)`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`CREATE UNIQUE INDEX ididx ON event (id)`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CheckHealth pings the database and fails if the last PRAGMA quick_check,
+// run at Init and then every QuickCheckInterval, found it corrupted.
+func (b *SQLite3Backend) CheckHealth(ctx context.Context) error {
+	if err := b.DB.PingContext(ctx); err != nil {
+		return err
+	}
+
+	b.check.mu.Lock()
+	defer b.check.mu.Unlock()
+	interval := b.QuickCheckInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	if time.Since(b.check.checkedAt) >= interval {
+		b.quickCheck(ctx)
+	}
+	return b.check.err
+}
+
+// quickCheck runs PRAGMA quick_check and caches its result, b.check.mu being held.
+// Checks interrupted by ctx are not cached, so they run again next time.
+func (b *SQLite3Backend) quickCheck(ctx context.Context) {
+	var problems []string
+	err := b.DB.SelectContext(ctx, &problems, `PRAGMA quick_check`)
+	if ctx.Err() != nil {
+		return
+	}
+	switch {
+	case err != nil:
+		b.check.err = fmt.Errorf("quick_check: %w", err)
+	case len(problems) != 1 || problems[0] != "ok":
+		b.check.err = errors.New("quick_check: " + strings.Join(problems, "; "))
+	default:
+		b.check.err = nil
+	}
+	b.check.checkedAt = time.Now()
 }
@@ -2,7 +2,7 @@ package sqlite3
 
 import (
 	"database/sql"
-	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
@@ -27,21 +27,13 @@ func (b SQLite3Backend) QueryEvents(filter *nostr.Filter) (events []nostr.Event,
 			return
 		}
 
-		likeids := make([]string, 0, len(filter.IDs))
-		for _, id := range filter.IDs {
-			// to prevent sql attack here we will check if
-			// these ids are valid 32byte hex
-			parsed, err := hex.DecodeString(id)
-			if err != nil || len(parsed) != 32 {
-				continue
-			}
-			likeids = append(likeids, fmt.Sprintf("id LIKE '%x%%'", parsed))
-		}
-		if len(likeids) == 0 {
+		cond, ps := prefixCondition("id", filter.IDs)
+		if cond == "" {
 			// ids being [] mean you won't get anything
 			return
 		}
-		conditions = append(conditions, "("+strings.Join(likeids, " OR ")+")")
+		conditions = append(conditions, cond)
+		params = append(params, ps...)
 	}
 
 	if filter.Authors != nil {
@@ -50,21 +42,13 @@ func (b SQLite3Backend) QueryEvents(filter *nostr.Filter) (events []nostr.Event,
 			return
 		}
 
-		likekeys := make([]string, 0, len(filter.Authors))
-		for _, key := range filter.Authors {
-			// to prevent sql attack here we will check if
-			// these keys are valid 32byte hex
-			parsed, err := hex.DecodeString(key)
-			if err != nil || len(parsed) != 32 {
-				continue
-			}
-			likekeys = append(likekeys, fmt.Sprintf("pubkey LIKE '%x%%'", parsed))
-		}
-		if len(likekeys) == 0 {
+		cond, ps := prefixCondition("pubkey", filter.Authors)
+		if cond == "" {
 			// authors being [] mean you won't get anything
 			return
 		}
-		conditions = append(conditions, "("+strings.Join(likekeys, " OR ")+")")
+		conditions = append(conditions, cond)
+		params = append(params, ps...)
 	}
 
 	if filter.Kinds != nil {
@@ -85,27 +69,31 @@ func (b SQLite3Backend) QueryEvents(filter *nostr.Filter) (events []nostr.Event,
 		conditions = append(conditions, `kind IN (`+strings.Join(inkinds, ",")+`)`)
 	}
 
-	tagQuery := make([]string, 0, 1)
-	for _, values := range filter.Tags {
+	tagCount := 0
+	for name, values := range filter.Tags {
 		if len(values) == 0 {
 			// any tag set to [] is wrong
 			return
 		}
 
-		// add these tags to the query
-		tagQuery = append(tagQuery, values...)
-
-		if len(tagQuery) > 10 {
+		tagCount += len(values)
+		if tagCount > 10 {
 			// too many tags, fail everything
 			return
 		}
-	}
 
-	// we use a very bad implementation in which we only check the tag values and
-	// ignore the tag names
-	for _, tagValue := range tagQuery {
-		params = append(params, "%"+tagValue+"%")
-		conditions = append(conditions, "tags LIKE ?")
+		// tags are stored as a json array, so look for ["<name>","<value>"
+		// followed by either the end of the tag or more elements.
+		// instr is used since it is case-sensitive, unlike LIKE.
+		jname, _ := json.Marshal(name)
+		tagConditions := make([]string, 0, len(values)*2)
+		for _, value := range values {
+			jvalue, _ := json.Marshal(value)
+			needle := "[" + string(jname) + "," + string(jvalue)
+			tagConditions = append(tagConditions, "instr(tags, ?) > 0", "instr(tags, ?) > 0")
+			params = append(params, needle+"]", needle+",")
+		}
+		conditions = append(conditions, "("+strings.Join(tagConditions, " OR ")+")")
 	}
 
 	if filter.Since != nil {
@@ -159,3 +147,34 @@ func (b SQLite3Backend) QueryEvents(filter *nostr.Filter) (events []nostr.Event,
 
 	return events, nil
 }
+
+// prefixCondition builds a condition matching column against full hex values
+// or hex prefixes, ignoring anything else.
+func prefixCondition(column string, values []string) (string, []any) {
+	var full []string
+	var conditions []string
+	var params []any
+	for _, v := range values {
+		// to prevent sql attack here we will check if
+		// these are valid hex strings of at most 32 bytes
+		if len(v) == 0 || len(v) > 64 || strings.Trim(v, "0123456789abcdef") != "" {
+			continue
+		}
+		if len(v) == 64 {
+			full = append(full, v)
+		} else {
+			conditions = append(conditions, column+" LIKE ?")
+			params = append(params, v+"%")
+		}
+	}
+	if len(full) > 0 {
+		conditions = append(conditions, column+" IN (?"+strings.Repeat(",?", len(full)-1)+")")
+		for _, v := range full {
+			params = append(params, v)
+		}
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", params
+}
@@ -23,6 +23,7 @@ func (b *SQLite3Backend) SaveEvent(evt *nostr.Event) error {
 	res, err := b.DB.Exec(`
         INSERT INTO event (id, pubkey, created_at, kind, tags, content, sig)
         VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO NOTHING
     `, evt.ID, evt.PubKey, evt.CreatedAt.Unix(), evt.Kind, tagsj, evt.Content, evt.Sig)
 	if err != nil {
 		return err
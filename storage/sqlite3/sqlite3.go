@@ -1,10 +1,26 @@
 package sqlite3
 
 import (
+	"sync"
+	"time"
+
 	"github.com/jmoiron/sqlx"
 )
 
 type SQLite3Backend struct {
 	*sqlx.DB
 	DatabaseURL string
+
+	// QuickCheckInterval is how often CheckHealth runs PRAGMA quick_check,
+	// one hour by default. The result is cached in between.
+	QuickCheckInterval time.Duration
+
+	check *quickCheck
+}
+
+// quickCheck is the cached result of the last PRAGMA quick_check.
+type quickCheck struct {
+	mu        sync.Mutex
+	err       error
+	checkedAt time.Time
 }
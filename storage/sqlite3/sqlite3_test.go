@@ -0,0 +1,100 @@
+package sqlite3
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/fiatjaf/relayer/storage/storagetest"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) relayer.Storage {
+		b := &SQLite3Backend{DatabaseURL: filepath.Join(t.TempDir(), "events.db")}
+		if err := b.Init(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { b.Close() })
+		return b
+	})
+}
+
+func TestCheckHealth(t *testing.T) {
+	b := &SQLite3Backend{DatabaseURL: filepath.Join(t.TempDir(), "events.db")}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.CheckHealth(context.Background()); err != nil {
+		t.Errorf("CheckHealth: %v", err)
+	}
+}
+
+func TestCheckHealthCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	b := &SQLite3Backend{DatabaseURL: path}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		b.MustExec(`INSERT INTO event VALUES (?, 'b', ?, 1, '[]', '', 'c')`, fmt.Sprint(i), i)
+	}
+	b.Close()
+
+	// overwrite the last page, of one of the indexes
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := len(data) - 4096; i < len(data); i++ {
+		data[i] = 0xff
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	b = &SQLite3Backend{DatabaseURL: path}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.CheckHealth(context.Background()); err == nil {
+		t.Error("corrupted database is healthy")
+	}
+}
+
+func TestInitDeduplicatesIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	db, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the schema of versions without unique ids
+	db.MustExec(`CREATE TABLE event (id text NOT NULL, pubkey text NOT NULL, created_at integer NOT NULL,
+		kind integer NOT NULL, tags jsonb NOT NULL, content text NOT NULL, sig text NOT NULL)`)
+	for i := 0; i < 2; i++ {
+		db.MustExec(`INSERT INTO event VALUES ('a', 'b', 1, 1, '[]', '', 'c')`)
+	}
+	db.Close()
+
+	b := &SQLite3Backend{DatabaseURL: path}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	var count int
+	b.Get(&count, `SELECT count(*) FROM event`)
+	if count != 1 {
+		t.Errorf("%d events after migration", count)
+	}
+	if _, err := b.Exec(`INSERT INTO event VALUES ('a', 'b', 1, 1, '[]', '', 'c')`); err == nil {
+		t.Error("duplicate id inserted")
+	}
+	if err := b.migrateUniqueIDs(); err != nil {
+		t.Errorf("migrating again: %v", err)
+	}
+}
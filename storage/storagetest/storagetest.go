@@ -0,0 +1,311 @@
+// Package storagetest provides a conformance test suite for [relayer.Storage]
+// implementations.
+//
+// The suite describes the behavior expected by the server and shared by the
+// storage backends in this module:
+//
+//   - QueryEvents returns events most recent first, never more than filter.Limit;
+//   - ids and authors match by prefix;
+//   - tag queries match the tag name and the exact value;
+//   - since and until are exclusive;
+//   - kinds or tags set to an empty list match nothing;
+//   - saving an event twice returns [storage.ErrDupEvent];
+//   - replaceable events (kinds 0, 3 and 10000-19999) replace previous ones
+//     from the same pubkey;
+//   - events can only be deleted by their author.
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/fiatjaf/relayer/storage"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Run runs the whole suite against the storage implementation returned by
+// newStorage, which is called once for every subtest and must return
+// an initialized storage with no events.
+func Run(t *testing.T, newStorage func(t *testing.T) relayer.Storage) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, relayer.Storage)
+	}{
+		{"Duplicates", testDuplicates},
+		{"Deletion", testDeletion},
+		{"Replaceable", testReplaceable},
+		{"IDs", testIDs},
+		{"Authors", testAuthors},
+		{"Kinds", testKinds},
+		{"Tags", testTags},
+		{"TimeRange", testTimeRange},
+		{"OrderAndLimit", testOrderAndLimit},
+		{"Concurrency", testConcurrency},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStorage(t))
+		})
+	}
+}
+
+// the time all test events are created around.
+var epoch = time.Unix(1670000000, 0)
+
+// keys used to sign test events, generated once since it is slow-ish.
+var keys = func() []string {
+	sks := make([]string, 4)
+	for i := range sks {
+		sks[i] = nostr.GeneratePrivateKey()
+	}
+	return sks
+}()
+
+// newEvent returns an event signed with keys[signer], created offset seconds after epoch.
+func newEvent(t *testing.T, signer int, kind int, offset int, tags nostr.Tags, content string) nostr.Event {
+	t.Helper()
+	pk, _ := nostr.GetPublicKey(keys[signer])
+	if tags == nil {
+		tags = nostr.Tags{}
+	}
+	evt := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: epoch.Add(time.Duration(offset) * time.Second),
+		Kind:      kind,
+		Tags:      tags,
+		Content:   content,
+	}
+	if err := evt.Sign(keys[signer]); err != nil {
+		t.Fatal(err)
+	}
+	return evt
+}
+
+// save stores events the same way the server does, with AdvancedSaver hooks.
+func save(t *testing.T, store relayer.Storage, events ...nostr.Event) {
+	t.Helper()
+	advancedSaver, _ := store.(relayer.AdvancedSaver)
+	for _, evt := range events {
+		if advancedSaver != nil {
+			advancedSaver.BeforeSave(&evt)
+		}
+		if err := store.SaveEvent(&evt); err != nil {
+			t.Fatalf("SaveEvent(%q): %v", evt.Content, err)
+		}
+		if advancedSaver != nil {
+			advancedSaver.AfterSave(&evt)
+		}
+	}
+}
+
+func query(t *testing.T, store relayer.Storage, filter nostr.Filter) []nostr.Event {
+	t.Helper()
+	events, err := store.QueryEvents(&filter)
+	if err != nil {
+		t.Fatalf("QueryEvents(%s): %v", filter, err)
+	}
+	return events
+}
+
+// expect checks that filter returns exactly want, in order.
+func expect(t *testing.T, store relayer.Storage, filter nostr.Filter, want ...nostr.Event) {
+	t.Helper()
+	got := query(t, store, filter)
+	if contents(got) != contents(want) {
+		t.Errorf("QueryEvents(%s) = %s; want %s", filter, contents(got), contents(want))
+	}
+}
+
+func contents(events []nostr.Event) string {
+	cs := make([]string, len(events))
+	for i, evt := range events {
+		cs[i] = evt.Content
+	}
+	return "[" + strings.Join(cs, " ") + "]"
+}
+
+func testDuplicates(t *testing.T, store relayer.Storage) {
+	evt := newEvent(t, 0, 1, 0, nil, "a")
+	save(t, store, evt)
+	if err := store.SaveEvent(&evt); !errors.Is(err, storage.ErrDupEvent) {
+		t.Errorf("SaveEvent of a duplicate returned %v; want %v", err, storage.ErrDupEvent)
+	}
+	expect(t, store, nostr.Filter{}, evt)
+}
+
+func testDeletion(t *testing.T, store relayer.Storage) {
+	a := newEvent(t, 0, 1, 0, nil, "a")
+	b := newEvent(t, 1, 1, 1, nil, "b")
+	save(t, store, a, b)
+
+	// not the author
+	if err := store.DeleteEvent(a.ID, b.PubKey); err != nil {
+		t.Errorf("DeleteEvent: %v", err)
+	}
+	expect(t, store, nostr.Filter{}, b, a)
+
+	if err := store.DeleteEvent(a.ID, a.PubKey); err != nil {
+		t.Errorf("DeleteEvent: %v", err)
+	}
+	expect(t, store, nostr.Filter{}, b)
+
+	// deleting what doesn't exist is not an error
+	if err := store.DeleteEvent(a.ID, a.PubKey); err != nil {
+		t.Errorf("DeleteEvent of a missing event: %v", err)
+	}
+}
+
+func testReplaceable(t *testing.T, store relayer.Storage) {
+	meta1 := newEvent(t, 0, 0, 0, nil, "meta1")
+	meta2 := newEvent(t, 0, 0, 1, nil, "meta2")
+	contacts1 := newEvent(t, 0, 3, 0, nil, "contacts1")
+	contacts2 := newEvent(t, 0, 3, 2, nil, "contacts2")
+	list1 := newEvent(t, 0, 10002, 0, nil, "list1")
+	list2 := newEvent(t, 0, 10002, 3, nil, "list2")
+	otherMeta := newEvent(t, 1, 0, 0, nil, "othermeta")
+	save(t, store, meta1, contacts1, list1, otherMeta, meta2, contacts2, list2)
+
+	expect(t, store, nostr.Filter{Kinds: []int{0, 3, 10002}}, list2, contacts2, meta2, otherMeta)
+}
+
+func testIDs(t *testing.T, store relayer.Storage) {
+	a := newEvent(t, 0, 1, 0, nil, "a")
+	b := newEvent(t, 0, 1, 1, nil, "b")
+	c := newEvent(t, 0, 1, 2, nil, "c")
+	save(t, store, a, b, c)
+
+	expect(t, store, nostr.Filter{IDs: []string{a.ID}}, a)
+	expect(t, store, nostr.Filter{IDs: []string{a.ID, c.ID}}, c, a)
+	expect(t, store, nostr.Filter{IDs: []string{b.ID[:10]}}, b)
+	expect(t, store, nostr.Filter{IDs: []string{b.ID[:9], a.ID}}, b, a)
+	expect(t, store, nostr.Filter{IDs: []string{strings.Repeat("0", 64)}})
+}
+
+func testAuthors(t *testing.T, store relayer.Storage) {
+	a := newEvent(t, 0, 1, 0, nil, "a")
+	b := newEvent(t, 1, 1, 1, nil, "b")
+	c := newEvent(t, 2, 1, 2, nil, "c")
+	save(t, store, a, b, c)
+
+	expect(t, store, nostr.Filter{Authors: []string{a.PubKey}}, a)
+	expect(t, store, nostr.Filter{Authors: []string{a.PubKey, c.PubKey}}, c, a)
+	expect(t, store, nostr.Filter{Authors: []string{b.PubKey[:8]}}, b)
+	expect(t, store, nostr.Filter{Authors: []string{b.PubKey[:7], c.PubKey}}, c, b)
+}
+
+func testKinds(t *testing.T, store relayer.Storage) {
+	a := newEvent(t, 0, 1, 0, nil, "a")
+	b := newEvent(t, 0, 7, 1, nil, "b")
+	c := newEvent(t, 0, 30023, 2, nil, "c")
+	save(t, store, a, b, c)
+
+	expect(t, store, nostr.Filter{Kinds: []int{1}}, a)
+	expect(t, store, nostr.Filter{Kinds: []int{1, 30023}}, c, a)
+	expect(t, store, nostr.Filter{Kinds: []int{2}})
+	expect(t, store, nostr.Filter{Kinds: []int{}})
+	expect(t, store, nostr.Filter{Authors: []string{a.PubKey}, Kinds: []int{7}}, b)
+}
+
+func testTags(t *testing.T, store relayer.Storage) {
+	a := newEvent(t, 0, 1, 0, nostr.Tags{{"e", "x"}, {"p", "y", "wss://relay"}}, "a")
+	b := newEvent(t, 0, 1, 1, nostr.Tags{{"p", "x"}}, "b")
+	c := newEvent(t, 0, 1, 2, nostr.Tags{{"t", "xyz"}, {"e", "z"}}, "c")
+	save(t, store, a, b, c)
+
+	expect(t, store, nostr.Filter{Tags: nostr.TagMap{"e": {"x"}}}, a)
+	expect(t, store, nostr.Filter{Tags: nostr.TagMap{"p": {"x", "y"}}}, b, a)
+	expect(t, store, nostr.Filter{Tags: nostr.TagMap{"e": {"x", "z"}}}, c, a)
+	// tag names are matched as well as values, and values exactly
+	expect(t, store, nostr.Filter{Tags: nostr.TagMap{"e": {"p"}}})
+	expect(t, store, nostr.Filter{Tags: nostr.TagMap{"t": {"xy"}}})
+	expect(t, store, nostr.Filter{Tags: nostr.TagMap{"p": {"wss://relay"}}})
+	// all tags must match
+	expect(t, store, nostr.Filter{Tags: nostr.TagMap{"e": {"x"}, "p": {"y"}}}, a)
+	expect(t, store, nostr.Filter{Tags: nostr.TagMap{"e": {"x"}, "p": {"x"}}})
+	expect(t, store, nostr.Filter{Tags: nostr.TagMap{"e": {}}})
+}
+
+func testTimeRange(t *testing.T, store relayer.Storage) {
+	var events []nostr.Event
+	for i := 0; i < 5; i++ {
+		events = append(events, newEvent(t, 0, 1, i*10, nil, fmt.Sprint(i)))
+	}
+	save(t, store, events...)
+
+	since := epoch.Add(10 * time.Second)
+	until := epoch.Add(30 * time.Second)
+	expect(t, store, nostr.Filter{Since: &since}, events[4], events[3], events[2])
+	expect(t, store, nostr.Filter{Until: &until}, events[2], events[1], events[0])
+	expect(t, store, nostr.Filter{Since: &since, Until: &until}, events[2])
+}
+
+func testOrderAndLimit(t *testing.T, store relayer.Storage) {
+	// more than any storage returns at once, spread across authors
+	var events []nostr.Event
+	for i := 0; i < 120; i++ {
+		events = append(events, newEvent(t, i%len(keys), 1, i, nil, fmt.Sprint(i)))
+	}
+	// save in a mixed order
+	for i := 0; i < len(events); i += 2 {
+		save(t, store, events[i])
+	}
+	for i := 1; i < len(events); i += 2 {
+		save(t, store, events[i])
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].CreatedAt.After(events[j].CreatedAt) })
+
+	expect(t, store, nostr.Filter{Limit: 10}, events[:10]...)
+	expect(t, store, nostr.Filter{Limit: 1}, events[0])
+
+	got := query(t, store, nostr.Filter{})
+	if len(got) < 100 {
+		t.Errorf("QueryEvents with no limit returned %d events; want at least 100", len(got))
+	}
+	if contents(got) != contents(events[:len(got)]) {
+		t.Errorf("QueryEvents with no limit = %s; want the most recent %s", contents(got), contents(events[:len(got)]))
+	}
+}
+
+func testConcurrency(t *testing.T, store relayer.Storage) {
+	const n = 40
+
+	events := make([]nostr.Event, n)
+	for i := range events {
+		events[i] = newEvent(t, i%len(keys), 1, i, nostr.Tags{{"t", "concurrency"}}, fmt.Sprint(i))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for i := range events {
+		wg.Add(2)
+		go func(evt nostr.Event) {
+			defer wg.Done()
+			if err := store.SaveEvent(&evt); err != nil {
+				errs <- fmt.Errorf("SaveEvent: %w", err)
+			}
+		}(events[i])
+		go func() {
+			defer wg.Done()
+			if _, err := store.QueryEvents(&nostr.Filter{Tags: nostr.TagMap{"t": {"concurrency"}}}); err != nil {
+				errs <- fmt.Errorf("QueryEvents: %w", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	got := query(t, store, nostr.Filter{Tags: nostr.TagMap{"t": {"concurrency"}}})
+	if len(got) != n {
+		t.Errorf("QueryEvents after concurrent saves returned %d events; want %d", len(got), n)
+	}
+}
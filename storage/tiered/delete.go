@@ -0,0 +1,43 @@
+package tiered
+
+import (
+	"context"
+
+	"github.com/fiatjaf/relayer"
+)
+
+// DeleteEvent deletes the event from every tier, returning the first error
+// after trying all of them.
+func (ts *TieredStorage) DeleteEvent(id string, pubkey string) error {
+	return ts.DeleteEventContext(context.Background(), id, pubkey)
+}
+
+// DeleteEventContext is like DeleteEvent, passing ctx on to the tiers
+// which are a [relayer.ContextDeleter].
+func (ts *TieredStorage) DeleteEventContext(ctx context.Context, id string, pubkey string) error {
+	var errs []error
+	for _, tier := range ts.Tiers {
+		if cd, ok := tier.Storage.(relayer.ContextDeleter); ok {
+			errs = append(errs, cd.DeleteEventContext(ctx, id, pubkey))
+		} else {
+			errs = append(errs, tier.Storage.DeleteEvent(id, pubkey))
+		}
+	}
+	return firstError(errs)
+}
+
+func (ts *TieredStorage) BeforeDelete(id string, pubkey string) {
+	for _, tier := range ts.Tiers {
+		if deleter, ok := tier.Storage.(relayer.AdvancedDeleter); ok {
+			deleter.BeforeDelete(id, pubkey)
+		}
+	}
+}
+
+func (ts *TieredStorage) AfterDelete(id string, pubkey string) {
+	for _, tier := range ts.Tiers {
+		if deleter, ok := tier.Storage.(relayer.AdvancedDeleter); ok {
+			deleter.AfterDelete(id, pubkey)
+		}
+	}
+}
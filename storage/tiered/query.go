@@ -0,0 +1,125 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// QueryEvents queries tiers from the hottest to the coldest, stopping as soon
+// as the events found so far can't be completed by colder tiers: when a tier
+// holds the whole time range of the filter, when it returned as many events
+// as requested and all of them are within its window, or when all requested
+// ids have been found.
+//
+// Tiers whose window starts after filter.Until are skipped, as are tiers
+// which don't handle the filter. Results are merged, deduplicated by id,
+// sorted most recent first and capped at the limit, 100 if unset as in the
+// other backends. An error is returned only if no tier could answer.
+func (ts *TieredStorage) QueryEvents(filter *nostr.Filter) ([]nostr.Event, error) {
+	return ts.QueryEventsContext(context.Background(), filter)
+}
+
+// QueryEventsContext is like QueryEvents, passing ctx on to the tiers
+// which are a [relayer.ContextQuerier].
+func (ts *TieredStorage) QueryEventsContext(ctx context.Context, filter *nostr.Filter) ([]nostr.Event, error) {
+	if filter == nil {
+		return nil, errors.New("filter cannot be null")
+	}
+
+	limit := filter.Limit
+	if limit < 1 || limit > 100 {
+		limit = 100
+	}
+
+	now := ts.timeNow()
+	var events []nostr.Event
+	var lastErr error
+	answered := false
+	seen := make(map[string]struct{})
+	for i := range ts.Tiers {
+		tier := &ts.Tiers[i]
+		if tier.Handles != nil && !tier.Handles(filter) {
+			continue
+		}
+		if filter.Until != nil && !tier.holds(*filter.Until, now) {
+			// everything asked for is older than what this tier keeps
+			continue
+		}
+
+		f := *filter
+		var res []nostr.Event
+		var err error
+		if cq, ok := tier.Storage.(relayer.ContextQuerier); ok {
+			res, err = cq.QueryEventsContext(ctx, &f)
+		} else {
+			res, err = tier.Storage.QueryEvents(&f)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		answered = true
+
+		inWindow := 0
+		for _, evt := range res {
+			if tier.holds(evt.CreatedAt, now) {
+				inWindow++
+			}
+			if _, ok := seen[evt.ID]; ok {
+				continue
+			}
+			seen[evt.ID] = struct{}{}
+			events = append(events, evt)
+		}
+
+		if tier.covers(filter, now) ||
+			inWindow >= limit ||
+			foundAllIDs(filter, seen) {
+			break
+		}
+	}
+
+	if !answered && lastErr != nil {
+		return nil, lastErr
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].CreatedAt.After(events[j].CreatedAt) })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	return events, nil
+}
+
+// foundAllIDs reports whether filter asks for full ids only and all of them were found.
+func foundAllIDs(filter *nostr.Filter, seen map[string]struct{}) bool {
+	if len(filter.IDs) == 0 {
+		return false
+	}
+	for _, id := range filter.IDs {
+		if _, ok := seen[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (ts *TieredStorage) BeforeQuery(filter *nostr.Filter) {
+	for _, tier := range ts.Tiers {
+		if querier, ok := tier.Storage.(relayer.AdvancedQuerier); ok {
+			querier.BeforeQuery(filter)
+		}
+	}
+}
+
+func (ts *TieredStorage) AfterQuery(events []nostr.Event, filter *nostr.Filter) {
+	for _, tier := range ts.Tiers {
+		if querier, ok := tier.Storage.(relayer.AdvancedQuerier); ok {
+			querier.AfterQuery(events, filter)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package tiered
+
+import (
+	"context"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// SaveEvent saves evt to every tier holding events as old as it.
+// Storage errors from any tier are returned, after trying all of them.
+func (ts *TieredStorage) SaveEvent(evt *nostr.Event) error {
+	return ts.SaveEventContext(context.Background(), evt)
+}
+
+// SaveEventContext is like SaveEvent, passing ctx on to the tiers
+// which are a [relayer.ContextSaver].
+func (ts *TieredStorage) SaveEventContext(ctx context.Context, evt *nostr.Event) error {
+	var errs []error
+	ts.forEach(evt.CreatedAt, func(store relayer.Storage) {
+		if cs, ok := store.(relayer.ContextSaver); ok {
+			errs = append(errs, cs.SaveEventContext(ctx, evt))
+		} else {
+			errs = append(errs, store.SaveEvent(evt))
+		}
+	})
+	return firstError(errs)
+}
+
+func (ts *TieredStorage) BeforeSave(evt *nostr.Event) {
+	ts.forEach(evt.CreatedAt, func(store relayer.Storage) {
+		if saver, ok := store.(relayer.AdvancedSaver); ok {
+			saver.BeforeSave(evt)
+		}
+	})
+}
+
+func (ts *TieredStorage) AfterSave(evt *nostr.Event) {
+	ts.forEach(evt.CreatedAt, func(store relayer.Storage) {
+		if saver, ok := store.(relayer.AdvancedSaver); ok {
+			saver.AfterSave(evt)
+		}
+	})
+}
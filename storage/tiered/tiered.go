@@ -0,0 +1,119 @@
+// Package tiered implements a storage spreading events over several backends,
+// such as a fast local store for recent events in front of a database
+// holding the full history.
+package tiered
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/fiatjaf/relayer/storage"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Tier is one of the backends of a [TieredStorage].
+type Tier struct {
+	Storage relayer.Storage
+
+	// MaxAge, if positive, is how far back in time this tier holds events.
+	// Older events are not saved to it and queries about older events are
+	// passed on to the next tiers. Zero means the tier holds the whole history.
+	MaxAge time.Duration
+
+	// Handles, if set, reports whether the tier should be queried for a filter
+	// at all, for example to send NIP-50 searches to a full-text search backend only.
+	Handles func(*nostr.Filter) bool
+}
+
+// TieredStorage writes events through to all of its tiers and answers queries
+// from the first tiers able to, merging their results.
+//
+// Tiers are ordered from the hottest to the coldest: the first ones should be
+// the fastest and hold the most recent events, while the last one is expected
+// to hold the full history.
+type TieredStorage struct {
+	Tiers []Tier
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func (ts *TieredStorage) Init() error {
+	if len(ts.Tiers) == 0 {
+		return errors.New("no tiers")
+	}
+	for _, tier := range ts.Tiers {
+		if err := tier.Storage.Init(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckHealth checks every tier which is a [relayer.HealthChecker].
+func (ts *TieredStorage) CheckHealth(ctx context.Context) error {
+	for i, tier := range ts.Tiers {
+		if checker, ok := tier.Storage.(relayer.HealthChecker); ok {
+			if err := checker.CheckHealth(ctx); err != nil {
+				return fmt.Errorf("tier %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RegisterMetrics registers the metrics of every tier which is a [relayer.MetricsReporter].
+// Tiers of the same type register metrics of the same names, so only the first one's are kept.
+func (ts *TieredStorage) RegisterMetrics(m *relayer.Metrics) {
+	for _, tier := range ts.Tiers {
+		if reporter, ok := tier.Storage.(relayer.MetricsReporter); ok {
+			reporter.RegisterMetrics(m)
+		}
+	}
+}
+
+func (ts *TieredStorage) timeNow() time.Time {
+	if ts.now != nil {
+		return ts.now()
+	}
+	return time.Now()
+}
+
+// holds reports whether the tier keeps events created at t.
+func (tier *Tier) holds(t time.Time, now time.Time) bool {
+	return tier.MaxAge <= 0 || !t.Before(now.Add(-tier.MaxAge))
+}
+
+// covers reports whether all events matching filter are within the tier's window.
+func (tier *Tier) covers(filter *nostr.Filter, now time.Time) bool {
+	return tier.MaxAge <= 0 || (filter.Since != nil && tier.holds(*filter.Since, now))
+}
+
+// forEach calls fn for every tier holding events created at t.
+func (ts *TieredStorage) forEach(t time.Time, fn func(relayer.Storage)) {
+	now := ts.timeNow()
+	for i := range ts.Tiers {
+		if ts.Tiers[i].holds(t, now) {
+			fn(ts.Tiers[i].Storage)
+		}
+	}
+}
+
+// firstError returns the first error in errs other than storage.ErrDupEvent,
+// or storage.ErrDupEvent if any tier already had the event.
+func firstError(errs []error) error {
+	var dup error
+	for _, err := range errs {
+		switch err {
+		case nil:
+		case storage.ErrDupEvent:
+			dup = err
+		default:
+			return err
+		}
+	}
+	return dup
+}
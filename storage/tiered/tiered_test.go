@@ -0,0 +1,183 @@
+package tiered
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/fiatjaf/relayer/storage/memory"
+	"github.com/fiatjaf/relayer/storage/storagetest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) relayer.Storage {
+		// conformance events are created around this time
+		now := time.Unix(1670000060, 0)
+		ts := &TieredStorage{
+			Tiers: []Tier{
+				{Storage: &memory.MemoryBackend{}, MaxAge: 30 * time.Second},
+				{Storage: &memory.MemoryBackend{}},
+			},
+			now: func() time.Time { return now },
+		}
+		if err := ts.Init(); err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	})
+}
+
+func TestRouting(t *testing.T) {
+	now := time.Unix(1670000000, 0)
+	hot := &countingStorage{MemoryBackend: &memory.MemoryBackend{}}
+	cold := &countingStorage{MemoryBackend: &memory.MemoryBackend{}}
+	search := &countingStorage{MemoryBackend: &memory.MemoryBackend{}}
+	ts := &TieredStorage{
+		Tiers: []Tier{
+			{Storage: hot, MaxAge: time.Hour},
+			{Storage: search, Handles: func(f *nostr.Filter) bool { return f.Search != "" }},
+			{Storage: cold},
+		},
+		now: func() time.Time { return now },
+	}
+	ts.Init()
+
+	for i, age := range []time.Duration{time.Minute, 2 * time.Minute, 2 * time.Hour} {
+		evt := nostr.Event{
+			ID:        string(rune('a' + i)),
+			PubKey:    "alice",
+			CreatedAt: now.Add(-age),
+			Kind:      1,
+			Content:   "hello",
+		}
+		ts.SaveEvent(&evt)
+	}
+	if hot.Len() != 2 || cold.Len() != 3 {
+		t.Fatalf("saved %d events to hot tier and %d to cold tier; want 2 and 3", hot.Len(), cold.Len())
+	}
+
+	recent := now.Add(-10 * time.Minute)
+	old := now.Add(-90 * time.Minute)
+	tests := []struct {
+		name            string
+		filter          nostr.Filter
+		want            int
+		hot, cold, srch int
+	}{
+		{"recent", nostr.Filter{Since: &recent}, 2, 1, 0, 0},
+		{"limit within hot window", nostr.Filter{Limit: 2}, 2, 1, 0, 0},
+		{"full history", nostr.Filter{}, 3, 1, 1, 0},
+		{"old", nostr.Filter{Until: &old}, 1, 0, 1, 0},
+		{"ids", nostr.Filter{IDs: []string{"a"}}, 1, 1, 0, 0},
+		{"search", nostr.Filter{Search: "hello", Limit: 5}, 3, 1, 0, 1},
+	}
+	for _, tt := range tests {
+		hot.queries, cold.queries, search.queries = 0, 0, 0
+		events, err := ts.QueryEvents(&tt.filter)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(events) != tt.want {
+			t.Errorf("%s: got %d events; want %d", tt.name, len(events), tt.want)
+		}
+		if hot.queries != tt.hot || cold.queries != tt.cold || search.queries != tt.srch {
+			t.Errorf("%s: queried hot %d, search %d, cold %d times; want %d, %d, %d", tt.name,
+				hot.queries, search.queries, cold.queries, tt.hot, tt.srch, tt.cold)
+		}
+	}
+
+	ts.DeleteEvent("a", "alice")
+	if hot.Len() != 1 || cold.Len() != 2 {
+		t.Errorf("after deletion, hot tier has %d events and cold tier %d; want 1 and 2", hot.Len(), cold.Len())
+	}
+}
+
+func TestQueryDefaultLimit(t *testing.T) {
+	now := time.Unix(1670000000, 0)
+	ts := &TieredStorage{
+		Tiers: []Tier{
+			{Storage: &memory.MemoryBackend{}, MaxAge: time.Hour},
+			{Storage: &memory.MemoryBackend{}},
+		},
+		now: func() time.Time { return now },
+	}
+	ts.Init()
+
+	// 60 events in both tiers and 60 only in the cold one
+	for i := 0; i < 120; i++ {
+		evt := nostr.Event{
+			ID:        fmt.Sprintf("%064x", i),
+			PubKey:    "alice",
+			CreatedAt: now.Add(-time.Duration(i) * time.Minute),
+			Kind:      1,
+		}
+		ts.SaveEvent(&evt)
+	}
+
+	events, err := ts.QueryEvents(&nostr.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 100 {
+		t.Errorf("got %d events without a limit; want 100", len(events))
+	}
+}
+
+type countingStorage struct {
+	*memory.MemoryBackend
+	queries int
+}
+
+func (cs *countingStorage) QueryEvents(f *nostr.Filter) ([]nostr.Event, error) {
+	cs.queries++
+	return cs.MemoryBackend.QueryEvents(f)
+}
+
+// contextStorage records the contexts it is called with.
+type contextStorage struct {
+	memory.MemoryBackend
+	contexts []context.Context
+}
+
+func (s *contextStorage) QueryEventsContext(ctx context.Context, filter *nostr.Filter) ([]nostr.Event, error) {
+	s.contexts = append(s.contexts, ctx)
+	return s.QueryEvents(filter)
+}
+
+func (s *contextStorage) SaveEventContext(ctx context.Context, evt *nostr.Event) error {
+	s.contexts = append(s.contexts, ctx)
+	return s.SaveEvent(evt)
+}
+
+func (s *contextStorage) DeleteEventContext(ctx context.Context, id string, pubkey string) error {
+	s.contexts = append(s.contexts, ctx)
+	return s.DeleteEvent(id, pubkey)
+}
+
+func TestContext(t *testing.T) {
+	hot, cold := &contextStorage{}, &contextStorage{}
+	ts := &TieredStorage{Tiers: []Tier{{Storage: hot, MaxAge: time.Hour}, {Storage: cold}}}
+	ts.Init()
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, true)
+	evt := nostr.Event{ID: "a", PubKey: "alice", Kind: 1, CreatedAt: time.Now(), Tags: nostr.Tags{}}
+	ts.SaveEventContext(ctx, &evt)
+	ts.QueryEventsContext(ctx, &nostr.Filter{})
+	ts.DeleteEventContext(ctx, "a", "alice")
+
+	for name, tier := range map[string]*contextStorage{"hot": hot, "cold": cold} {
+		if len(tier.contexts) != 3 {
+			t.Errorf("%s tier called with a context %d times, want 3", name, len(tier.contexts))
+		}
+		for i, got := range tier.contexts {
+			if got.Value(key{}) == nil {
+				t.Errorf("%s tier, call %d: context not passed on", name, i)
+			}
+		}
+	}
+}
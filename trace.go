@@ -0,0 +1,185 @@
+package relayer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Tracer starts spans timing the stages of handling client messages, such as
+// decoding, checking signatures, saving and querying. It can be backed by
+// OpenTelemetry or any other tracing system. See [Server.Tracer].
+type Tracer interface {
+	// Start starts a span, child of the one in ctx if any, and returns
+	// a context holding the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a stage being timed, ended by End.
+type Span interface {
+	// SetAttributes adds key/value pairs describing the stage.
+	SetAttributes(keysAndValues ...any)
+	// RecordError marks the stage as failed.
+	RecordError(err error)
+	End()
+}
+
+// NoopTracer does nothing, it is the default Tracer.
+var NoopTracer Tracer = noopTracer{}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...any) {}
+func (noopSpan) RecordError(error)    {}
+func (noopSpan) End()                 {}
+
+type tracerKey struct{}
+
+// ContextWithTracer returns a context in which [StartSpan] uses tracer.
+// Server passes such a context to [ContextQuerier], [ContextSaver] and [ContextDeleter].
+func ContextWithTracer(ctx context.Context, tracer Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, tracer)
+}
+
+// StartSpan starts a span with the tracer of ctx, or a no-op one if there's none,
+// so that storages and relays can time stages of their own.
+func StartSpan(ctx context.Context, name string, keysAndValues ...any) (context.Context, Span) {
+	tracer, ok := ctx.Value(tracerKey{}).(Tracer)
+	if !ok {
+		tracer = NoopTracer
+	}
+	ctx, span := tracer.Start(ctx, name)
+	if len(keysAndValues) > 0 {
+		span.SetAttributes(keysAndValues...)
+	}
+	return ctx, span
+}
+
+// RecordingTracer keeps spans in memory, for tests.
+type RecordingTracer struct {
+	mu    sync.Mutex
+	ended []RecordedSpan
+}
+
+// RecordedSpan is a span started by a RecordingTracer.
+type RecordedSpan struct {
+	Name       string
+	Parent     *RecordedSpan // nil for root spans, may not have ended yet
+	Attributes map[string]any
+	Err        error
+
+	StartTime, EndTime time.Time
+
+	tracer *RecordingTracer
+}
+
+type recordedSpanKey struct{}
+
+func (rt *RecordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(recordedSpanKey{}).(*RecordedSpan)
+	span := &RecordedSpan{
+		Name:       name,
+		Parent:     parent,
+		Attributes: make(map[string]any),
+		StartTime:  time.Now(),
+		tracer:     rt,
+	}
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+// Spans returns the spans ended so far, in the order they ended.
+func (rt *RecordingTracer) Spans() []RecordedSpan {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return append([]RecordedSpan(nil), rt.ended...)
+}
+
+// Reset forgets all spans.
+func (rt *RecordingTracer) Reset() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.ended = nil
+}
+
+func (s *RecordedSpan) SetAttributes(keysAndValues ...any) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if key, ok := keysAndValues[i].(string); ok {
+			s.Attributes[key] = keysAndValues[i+1]
+		}
+	}
+}
+
+func (s *RecordedSpan) RecordError(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.Err = err
+}
+
+func (s *RecordedSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.EndTime = time.Now()
+	attributes := make(map[string]any, len(s.Attributes))
+	for k, v := range s.Attributes {
+		attributes[k] = v
+	}
+	ended := *s
+	ended.Attributes = attributes
+	s.tracer.ended = append(s.tracer.ended, ended)
+}
+
+// Path returns the names of the span and its ancestors, from the root, such as "EVENT/SaveEvent".
+func (s RecordedSpan) Path() string {
+	if s.Parent == nil {
+		return s.Name
+	}
+	return s.Parent.Path() + "/" + s.Name
+}
+
+// the storage calls below start a span and use the context-aware
+// method of the storage if there's one.
+
+func queryEvents(ctx context.Context, store Storage, filter *nostr.Filter) ([]nostr.Event, error) {
+	ctx, span := StartSpan(ctx, "QueryEvents", "storage", fmt.Sprintf("%T", store))
+	defer span.End()
+
+	var events []nostr.Event
+	var err error
+	if cq, ok := store.(ContextQuerier); ok {
+		events, err = cq.QueryEventsContext(ctx, filter)
+	} else {
+		events, err = store.QueryEvents(filter)
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.SetAttributes("events", len(events))
+	return events, err
+}
+
+func deleteEvent(ctx context.Context, store Storage, id, pubkey string) error {
+	ctx, span := StartSpan(ctx, "DeleteEvent", "event", id)
+	defer span.End()
+
+	var err error
+	if cd, ok := store.(ContextDeleter); ok {
+		err = cd.DeleteEventContext(ctx, id, pubkey)
+	} else {
+		err = store.DeleteEvent(id, pubkey)
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
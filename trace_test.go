@@ -0,0 +1,142 @@
+package relayer
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer/storage/memory"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestServerTracing(t *testing.T) {
+	tracer := &RecordingTracer{}
+	rl := &testRelay{storage: &tracedStorage{MemoryBackend: &memory.MemoryBackend{}}}
+	srv := NewServer("127.0.0.1:0", rl)
+	srv.Tracer = tracer
+	ready := make(chan struct{})
+	rl.onInitialized = func(*Server) { close(ready) }
+	go srv.Start()
+	<-ready
+	defer srv.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client, err := nostr.RelayConnect(ctx, "ws://"+srv.Addr())
+	if err != nil {
+		t.Fatalf("nostr.RelayConnect: %v", err)
+	}
+	defer client.Close()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	evt := nostr.Event{PubKey: pk, Kind: 1, CreatedAt: time.Now(), Tags: nostr.Tags{}}
+	evt.Sign(sk)
+	if ok, msg := publishEvent(t, srv.Addr(), evt); !ok {
+		t.Fatalf("event not accepted: %s", msg)
+	}
+
+	tracer.Reset()
+	sub := client.Subscribe(ctx, nostr.Filters{{IDs: []string{evt.ID}}})
+	<-sub.Events
+	<-sub.EndOfStoredEvents
+
+	waitSpan(tracer, "REQ")
+	paths := spanPaths(tracer)
+	want := "REQ REQ/QueryEvents REQ/decode"
+	if strings.Join(paths, " ") != want {
+		t.Errorf("REQ spans = %v; want %s", paths, want)
+	}
+	for _, span := range tracer.Spans() {
+		switch span.Name {
+		case "REQ":
+			if span.Attributes["filters"] != 1 {
+				t.Errorf("REQ span attributes = %v", span.Attributes)
+			}
+		case "QueryEvents":
+			if span.Attributes["events"] != 1 || span.Attributes["storage"] != "*relayer.tracedStorage" {
+				t.Errorf("QueryEvents span attributes = %v", span.Attributes)
+			}
+		}
+	}
+
+	tracer.Reset()
+	evt.CreatedAt = evt.CreatedAt.Add(time.Second)
+	evt.Sign(sk)
+	if ok, msg := publishEvent(t, srv.Addr(), evt); !ok {
+		t.Fatalf("event not accepted: %s", msg)
+	}
+	waitSpan(tracer, "EVENT")
+	var eventSpan RecordedSpan
+	for _, span := range tracer.Spans() {
+		if span.Name == "EVENT" {
+			eventSpan = span
+		}
+	}
+	if eventSpan.Attributes["event"] != evt.ID || eventSpan.Attributes["ok"] != true {
+		t.Errorf("EVENT span attributes = %v", eventSpan.Attributes)
+	}
+	for _, path := range []string{
+		"EVENT/decode",
+		"EVENT/verify",
+		"EVENT/AcceptEvent",
+		"EVENT/BeforeSave",
+		"EVENT/SaveEvent",
+		"EVENT/SaveEvent/storage", // started by the storage from the context it was given
+		"EVENT/AfterSave",
+		"EVENT/fanout",
+	} {
+		if !hasSpan(tracer, path) {
+			t.Errorf("no %s span in %v", path, spanPaths(tracer))
+		}
+	}
+}
+
+func TestStartSpanWithoutTracer(t *testing.T) {
+	ctx, span := StartSpan(context.Background(), "anything", "key", "value")
+	span.End()
+	if ctx != context.Background() {
+		t.Error("StartSpan changed the context without a tracer")
+	}
+}
+
+func spanPaths(tracer *RecordingTracer) []string {
+	var paths []string
+	for _, span := range tracer.Spans() {
+		paths = append(paths, span.Path())
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// waitSpan waits for a root span to end, which happens after answering the client.
+func waitSpan(tracer *RecordingTracer, path string) {
+	deadline := time.Now().Add(time.Second)
+	for !hasSpan(tracer, path) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func hasSpan(tracer *RecordingTracer, path string) bool {
+	for _, span := range tracer.Spans() {
+		if span.Path() == path {
+			return true
+		}
+	}
+	return false
+}
+
+type tracedStorage struct {
+	*memory.MemoryBackend
+}
+
+func (s *tracedStorage) SaveEventContext(ctx context.Context, evt *nostr.Event) error {
+	_, span := StartSpan(ctx, "storage")
+	defer span.End()
+	return s.SaveEvent(evt)
+}
+
+func (s *tracedStorage) BeforeSave(*nostr.Event) {}
+func (s *tracedStorage) AfterSave(*nostr.Event)  {}
@@ -2,9 +2,11 @@ package relayer
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/nbd-wtf/go-nostr"
 )
 
@@ -37,6 +39,7 @@ type testRelay struct {
 	onInitialized func(*Server)
 	onShutdown    func(context.Context)
 	acceptEvent   func(*nostr.Event) bool
+	bus           EventBus
 }
 
 func (tr *testRelay) Name() string     { return tr.name }
@@ -68,6 +71,8 @@ func (tr *testRelay) AcceptEvent(e *nostr.Event) bool {
 	return true
 }
 
+func (tr *testRelay) EventBus() EventBus { return tr.bus }
+
 type testStorage struct {
 	init        func() error
 	queryEvents func(*nostr.Filter) ([]nostr.Event, error)
@@ -102,3 +107,35 @@ func (st *testStorage) SaveEvent(e *nostr.Event) error {
 	}
 	return nil
 }
+
+// publishEvent sends evt to the relay at addr and returns its OK answer. It doesn't use
+// nostr.Relay.Publish, which may block when it gets the event both stored and live.
+func publishEvent(t *testing.T, addr string, evt nostr.Event) (ok bool, message string) {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr, nil)
+	if err != nil {
+		t.Fatalf("websocket.Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.WriteJSON([]any{"EVENT", evt}); err != nil {
+		t.Fatalf("failed to send event: %v", err)
+	}
+	for {
+		var msg []json.RawMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("no OK for event %s: %v", evt.ID, err)
+		}
+		var typ, id string
+		json.Unmarshal(msg[0], &typ)
+		if typ != "OK" || len(msg) < 4 {
+			continue
+		}
+		json.Unmarshal(msg[1], &id)
+		json.Unmarshal(msg[2], &ok)
+		json.Unmarshal(msg[3], &message)
+		if id == evt.ID {
+			return ok, message
+		}
+	}
+}
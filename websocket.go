@@ -2,6 +2,7 @@ package relayer
 
 import (
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -13,16 +14,30 @@ type WebSocket struct {
 	// nip42
 	challenge string
 	authed    string
+
+	// set when connecting, for logs and the admin API
+	id          string
+	ip          string
+	connectedAt time.Time
+	log         StructuredLogger
 }
 
 func (ws *WebSocket) WriteJSON(any interface{}) error {
 	ws.mutex.Lock()
 	defer ws.mutex.Unlock()
-	return ws.conn.WriteJSON(any)
+	err := ws.conn.WriteJSON(any)
+	if err != nil {
+		writeFailures.Inc()
+	}
+	return err
 }
 
 func (ws *WebSocket) WriteMessage(t int, b []byte) error {
 	ws.mutex.Lock()
 	defer ws.mutex.Unlock()
-	return ws.conn.WriteMessage(t, b)
+	err := ws.conn.WriteMessage(t, b)
+	if err != nil {
+		writeFailures.Inc()
+	}
+	return err
 }
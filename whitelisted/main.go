@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"log"
+	"net"
 
 	"github.com/fiatjaf/relayer"
 	"github.com/fiatjaf/relayer/storage/postgresql"
@@ -13,34 +17,82 @@ import (
 type Relay struct {
 	PostgresDatabase string   `envconfig:"POSTGRESQL_DATABASE"`
 	Whitelist        []string `envconfig:"WHITELIST"`
+	AdminPubkeys     []string `envconfig:"ADMIN_PUBKEYS"`
+	AdminStateFile   string   `envconfig:"ADMIN_STATE_FILE" default:"admin-state.json"`
 
-	storage *postgresql.PostgresBackend
+	// Owner is whitelisted along with everyone they follow.
+	Owner string `envconfig:"OWNER_PUBKEY"`
+	// Private relays only serve whitelisted pubkeys, authenticated with NIP-42.
+	Private bool `envconfig:"PRIVATE"`
+	// RelayURL is the URL clients connect to, checked in NIP-42 AUTH events.
+	RelayURL string `envconfig:"SERVICE_URL"`
+
+	storage   *postgresql.PostgresBackend
+	admin     *relayer.Admin
+	whitelist *whitelist
 }
 
 func (r *Relay) Name() string {
 	return "WhitelistedRelay"
 }
 
-func (r *Relay) OnInitialized(*relayer.Server) {}
+func (r *Relay) OnInitialized(s *relayer.Server) {
+	// the whitelist lives in the database, which is ready only now
+	wl, err := newWhitelist(r.storage.DB)
+	if err != nil {
+		log.Fatalf("failed to load whitelist: %v", err)
+	}
+	for _, pubkey := range r.Whitelist {
+		if err := wl.add(pubkey); err != nil {
+			log.Fatalf("failed to add %s to whitelist: %v", pubkey, err)
+		}
+	}
+	if r.Owner != "" {
+		contacts, err := r.storage.QueryEvents(&nostr.Filter{
+			Authors: []string{r.Owner},
+			Kinds:   []int{3},
+			Limit:   1,
+		})
+		if err != nil {
+			log.Fatalf("failed to query contact list of owner: %v", err)
+		}
+		if len(contacts) > 0 {
+			if err := wl.follow(&contacts[0]); err != nil {
+				log.Fatalf("failed to follow contact list of owner: %v", err)
+			}
+		}
+	}
+	r.whitelist = wl
+}
 
 func (r *Relay) Storage() relayer.Storage {
-	return r.storage
+	return store{r.storage, r}
+}
+
+// store tells the relay about the events saved.
+type store struct {
+	*postgresql.PostgresBackend
+	relay *Relay
+}
+
+func (s store) AfterSave(evt *nostr.Event) {
+	s.PostgresBackend.AfterSave(evt)
+	s.relay.afterSave(evt)
 }
 
 func (r *Relay) Init() error {
+	if r.Owner != "" && !isPubkey(r.Owner) {
+		return errors.New("OWNER_PUBKEY must be a hex pubkey")
+	}
+	if r.Private && r.RelayURL == "" {
+		return errors.New("SERVICE_URL is required for private relays")
+	}
 	return nil
 }
 
 func (r *Relay) AcceptEvent(evt *nostr.Event) bool {
 	// disallow anything from non-authorized pubkeys
-	found := false
-	for _, pubkey := range r.Whitelist {
-		if pubkey == evt.PubKey {
-			found = true
-			break
-		}
-	}
-	if !found {
+	if !r.isAllowed(evt.PubKey) {
 		return false
 	}
 
@@ -53,14 +105,138 @@ func (r *Relay) AcceptEvent(evt *nostr.Event) bool {
 	return true
 }
 
+// afterSave follows the contact list of the owner once it is saved:
+// whoever the owner follows can write.
+func (r *Relay) afterSave(evt *nostr.Event) {
+	if evt.Kind == 3 && evt.PubKey == r.Owner {
+		if err := r.whitelist.follow(evt); err != nil {
+			log.Printf("failed to follow contact list of owner: %v", err)
+		}
+	}
+}
+
+func (r *Relay) isAllowed(pubkey string) bool {
+	// bans win over the whitelist, for reads too
+	if r.admin != nil && r.admin.IsBanned(pubkey) {
+		return false
+	}
+	return pubkey == r.Owner ||
+		r.whitelist.contains(pubkey) ||
+		r.admin != nil && r.admin.IsAllowed(pubkey)
+}
+
+// authRelay is the relay with a SERVICE_URL, which clients can authenticate
+// to with NIP-42. Without one AUTH events can't be checked, so the relay
+// isn't a relayer.Auther at all.
+type authRelay struct{ *Relay }
+
+// ServiceURL implements relayer.Auther, so clients can authenticate to
+// read from private relays.
+func (r authRelay) ServiceURL() string {
+	return r.RelayURL
+}
+
+// serverRelay returns r as given to relayer.NewServer.
+func (r *Relay) serverRelay() relayer.Relay {
+	if r.RelayURL == "" {
+		return r
+	}
+	return authRelay{r}
+}
+
+// AcceptReq implements relayer.ReqAccepter, only serving whitelisted pubkeys
+// on private relays.
+func (r *Relay) AcceptReq(ctx context.Context, id string, filters nostr.Filters, authedPubkey string) bool {
+	return !r.Private || authedPubkey != "" && r.isAllowed(authedPubkey)
+}
+
+// ManagementMethods implements relayer.ManagementHandler.
+func (r *Relay) ManagementMethods() []string {
+	return nil
+}
+
+// HandleManagement implements relayer.ManagementHandler, keeping the
+// whitelist in sync with the pubkeys allowed and banned through NIP-86.
+func (r *Relay) HandleManagement(ctx context.Context, pubkey, method string, params []json.RawMessage) (any, bool, error) {
+	var target string
+	if len(params) > 0 {
+		json.Unmarshal(params[0], &target)
+	}
+	switch method {
+	case "allowpubkey":
+		// also allowed by the relayer.Admin, lifting any ban, handled next
+		if !isPubkey(target) {
+			return nil, false, errors.New("invalid pubkey")
+		}
+		return nil, false, r.whitelist.add(target)
+	case "banpubkey":
+		// also banned by the relayer.Admin, handled next
+		if isPubkey(target) {
+			return nil, false, r.whitelist.remove(target)
+		}
+	case "listallowedpubkeys":
+		return r.allowedPubkeys(), true, nil
+	}
+	return nil, false, nil
+}
+
+// allowedPubkeys lists the whitelist along with the pubkeys allowed only
+// through the admin API, with the reason given.
+func (r *Relay) allowedPubkeys() []whitelisted {
+	list := r.whitelist.list()
+	if r.admin == nil {
+		return list
+	}
+	state := r.admin.State()
+	for _, pubkey := range state.AllowedPubkeys {
+		if !r.whitelist.contains(pubkey) {
+			list = append(list, whitelisted{pubkey, state.Reasons[pubkey]})
+		}
+	}
+	sortWhitelisted(list)
+	return list
+}
+
+func isPubkey(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
+
 func main() {
 	r := Relay{}
 	if err := envconfig.Process("", &r); err != nil {
 		log.Fatalf("failed to read from env: %v", err)
 		return
 	}
+	var settings relayer.Settings
+	if err := envconfig.Process("", &settings); err != nil {
+		log.Fatalf("failed to read from env: %v", err)
+		return
+	}
 	r.storage = &postgresql.PostgresBackend{DatabaseURL: r.PostgresDatabase}
-	if err := relayer.Start(&r); err != nil {
+
+	srv := relayer.NewServer(net.JoinHostPort(settings.Host, settings.Port), r.serverRelay())
+	srv.MetricsPath = settings.MetricsPath
+	proxies, err := relayer.ParseTrustedProxies(settings.TrustedProxies)
+	if err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+		return
+	}
+	srv.TrustedProxies = proxies
+	srv.SetDebug(settings.Debug)
+	if len(r.AdminPubkeys) > 0 || r.Owner != "" {
+		// the whitelist can be managed at runtime, through NIP-86 or the admin API
+		pubkeys := r.AdminPubkeys
+		if r.Owner != "" {
+			pubkeys = append(pubkeys, r.Owner)
+		}
+		r.admin = &relayer.Admin{
+			Pubkeys: pubkeys,
+			Store:   relayer.FileStateStore{Path: r.AdminStateFile},
+		}
+		srv.Admin = r.admin
+	}
+	if err := srv.Start(); err != nil {
 		log.Fatalf("server terminated: %v", err)
 	}
 }
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/relayer"
+	"github.com/fiatjaf/relayer/storage/postgresql"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// tests needing a database use the one in POSTGRESQL_TEST_DATABASE,
+// whose event and whitelist tables are emptied along the way.
+func testStorage(t *testing.T) *postgresql.PostgresBackend {
+	url := os.Getenv("POSTGRESQL_TEST_DATABASE")
+	if url == "" {
+		t.Skip("POSTGRESQL_TEST_DATABASE not set")
+	}
+	b := &postgresql.PostgresBackend{DatabaseURL: url}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	b.DB.Exec("DROP TABLE IF EXISTS whitelist")
+	if _, err := b.DB.Exec("TRUNCATE event"); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestWhitelist(t *testing.T) {
+	b := testStorage(t)
+	wl, err := newWhitelist(b.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob, carol := pubkey(t), pubkey(t), pubkey(t)
+
+	if err := wl.add(alice); err != nil {
+		t.Fatal(err)
+	}
+	if !wl.contains(alice) || wl.contains(bob) {
+		t.Errorf("added alice: alice %v, bob %v", wl.contains(alice), wl.contains(bob))
+	}
+
+	contacts := nostr.Event{Kind: 3, CreatedAt: time.Now(), Tags: nostr.Tags{{"p", alice}, {"p", bob}, {"p", "invalid"}}}
+	if err := wl.follow(&contacts); err != nil {
+		t.Fatal(err)
+	}
+	older := nostr.Event{Kind: 3, CreatedAt: contacts.CreatedAt.Add(-time.Hour), Tags: nostr.Tags{{"p", carol}}}
+	if err := wl.follow(&older); err != nil {
+		t.Fatal(err)
+	}
+	if !wl.contains(bob) || wl.contains(carol) {
+		t.Errorf("followed bob before carol: bob %v, carol %v", wl.contains(bob), wl.contains(carol))
+	}
+
+	// followed pubkeys stay until unfollowed
+	if err := wl.remove(alice); err != nil {
+		t.Fatal(err)
+	}
+	if !wl.contains(alice) {
+		t.Error("removed alice though followed")
+	}
+	unfollowed := nostr.Event{Kind: 3, CreatedAt: contacts.CreatedAt.Add(time.Hour), Tags: nostr.Tags{{"p", carol}}}
+	if err := wl.follow(&unfollowed); err != nil {
+		t.Fatal(err)
+	}
+	if wl.contains(alice) || wl.contains(bob) || !wl.contains(carol) {
+		t.Errorf("followed only carol: alice %v, bob %v, carol %v", wl.contains(alice), wl.contains(bob), wl.contains(carol))
+	}
+
+	// and all of it is kept in the database
+	if err := wl.add(bob); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := newWhitelist(b.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reloaded.list(), wl.list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded %v, want %v", got, want)
+	}
+}
+
+// testRelay tells when the relay is initialized.
+type testRelay struct {
+	*Relay
+	ready chan struct{}
+}
+
+func (tr testRelay) OnInitialized(s *relayer.Server) {
+	tr.Relay.OnInitialized(s)
+	close(tr.ready)
+}
+
+func TestRelay(t *testing.T) {
+	b := testStorage(t)
+	ownerKey := nostr.GeneratePrivateKey()
+	owner, _ := nostr.GetPublicKey(ownerKey)
+	r := &Relay{Owner: owner, storage: b}
+	r.admin = &relayer.Admin{Pubkeys: []string{owner}}
+	tr := testRelay{r, make(chan struct{})}
+	srv := relayer.NewServer("127.0.0.1:0", tr)
+	srv.Admin = r.admin
+	go srv.Start()
+	select {
+	case <-tr.ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server took too long to start up")
+	}
+	defer srv.Shutdown(context.Background())
+	ctx := context.Background()
+
+	// the contact list of the owner is only followed once saved
+	friend := pubkey(t)
+	contacts := nostr.Event{PubKey: owner, Kind: 3, CreatedAt: time.Now(), Tags: nostr.Tags{{"p", friend}}}
+	contacts.Sign(ownerKey)
+	if !r.AcceptEvent(&contacts) {
+		t.Fatal("contact list of the owner rejected")
+	}
+	if r.isAllowed(friend) {
+		t.Error("followed before the contact list is saved")
+	}
+	store := r.Storage()
+	if err := store.SaveEvent(&contacts); err != nil {
+		t.Fatal(err)
+	}
+	store.(relayer.AdvancedSaver).AfterSave(&contacts)
+	if !r.isAllowed(friend) {
+		t.Error("not followed once the contact list is saved")
+	}
+
+	// banned pubkeys can't read even if followed
+	r.Private = true
+	r.admin.BanPubkey(friend, "spam")
+	if r.AcceptReq(ctx, "sub", nil, friend) {
+		t.Error("banned but followed pubkey can read")
+	}
+	r.admin.AllowPubkey(friend, "")
+	if !r.AcceptReq(ctx, "sub", nil, friend) {
+		t.Error("followed pubkey can't read once unbanned")
+	}
+
+	// allowpubkey is passed on to the admin, which lifts bans
+	banned := pubkey(t)
+	r.admin.BanPubkey(banned, "")
+	if _, handled, err := r.HandleManagement(ctx, owner, "allowpubkey", params(t, banned)); handled || err != nil {
+		t.Fatalf("allowpubkey handled %v, %v", handled, err)
+	}
+	if !r.whitelist.contains(banned) {
+		t.Error("allowpubkey didn't add to the whitelist")
+	}
+
+	// and pubkeys allowed by the admin are listed
+	allowed := pubkey(t)
+	r.admin.AllowPubkey(allowed, "paid")
+	list, handled, err := r.HandleManagement(ctx, owner, "listallowedpubkeys", nil)
+	if !handled || err != nil {
+		t.Fatalf("listallowedpubkeys handled %v, %v", handled, err)
+	}
+	want := []whitelisted{{banned, sourceManual}, {friend, sourceFollows}, {allowed, "paid"}}
+	sortWhitelisted(want)
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("listallowedpubkeys = %v, want %v", list, want)
+	}
+}
+
+func TestAuther(t *testing.T) {
+	if _, ok := (&Relay{}).serverRelay().(relayer.Auther); ok {
+		t.Error("relay without SERVICE_URL is an Auther")
+	}
+	if auther, ok := (&Relay{RelayURL: "wss://relay.example.com"}).serverRelay().(relayer.Auther); !ok || auther.ServiceURL() != "wss://relay.example.com" {
+		t.Error("relay with SERVICE_URL isn't an Auther")
+	}
+}
+
+func pubkey(t *testing.T) string {
+	pk, err := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pk
+}
+
+func params(t *testing.T, values ...any) []json.RawMessage {
+	var raw []json.RawMessage
+	for _, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw = append(raw, data)
+	}
+	return raw
+}
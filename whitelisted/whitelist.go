@@ -0,0 +1,169 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// sources of whitelisted pubkeys
+const (
+	// sourceManual pubkeys come from WHITELIST or were added by an admin.
+	sourceManual = "manual"
+	// sourceFollows pubkeys are followed by the owner.
+	sourceFollows = "follows"
+)
+
+// whitelist is the set of pubkeys which can use the relay. It is kept in
+// the database so it can be changed at runtime, and looked up in memory.
+type whitelist struct {
+	db *sqlx.DB
+
+	mu      sync.RWMutex
+	manual  map[string]struct{}
+	follows map[string]struct{}
+	// created_at of the contact list follows was set from
+	followsAt time.Time
+}
+
+func newWhitelist(db *sqlx.DB) (*whitelist, error) {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS whitelist (
+  pubkey text NOT NULL,
+  source text NOT NULL,
+  PRIMARY KEY (pubkey, source)
+);
+    `)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &whitelist{
+		db:      db,
+		manual:  make(map[string]struct{}),
+		follows: make(map[string]struct{}),
+	}
+	var rows []struct {
+		Pubkey string `json:"pubkey"`
+		Source string `json:"source"`
+	}
+	if err := db.Select(&rows, `SELECT pubkey, source FROM whitelist`); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		switch row.Source {
+		case sourceManual:
+			w.manual[row.Pubkey] = struct{}{}
+		case sourceFollows:
+			w.follows[row.Pubkey] = struct{}{}
+		}
+	}
+	return w, nil
+}
+
+func (w *whitelist) contains(pubkey string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	_, manual := w.manual[pubkey]
+	_, follows := w.follows[pubkey]
+	return manual || follows
+}
+
+// add whitelists pubkey until it is removed.
+func (w *whitelist) add(pubkey string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.db.Exec(`INSERT INTO whitelist (pubkey, source) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`, pubkey, sourceManual); err != nil {
+		return err
+	}
+	w.manual[pubkey] = struct{}{}
+	return nil
+}
+
+// remove removes pubkey added with add. Pubkeys followed by the owner
+// stay until the owner unfollows them.
+func (w *whitelist) remove(pubkey string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.db.Exec(`DELETE FROM whitelist WHERE pubkey = $1 AND source = $2`,
+		pubkey, sourceManual); err != nil {
+		return err
+	}
+	delete(w.manual, pubkey)
+	return nil
+}
+
+// follow replaces the followed pubkeys with the "p" tags of contacts,
+// unless it is older than the contact list they were taken from.
+func (w *whitelist) follow(contacts *nostr.Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !contacts.CreatedAt.After(w.followsAt) {
+		return nil
+	}
+
+	follows := make(map[string]struct{})
+	for _, tag := range contacts.Tags {
+		if len(tag) >= 2 && tag[0] == "p" && isPubkey(tag[1]) {
+			follows[tag[1]] = struct{}{}
+		}
+	}
+
+	tx, err := w.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM whitelist WHERE source = $1`, sourceFollows); err != nil {
+		return err
+	}
+	for pubkey := range follows {
+		if _, err := tx.Exec(`INSERT INTO whitelist (pubkey, source) VALUES ($1, $2)`,
+			pubkey, sourceFollows); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	w.follows = follows
+	w.followsAt = contacts.CreatedAt
+	return nil
+}
+
+// list returns the whitelisted pubkeys, sorted, with their sources.
+func (w *whitelist) list() []whitelisted {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var list []whitelisted
+	for pubkey := range w.manual {
+		list = append(list, whitelisted{pubkey, sourceManual})
+	}
+	for pubkey := range w.follows {
+		list = append(list, whitelisted{pubkey, sourceFollows})
+	}
+	sortWhitelisted(list)
+	return list
+}
+
+// sortWhitelisted sorts list by pubkey, then by source.
+func sortWhitelisted(list []whitelisted) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Pubkey == list[j].Pubkey {
+			return list[i].Reason < list[j].Reason
+		}
+		return list[i].Pubkey < list[j].Pubkey
+	})
+}
+
+// whitelisted is an item of the NIP-86 listallowedpubkeys result, with
+// the source as reason.
+type whitelisted struct {
+	Pubkey string `json:"pubkey"`
+	Reason string `json:"reason"`
+}